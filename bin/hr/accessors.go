@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	stdjson "encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+// Other implementations do not always stick to the types of
+// penlog(7), e.g. "line" is an integer or "tags" a string. The
+// accessors below accept such values where the meaning is obvious;
+// castField remains for places where a wrong type is an error.
+
+// scalarString converts strings, numbers, and booleans to a string.
+func scalarString(raw interface{}) (string, bool) {
+	switch v := raw.(type) {
+	case string:
+		return v, true
+	case stdjson.Number:
+		return v.String(), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(v), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// fieldString returns a string field, tolerating scalar values.
+func fieldString(data map[string]interface{}, field string) (string, bool) {
+	raw, ok := data[field]
+	if !ok {
+		return "", false
+	}
+	return scalarString(raw)
+}
+
+// fieldStrings returns a list field. A single string is split at
+// commas, a scalar becomes a list with one element.
+func fieldStrings(data map[string]interface{}, field string) ([]string, bool) {
+	raw, ok := data[field]
+	if !ok {
+		return nil, false
+	}
+	switch v := raw.(type) {
+	case []interface{}:
+		res := make([]string, 0, len(v))
+		for _, elem := range v {
+			s, ok := scalarString(elem)
+			if !ok {
+				return nil, false
+			}
+			res = append(res, s)
+		}
+		return res, true
+	case string:
+		return removeEmpy(strings.Split(v, ",")), true
+	}
+	if s, ok := scalarString(raw); ok {
+		return []string{s}, true
+	}
+	return nil, false
+}
+
+// numberOf returns numeric values as json.Number. Strings are not
+// accepted; "priority": "3" is no priority.
+func numberOf(raw interface{}) (stdjson.Number, bool) {
+	switch v := raw.(type) {
+	case stdjson.Number:
+		return v, true
+	case float64:
+		return stdjson.Number(strconv.FormatFloat(v, 'f', -1, 64)), true
+	case int:
+		return stdjson.Number(strconv.Itoa(v)), true
+	case rawJSON:
+		if _, err := strconv.ParseFloat(string(v), 64); err == nil {
+			return stdjson.Number(v), true
+		}
+	}
+	return "", false
+}
+
+// fieldNumber returns a numeric field without losing precision.
+func fieldNumber(data map[string]interface{}, field string) (stdjson.Number, bool) {
+	return numberOf(data[field])
+}
+
+// fieldInt64 returns an integer field. Floats are accepted if they are
+// integral, e.g. 6.0.
+func fieldInt64(data map[string]interface{}, field string) (int64, bool) {
+	n, ok := fieldNumber(data, field)
+	if !ok {
+		return 0, false
+	}
+	if i, err := n.Int64(); err == nil {
+		return i, true
+	}
+	f, err := n.Float64()
+	if err != nil || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+func fieldPrio(data map[string]interface{}) (penlog.Prio, bool) {
+	p, ok := fieldInt64(data, "priority")
+	return penlog.Prio(p), ok
+}
+
+// normalizeRecord converts the well known fields to the types of the
+// specification such that the formatter can render them. Fields which
+// cannot be converted are left alone.
+func normalizeRecord(data map[string]interface{}) {
+	for _, field := range []string{"component", "type", "data", "id", "line", "host"} {
+		if _, ok := data[field].(string); ok {
+			continue
+		}
+		if s, ok := fieldString(data, field); ok {
+			data[field] = s
+		}
+	}
+	if _, ok := data["tags"].([]interface{}); !ok {
+		if tags, ok := fieldStrings(data, "tags"); ok {
+			list := make([]interface{}, 0, len(tags))
+			for _, tag := range tags {
+				list = append(list, tag)
+			}
+			data["tags"] = list
+		}
+	}
+	// The formatter only understands priorities of type float64.
+	if _, ok := data["priority"].(stdjson.Number); ok {
+		if p, ok := fieldPrio(data); ok {
+			data["priority"] = float64(p)
+		}
+	}
+}
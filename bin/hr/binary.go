@@ -0,0 +1,514 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+const (
+	inputFormatAuto    = "auto"
+	inputFormatJSON    = "json"
+	inputFormatCBOR    = "cbor"
+	inputFormatMsgpack = "msgpack"
+)
+
+const (
+	// Records larger than this are considered as corrupt framing.
+	binaryMaxRecordSize = 64 << 20
+	binaryMaxDepth      = 100
+	// Binary input is only detected if the first record fits into
+	// this many bytes.
+	binarySniffSize = 64 << 10
+)
+
+var errBinaryFormat = errors.New("invalid binary record")
+
+// detectInputFormat looks at the first record of the stream. Binary
+// records start with a four byte length prefix followed by a map; the
+// first record must decode completely, such that text which happens to
+// start with a plausible prefix is still read as JSON.
+func detectInputFormat(r *bufio.Reader) string {
+	head, _ := r.Peek(4)
+	if len(head) < 4 {
+		return inputFormatJSON
+	}
+	size := binary.BigEndian.Uint32(head)
+	if size == 0 || size > binarySniffSize-4 {
+		return inputFormatJSON
+	}
+	frame, err := r.Peek(4 + int(size))
+	if err != nil {
+		return inputFormatJSON
+	}
+	for _, format := range []string{inputFormatCBOR, inputFormatMsgpack} {
+		var (
+			val interface{}
+			err error
+			d   = binaryDecoder{buf: frame[4:]}
+		)
+		switch format {
+		case inputFormatCBOR:
+			val, err = d.decodeCBOR(0)
+		case inputFormatMsgpack:
+			val, err = d.decodeMsgpack(0)
+		}
+		if _, ok := val.(map[string]interface{}); ok && err == nil && d.pos == len(d.buf) {
+			return format
+		}
+	}
+	return inputFormatJSON
+}
+
+func checkInputFormat(format string) error {
+	switch format {
+	case inputFormatAuto, inputFormatJSON, inputFormatCBOR, inputFormatMsgpack:
+		return nil
+	}
+	if isTsharkFormat(format) {
+		_, err := parseTsharkFormat(format)
+		return err
+	}
+	return fmt.Errorf("invalid input format: %s", format)
+}
+
+// newInputReader converts binary and foreign input formats into JSON lines, such
+// that the rest of hr only needs to deal with JSON.
+func newInputReader(r io.Reader, format string) io.Reader {
+	br := bufio.NewReaderSize(r, binarySniffSize)
+	if format == inputFormatAuto {
+		format = detectInputFormat(br)
+	}
+	if format == inputFormatJSON {
+		return br
+	}
+	if isTsharkFormat(format) {
+		// The format is validated by checkInputFormat.
+		fields, _ := parseTsharkFormat(format)
+		return &tsharkReader{r: br, fields: fields}
+	}
+	return &binaryReader{r: br, format: format}
+}
+
+type binaryReader struct {
+	r      *bufio.Reader
+	format string
+	buf    bytes.Buffer
+	err    error
+}
+
+func (b *binaryReader) Read(p []byte) (int, error) {
+	for b.buf.Len() == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		b.next()
+	}
+	return b.buf.Read(p)
+}
+
+func (b *binaryReader) next() {
+	var prefix [4]byte
+	if _, err := io.ReadFull(b.r, prefix[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			b.fail(fmt.Errorf("%s: truncated length prefix", b.format))
+			return
+		}
+		b.err = err
+		return
+	}
+	size := binary.BigEndian.Uint32(prefix[:])
+	if size > binaryMaxRecordSize {
+		b.fail(fmt.Errorf("%s: record size %d exceeds limit", b.format, size))
+		return
+	}
+	raw := make([]byte, size)
+	if _, err := io.ReadFull(b.r, raw); err != nil {
+		b.fail(fmt.Errorf("%s: truncated record", b.format))
+		return
+	}
+
+	var (
+		val interface{}
+		err error
+		d   = binaryDecoder{buf: raw}
+	)
+	switch b.format {
+	case inputFormatCBOR:
+		val, err = d.decodeCBOR(0)
+	case inputFormatMsgpack:
+		val, err = d.decodeMsgpack(0)
+	}
+	if err == nil && d.pos != len(raw) {
+		err = errBinaryFormat
+	}
+	if err != nil {
+		// The framing is still intact; continue with the next record.
+		b.emit(createErrorRecord(fmt.Sprintf("%s: %s", b.format, err)))
+		return
+	}
+	if _, ok := val.(map[string]interface{}); !ok {
+		b.emit(createErrorRecord(fmt.Sprintf("%s: record is not a map", b.format)))
+		return
+	}
+	b.emit(val)
+}
+
+// fail emits an error record and stops reading, since the framing
+// cannot be recovered.
+func (b *binaryReader) fail(err error) {
+	b.emit(createErrorRecord(err.Error()))
+	b.err = io.EOF
+}
+
+func (b *binaryReader) emit(val interface{}) {
+	line, err := json.Marshal(val)
+	if err != nil {
+		line, _ = json.Marshal(createErrorRecord(err.Error()))
+	}
+	b.buf.Write(line)
+	b.buf.WriteByte('\n')
+}
+
+type binaryDecoder struct {
+	buf []byte
+	pos int
+}
+
+func (d *binaryDecoder) read(n uint64) ([]byte, error) {
+	if n > uint64(len(d.buf)-d.pos) {
+		return nil, errBinaryFormat
+	}
+	b := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+func (d *binaryDecoder) readByte() (byte, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (d *binaryDecoder) readUint(n int) (uint64, error) {
+	b, err := d.read(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, x := range b {
+		v = v<<8 | uint64(x)
+	}
+	return v, nil
+}
+
+// Integers are decoded as json.Number such that 64-bit values are
+// not rounded.
+func uintNumber(v uint64) stdjson.Number {
+	return stdjson.Number(strconv.FormatUint(v, 10))
+}
+
+func intNumber(v int64) stdjson.Number {
+	return stdjson.Number(strconv.FormatInt(v, 10))
+}
+
+func mapKey(k interface{}) string {
+	if s, ok := k.(string); ok {
+		return s
+	}
+	return fmt.Sprint(k)
+}
+
+// cborArg decodes the argument of the initial byte; see RFC 8949, 3.
+func (d *binaryDecoder) cborArg(info byte) (uint64, bool, error) {
+	switch {
+	case info < 24:
+		return uint64(info), false, nil
+	case info <= 27:
+		v, err := d.readUint(1 << (info - 24))
+		return v, false, err
+	case info == 31:
+		return 0, true, nil
+	}
+	return 0, false, errBinaryFormat
+}
+
+func (d *binaryDecoder) isBreak() bool {
+	if d.pos < len(d.buf) && d.buf[d.pos] == 0xff {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+func (d *binaryDecoder) decodeCBOR(depth int) (interface{}, error) {
+	if depth > binaryMaxDepth {
+		return nil, errBinaryFormat
+	}
+	initial, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	major, info := initial>>5, initial&0x1f
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 25:
+			v, err := d.readUint(2)
+			return halfToFloat(uint16(v)), err
+		case 26:
+			v, err := d.readUint(4)
+			return float64(math.Float32frombits(uint32(v))), err
+		case 27:
+			v, err := d.readUint(8)
+			return math.Float64frombits(v), err
+		}
+		if info < 24 {
+			return float64(info), nil
+		}
+		return nil, errBinaryFormat
+	}
+
+	arg, indefinite, err := d.cborArg(info)
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		return uintNumber(arg), nil
+	case 1:
+		// -1 - arg does not fit into int64 for large arguments.
+		n := new(big.Int).SetUint64(arg)
+		return stdjson.Number(n.Neg(n.Add(n, big.NewInt(1))).String()), nil
+	case 2, 3:
+		var s []byte
+		if indefinite {
+			// Chunks are definite strings of the same major type.
+			for !d.isBreak() {
+				b, err := d.readByte()
+				if err != nil {
+					return nil, err
+				}
+				n, chunked, err := d.cborArg(b & 0x1f)
+				if err != nil || chunked || b>>5 != major {
+					return nil, errBinaryFormat
+				}
+				chunk, err := d.read(n)
+				if err != nil {
+					return nil, err
+				}
+				s = append(s, chunk...)
+			}
+		} else if s, err = d.read(arg); err != nil {
+			return nil, err
+		}
+		if major == 2 {
+			if depth == 0 {
+				return nil, errBinaryFormat
+			}
+			return base64.StdEncoding.EncodeToString(s), nil
+		}
+		return string(s), nil
+	case 4:
+		res := []interface{}{}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			if indefinite && d.isBreak() {
+				break
+			}
+			v, err := d.decodeCBOR(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, v)
+		}
+		return res, nil
+	case 5:
+		res := make(map[string]interface{})
+		for i := uint64(0); indefinite || i < arg; i++ {
+			if indefinite && d.isBreak() {
+				break
+			}
+			k, err := d.decodeCBOR(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.decodeCBOR(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			res[mapKey(k)] = v
+		}
+		return res, nil
+	case 6:
+		// Tags carry no information which JSON can express.
+		return d.decodeCBOR(depth + 1)
+	}
+	return nil, errBinaryFormat
+}
+
+func halfToFloat(h uint16) float64 {
+	var (
+		exp  = int(h>>10) & 0x1f
+		mant = float64(h & 0x3ff)
+		val  float64
+	)
+	switch exp {
+	case 0:
+		val = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			val = math.Inf(1)
+		} else {
+			val = math.NaN()
+		}
+	default:
+		val = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -val
+	}
+	return val
+}
+
+func (d *binaryDecoder) msgpackArray(n uint64, depth int) (interface{}, error) {
+	res := []interface{}{}
+	for i := uint64(0); i < n; i++ {
+		v, err := d.decodeMsgpack(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+func (d *binaryDecoder) msgpackMap(n uint64, depth int) (interface{}, error) {
+	res := make(map[string]interface{})
+	for i := uint64(0); i < n; i++ {
+		k, err := d.decodeMsgpack(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decodeMsgpack(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		res[mapKey(k)] = v
+	}
+	return res, nil
+}
+
+func (d *binaryDecoder) msgpackLen(n int) (uint64, error) {
+	return d.readUint(n)
+}
+
+func (d *binaryDecoder) decodeMsgpack(depth int) (interface{}, error) {
+	if depth > binaryMaxDepth {
+		return nil, errBinaryFormat
+	}
+	b, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return uintNumber(uint64(b)), nil
+	case b <= 0x8f:
+		return d.msgpackMap(uint64(b&0x0f), depth)
+	case b <= 0x9f:
+		return d.msgpackArray(uint64(b&0x0f), depth)
+	case b <= 0xbf:
+		s, err := d.read(uint64(b & 0x1f))
+		return string(s), err
+	case b >= 0xe0:
+		return intNumber(int64(int8(b))), nil
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6, 0xd9, 0xda, 0xdb:
+		sizes := map[byte]int{0xc4: 1, 0xc5: 2, 0xc6: 4, 0xd9: 1, 0xda: 2, 0xdb: 4}
+		n, err := d.msgpackLen(sizes[b])
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.read(n)
+		if err != nil {
+			return nil, err
+		}
+		if b <= 0xc6 {
+			return base64.StdEncoding.EncodeToString(s), nil
+		}
+		return string(s), nil
+	case 0xc7, 0xc8, 0xc9, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		var n uint64
+		switch b {
+		case 0xc7, 0xc8, 0xc9:
+			if n, err = d.msgpackLen(1 << (b - 0xc7)); err != nil {
+				return nil, err
+			}
+		default:
+			n = 1 << (b - 0xd4)
+		}
+		// The extension type is dropped; the payload is kept.
+		if _, err := d.read(1); err != nil {
+			return nil, err
+		}
+		s, err := d.read(n)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString(s), nil
+	case 0xca:
+		v, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := d.readUint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := d.readUint(1 << (b - 0xcc))
+		return uintNumber(v), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n := 1 << (b - 0xd0)
+		v, err := d.readUint(n)
+		// Sign extend.
+		shift := uint(64 - 8*n)
+		return intNumber(int64(v<<shift) >> shift), err
+	case 0xdc, 0xdd:
+		n, err := d.msgpackLen(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.msgpackArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.msgpackLen(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.msgpackMap(n, depth)
+	}
+	return nil, errBinaryFormat
+}
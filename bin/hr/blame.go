@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Fraunhofer-AISEC/penlog/filter"
+)
+
+type blameRecord struct {
+	ts        time.Time
+	component string
+	msgType   string
+	payload   string
+}
+
+func (r *blameRecord) action() string {
+	return fmt.Sprintf("%s [%s]", r.component, r.msgType)
+}
+
+type blameAction struct {
+	name  string
+	count int
+	// The most recent payload of this action before the error.
+	last string
+}
+
+type blameEntry struct {
+	error   *blameRecord
+	actions []*blameAction
+}
+
+// blamer collects, for every error record, the records of other
+// components which were logged in the preceding window. The input is
+// expected to be mostly chronological; the window is trimmed by the
+// latest timestamp seen so far.
+type blamer struct {
+	window  time.Duration
+	recent  []*blameRecord
+	latest  time.Time
+	entries []*blameEntry
+	// Number of errors each action preceded, for the summary.
+	preceded map[string]int
+}
+
+func newBlamer(window time.Duration) *blamer {
+	return &blamer{
+		window:   window,
+		preceded: make(map[string]int),
+	}
+}
+
+func (b *blamer) add(data map[string]interface{}) {
+	raw, err := castField(data, "timestamp")
+	if err != nil {
+		return
+	}
+	ts, err := filter.ParseTimestamp(raw)
+	if err != nil {
+		return
+	}
+	comp, _ := fieldString(data, "component")
+	msgType, _ := fieldString(data, "type")
+	payload, _ := fieldString(data, "data")
+	rec := &blameRecord{
+		ts:        ts,
+		component: comp,
+		msgType:   msgType,
+		payload:   payload,
+	}
+
+	if ts.After(b.latest) {
+		b.latest = ts
+	}
+	start := b.latest.Add(-b.window)
+	i := 0
+	for i < len(b.recent) && b.recent[i].ts.Before(start) {
+		i++
+	}
+	b.recent = b.recent[i:]
+
+	if isErrorRecord(data) {
+		b.blame(rec)
+	}
+	b.recent = append(b.recent, rec)
+}
+
+func (b *blamer) blame(rec *blameRecord) {
+	var (
+		start   = rec.ts.Add(-b.window)
+		actions = make(map[string]*blameAction)
+	)
+	for _, r := range b.recent {
+		if r.component == rec.component || r.ts.Before(start) || r.ts.After(rec.ts) {
+			continue
+		}
+		name := r.action()
+		a, ok := actions[name]
+		if !ok {
+			a = &blameAction{name: name}
+			actions[name] = a
+		}
+		a.count++
+		a.last = r.payload
+	}
+
+	entry := &blameEntry{error: rec}
+	for name, a := range actions {
+		entry.actions = append(entry.actions, a)
+		b.preceded[name]++
+	}
+	sort.Slice(entry.actions, func(i, j int) bool {
+		if entry.actions[i].count != entry.actions[j].count {
+			return entry.actions[i].count > entry.actions[j].count
+		}
+		return entry.actions[i].name < entry.actions[j].name
+	})
+	b.entries = append(b.entries, entry)
+}
+
+func (b *blamer) read(r io.Reader) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var data map[string]interface{}
+			if err := json.Unmarshal(line, &data); err == nil {
+				b.add(data)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func blameLine(s string) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	if r := []rune(s); len(r) > 60 {
+		s = string(r[:59]) + "…"
+	}
+	return s
+}
+
+func (b *blamer) write(w io.Writer) {
+	for _, e := range b.entries {
+		fmt.Fprintf(w, "%s %s: %s\n", e.error.ts.Format("2006-01-02T15:04:05.000"), e.error.action(), blameLine(e.error.payload))
+		if len(e.actions) == 0 {
+			fmt.Fprintf(w, "  no other components within %s\n", b.window)
+			continue
+		}
+		for _, a := range e.actions {
+			fmt.Fprintf(w, "  %6d  %s: %s\n", a.count, a.name, blameLine(a.last))
+		}
+	}
+
+	counts := make([]statsCount, 0, len(b.preceded))
+	for name, n := range b.preceded {
+		counts = append(counts, statsCount{Name: name, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	writeCounts(w, fmt.Sprintf("actions preceding %d errors within %s", len(b.entries), b.window), counts)
+}
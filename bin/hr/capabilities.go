@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+// penlogSpecVersion is the version of penlog(7) which hr implements.
+const penlogSpecVersion = 1
+
+// knownExtensions are the optional parts of penlog(7) which hr
+// understands.
+var knownExtensions = map[string]bool{
+	"checks":   true,
+	"error":    true,
+	"findings": true,
+	"hmac":     true,
+	"phases":   true,
+	"run_id":   true,
+	"tags":     true,
+	"target":   true,
+	"via":      true,
+}
+
+// capabilities reacts to the records of type "capabilities" in which
+// producers announce the features they use, see penlog(7). It returns
+// records which warn about combinations hr cannot handle and hints.
+// Announced encodings of binary fields are decoded from then on,
+// unless --decode-field covers the field.
+func (c *converter) capabilities(data map[string]interface{}) []map[string]interface{} {
+	caps, ok := data["capabilities"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	var (
+		res     []map[string]interface{}
+		comp, _ = fieldString(data, "component")
+		emit    = func(prio penlog.Prio, format string, args ...interface{}) {
+			res = append(res, createRecord("capabilities", prio, fmt.Sprintf(format, args...)))
+		}
+	)
+	if n, ok := numberOf(caps["spec"]); ok {
+		if v, err := n.Int64(); err == nil && v > penlogSpecVersion {
+			emit(penlog.PrioWarning, "%s uses version %d of penlog(7), hr supports version %d; unknown fields are passed through", comp, v, penlogSpecVersion)
+		}
+	}
+	if exts, ok := fieldStrings(caps, "extensions"); ok {
+		var unknown []string
+		for _, ext := range exts {
+			if !knownExtensions[ext] {
+				unknown = append(unknown, ext)
+			}
+		}
+		if len(unknown) > 0 {
+			emit(penlog.PrioWarning, "%s uses extensions unknown to hr: %s", comp, strings.Join(unknown, ", "))
+		}
+	}
+	if signed, ok := caps["hmac"].(bool); ok {
+		switch {
+		case signed && c.hmacVerifier == nil:
+			emit(penlog.PrioNotice, "%s signs its records; verify them with --verify-hmac", comp)
+		case !signed && c.hmacVerifier != nil:
+			emit(penlog.PrioWarning, "%s does not sign its records; --verify-hmac will reject them", comp)
+		}
+	}
+	if binary, ok := caps["binary"].(map[string]interface{}); ok {
+		fields := make([]string, 0, len(binary))
+		for field := range binary {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			steps, _ := binary[field].(string)
+			if c.hasDecoder(field, comp) {
+				continue
+			}
+			dec, err := parseFieldDecoder(field+"="+steps, c.decodeLimit)
+			if err != nil {
+				emit(penlog.PrioWarning, "%s announces an encoding hr cannot decode: %s", comp, err)
+				continue
+			}
+			dec.component = comp
+			c.decoders = append(c.decoders, dec)
+			emit(penlog.PrioInfo, "decoding field '%s' of %s with %s as announced", field, comp, steps)
+		}
+	}
+	return res
+}
+
+// hasDecoder is true if field is already decoded for records of comp.
+func (c *converter) hasDecoder(field, comp string) bool {
+	for _, dec := range c.decoders {
+		if dec.field == field && (dec.component == "" || dec.component == comp) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+	jsoniter "github.com/json-iterator/go"
+)
+
+type componentCheckpoint struct {
+	Records int     `json:"records"`
+	Errors  int     `json:"errors"`
+	Rate    float64 `json:"rate"`
+}
+
+// checkpoints accumulates records between two checkpoints of
+// --stats-interval. add is called by transform, emit by a timer.
+type checkpoints struct {
+	mutex      sync.Mutex
+	start      time.Time
+	last       time.Time
+	records    int
+	errors     int
+	total      int
+	components map[string]*componentCheckpoint
+	file       *os.File
+	encoder    *jsoniter.Encoder
+	ticker     *time.Ticker
+	done       chan struct{}
+}
+
+func newCheckpoints(filename string) (*checkpoints, error) {
+	now := time.Now()
+	cp := &checkpoints{
+		start:      now,
+		last:       now,
+		components: make(map[string]*componentCheckpoint),
+		done:       make(chan struct{}),
+	}
+	if filename != "" {
+		f, err := os.Create(filename)
+		if err != nil {
+			return nil, err
+		}
+		cp.file = f
+		cp.encoder = json.NewEncoder(f)
+	}
+	return cp, nil
+}
+
+func (cp *checkpoints) add(data map[string]interface{}) {
+	comp, _ := fieldString(data, "component")
+	isError := isErrorRecord(data)
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	c, ok := cp.components[comp]
+	if !ok {
+		c = &componentCheckpoint{}
+		cp.components[comp] = c
+	}
+	c.Records++
+	cp.records++
+	cp.total++
+	if isError {
+		c.Errors++
+		cp.errors++
+	}
+}
+
+func rate(n int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return math.Round(float64(n)/d.Seconds()*10) / 10
+}
+
+// checkpoint creates a stats record covering the time since the
+// previous checkpoint and resets the counters.
+func (cp *checkpoints) checkpoint(now time.Time) map[string]interface{} {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	elapsed := now.Sub(cp.last)
+	for _, c := range cp.components {
+		c.Rate = rate(c.Records, elapsed)
+	}
+	record := createRecord("stats", penlog.PrioInfo, fmt.Sprintf(
+		"%d records (%.1f/s), %d errors, %d components in %s; %d records in total",
+		cp.records,
+		rate(cp.records, elapsed),
+		cp.errors,
+		len(cp.components),
+		elapsed.Round(time.Second),
+		cp.total,
+	))
+	record["stats"] = map[string]interface{}{
+		"interval":      elapsed.Seconds(),
+		"records":       cp.records,
+		"errors":        cp.errors,
+		"rate":          rate(cp.records, elapsed),
+		"total_records": cp.total,
+		"uptime":        now.Sub(cp.start).Seconds(),
+		"components":    cp.components,
+	}
+
+	cp.last = now
+	cp.records = 0
+	cp.errors = 0
+	cp.components = make(map[string]*componentCheckpoint)
+	return record
+}
+
+// run emits a checkpoint every interval until stop is called.
+func (cp *checkpoints) run(c *converter, interval time.Duration) {
+	cp.ticker = time.NewTicker(interval)
+	go func() {
+		for {
+			select {
+			case now := <-cp.ticker.C:
+				c.emitCheckpoint(cp.checkpoint(now))
+			case <-cp.done:
+				return
+			}
+		}
+	}()
+}
+
+func (cp *checkpoints) stop() {
+	if cp.ticker != nil {
+		cp.ticker.Stop()
+		close(cp.done)
+	}
+	if cp.file != nil {
+		cp.mutex.Lock()
+		cp.file.Close()
+		cp.file = nil
+		cp.mutex.Unlock()
+	}
+}
+
+// emitCheckpoint shows the record in the output and appends it to
+// the side file of --stats-file.
+func (c *converter) emitCheckpoint(record map[string]interface{}) {
+	c.checkpoints.mutex.Lock()
+	if c.checkpoints.file != nil {
+		if err := c.checkpoints.encoder.Encode(record); err != nil {
+			colorEprintf(colorRed, c.formatter.ShowColors, "error: %s\n", err)
+		}
+	}
+	c.checkpoints.mutex.Unlock()
+	c.printRecord(copyData(record))
+}
@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Fraunhofer-AISEC/penlog/filter"
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+// columnWidths are the default widths of the columns; 0 is not padded.
+// The widths of component and type follow --complen and --typelen.
+var columnWidths = map[string]int{
+	"timestamp": 0,
+	"host":      12,
+	"component": -1,
+	"target":    24,
+	"type":      -1,
+	"prio":      9,
+	"data":      0,
+	"line":      24,
+}
+
+type column struct {
+	name  string
+	width int
+}
+
+// columnLayout renders the selected columns in the given order. The
+// columns in front of data are joined like the default format, e.g.
+// "timestamp,component,type,data" is identical to it. The columns
+// after data are appended to the payload and share its color.
+type columnLayout struct {
+	pre       []column
+	post      []column
+	formatter *penlog.HRFormatter
+}
+
+// parseColumns parses a comma separated list of columns, each
+// optionally with a width, e.g. "timestamp,target:30,data".
+func parseColumns(specs []string, formatter *penlog.HRFormatter) (*columnLayout, error) {
+	var (
+		layout  columnLayout
+		hasData bool
+		seen    = make(map[string]bool)
+	)
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		name, width := spec, -1
+		if i := strings.Index(spec, ":"); i >= 0 {
+			n, err := strconv.Atoi(spec[i+1:])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid width of column '%s'", spec)
+			}
+			name, width = spec[:i], n
+		}
+		name = strings.ToLower(name)
+		if name == "priority" {
+			name = "prio"
+		}
+		def, ok := columnWidths[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column '%s', expected timestamp, host, component, target, type, prio, data, or line", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate column '%s'", name)
+		}
+		seen[name] = true
+		if width < 0 {
+			width = def
+		}
+		switch {
+		case name == "data":
+			hasData = true
+			continue
+		case width < 0 && name == "component":
+			width = formatter.CompLen
+		case width < 0 && name == "type":
+			width = formatter.TypeLen
+		}
+		if hasData {
+			layout.post = append(layout.post, column{name, width})
+		} else {
+			layout.pre = append(layout.pre, column{name, width})
+		}
+	}
+	if !hasData {
+		return nil, fmt.Errorf("the columns must include data")
+	}
+
+	// Only the payload and the additional lines of the formatter
+	// are used; a line column replaces the line below the record.
+	f := *formatter
+	f.Dialect = penlog.HRNano
+	if seen["line"] {
+		f.ShowLines = false
+	}
+	layout.formatter = &f
+	return &layout, nil
+}
+
+func (l *columnLayout) cell(col column, data map[string]interface{}) string {
+	var val string
+	switch col.name {
+	case "timestamp":
+		raw, _ := fieldString(data, "timestamp")
+		if ts, err := filter.ParseTimestamp(raw); err == nil {
+			val = ts.Format(l.formatter.Timespec)
+		} else {
+			val = strings.Repeat("0", len(l.formatter.Timespec))
+		}
+	case "target":
+		val = filter.Target(data)
+	case "prio":
+		if p, ok := fieldPrio(data); ok {
+			val = prioName(p)
+		}
+	default:
+		val, _ = scalarString(data[col.name])
+	}
+	if col.width > 0 {
+		val = padOrTruncate(val, col.width)
+	}
+	switch col.name {
+	case "component":
+		return "{" + val + "}"
+	case "type":
+		return "[" + val + "]"
+	}
+	return val
+}
+
+func (l *columnLayout) format(data map[string]interface{}) (string, error) {
+	d := copyData(data)
+	if len(l.post) > 0 {
+		cells := make([]string, 0, len(l.post))
+		for _, col := range l.post {
+			cells = append(cells, l.cell(col, d))
+		}
+		// Padding at the end of the line is of no use.
+		if post := strings.TrimRight(strings.Join(cells, " "), " "); post != "" {
+			payload, _ := fieldString(d, "data")
+			d["data"] = payload + " " + post
+		}
+	}
+	out, err := l.formatter.Format(d)
+	if err != nil {
+		return "", err
+	}
+	if len(l.pre) == 0 {
+		return out, nil
+	}
+	cells := make([]string, 0, len(l.pre))
+	for _, col := range l.pre {
+		cells = append(cells, l.cell(col, d))
+	}
+	return strings.Join(cells, " ") + ": " + out, nil
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+type config struct {
+	// Args are flags which precede the command line.
+	Args      []string         `json:"args,omitempty"`
+	Renderers []rendererConfig `json:"renderers,omitempty"`
+	Clients   []clientConfig   `json:"clients,omitempty"`
+}
+
+// defaultConfigPath returns $XDG_CONFIG_HOME/penlog/hr.json or
+// an empty string if the config directory cannot be determined.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "penlog", "hr.json")
+}
+
+// loadConfig reads the config file at path. If path is empty, the
+// default location is tried and a missing file is not an error.
+func loadConfig(path string) (*config, error) {
+	var (
+		cfg      config
+		optional = false
+	)
+	if path == "" {
+		path = defaultConfigPath()
+		optional = true
+	}
+	if path == "" {
+		return &cfg, nil
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		if optional && errors.Is(err, os.ErrNotExist) {
+			return &cfg, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// controlVersion is the version of the event schema of the
+	// control socket. It is only increased for incompatible changes.
+	controlVersion = 1
+	// controlQueue is the number of events a client may lag behind
+	// before it is disconnected.
+	controlQueue = 1024
+	// controlCloseTimeout limits the time to send pending events at
+	// the end of the input.
+	controlCloseTimeout = 2 * time.Second
+)
+
+// controlEvent is a line on the control socket. Records are sent as
+// they were filtered and decoded, before they are rendered.
+type controlEvent struct {
+	Version int                    `json:"version"`
+	Event   string                 `json:"event"`
+	Seq     uint64                 `json:"seq,omitempty"`
+	Record  map[string]interface{} `json:"record,omitempty"`
+}
+
+type controlClient struct {
+	conn net.Conn
+	ch   chan []byte
+}
+
+// controlSocket publishes the shown records as NDJSON to the clients of
+// a unix socket, e.g. GUIs or analyzers attached to a live instance.
+type controlSocket struct {
+	mutex    sync.Mutex
+	listener net.Listener
+	seq      uint64
+	clients  map[*controlClient]struct{}
+	closed   bool
+	handlers sync.WaitGroup
+}
+
+func newControlSocket(path string) (*controlSocket, error) {
+	// Records may contain secrets of the target. The socket must
+	// not be accessible to others before it is chmod-ed, hence the
+	// umask.
+	umask := syscall.Umask(0077)
+	ln, err := net.Listen("unix", path)
+	syscall.Umask(umask)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	s := &controlSocket{
+		listener: ln,
+		clients:  make(map[*controlClient]struct{}),
+	}
+	go s.accept()
+	return s, nil
+}
+
+func (s *controlSocket) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		hello, _ := json.Marshal(controlEvent{Version: controlVersion, Event: "hello"})
+
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		c := &controlClient{conn: conn, ch: make(chan []byte, controlQueue)}
+		c.ch <- hello
+		s.clients[c] = struct{}{}
+		s.handlers.Add(1)
+		s.mutex.Unlock()
+
+		go s.handle(c)
+	}
+}
+
+func (s *controlSocket) handle(c *controlClient) {
+	defer s.handlers.Done()
+	defer c.conn.Close()
+	for line := range c.ch {
+		if _, err := c.conn.Write(append(line, '\n')); err != nil {
+			s.remove(c)
+			// Drain the channel until publish notices the removal.
+			for range c.ch {
+			}
+			return
+		}
+	}
+}
+
+func (s *controlSocket) remove(c *controlClient) {
+	s.mutex.Lock()
+	if _, ok := s.clients[c]; ok {
+		close(c.ch)
+		delete(s.clients, c)
+	}
+	s.mutex.Unlock()
+}
+
+func (s *controlSocket) publish(data map[string]interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.seq++
+	if len(s.clients) == 0 {
+		return
+	}
+	line, err := json.Marshal(controlEvent{
+		Version: controlVersion,
+		Event:   "record",
+		Seq:     s.seq,
+		Record:  data,
+	})
+	if err != nil {
+		return
+	}
+	for c := range s.clients {
+		select {
+		case c.ch <- line:
+		default:
+			// Slow clients are dropped; the gap is visible
+			// in seq when they reconnect.
+			close(c.ch)
+			delete(s.clients, c)
+		}
+	}
+}
+
+// close sends the end event to all clients and removes the socket.
+func (s *controlSocket) close() {
+	s.mutex.Lock()
+	end, _ := json.Marshal(controlEvent{Version: controlVersion, Event: "end", Seq: s.seq})
+	s.closed = true
+	for c := range s.clients {
+		select {
+		case c.ch <- end:
+		default:
+		}
+		close(c.ch)
+		delete(s.clients, c)
+	}
+	s.mutex.Unlock()
+	s.listener.Close()
+
+	// Clients which do not read must not block the exit.
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(controlCloseTimeout):
+	}
+}
@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// fieldDecoder decodes the value of a field with a chain of steps,
+// e.g. "data=base64+gzip".
+type fieldDecoder struct {
+	field string
+	steps []string
+	limit int64
+	// component restricts decoders announced in capabilities to
+	// the following records of the announcing component.
+	component string
+	// zstd is created on first use and reset for every record.
+	zstd *zstd.Decoder
+}
+
+func parseFieldDecoder(spec string, limit int64) (*fieldDecoder, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid decode spec '%s': expected 'field=step+…'", spec)
+	}
+	dec := fieldDecoder{
+		field: parts[0],
+		steps: strings.Split(strings.ToLower(parts[1]), "+"),
+		limit: limit,
+	}
+	for _, step := range dec.steps {
+		switch step {
+		case "base64", "base64url", "hex", "gzip", "zstd":
+		default:
+			return nil, fmt.Errorf("invalid decode spec '%s': unknown step '%s'", spec, step)
+		}
+	}
+	return &dec, nil
+}
+
+// readLimited reads r completely, but fails if more than limit
+// bytes are available. This protects against decompression bombs.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	buf, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > limit {
+		return nil, fmt.Errorf("decoded size exceeds %d bytes", limit)
+	}
+	return buf, nil
+}
+
+func (d *fieldDecoder) decodeStep(step string, in []byte) ([]byte, error) {
+	switch step {
+	case "base64", "base64url":
+		enc := base64.StdEncoding
+		if step == "base64url" {
+			enc = base64.URLEncoding
+		}
+		s := strings.TrimSpace(string(in))
+		if !strings.HasSuffix(s, "=") {
+			enc = enc.WithPadding(base64.NoPadding)
+		}
+		// Without the padding, the decoded length is exact.
+		unpadded := strings.TrimRight(s, "=")
+		if int64(base64.RawStdEncoding.DecodedLen(len(unpadded))) > d.limit {
+			return nil, fmt.Errorf("decoded size exceeds %d bytes", d.limit)
+		}
+		return enc.DecodeString(s)
+	case "hex":
+		s := strings.TrimSpace(string(in))
+		if int64(hex.DecodedLen(len(s))) > d.limit {
+			return nil, fmt.Errorf("decoded size exceeds %d bytes", d.limit)
+		}
+		return hex.DecodeString(s)
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(in))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readLimited(r, d.limit)
+	case "zstd":
+		if d.zstd == nil {
+			r, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			d.zstd = r
+		}
+		if err := d.zstd.Reset(bytes.NewReader(in)); err != nil {
+			return nil, err
+		}
+		return readLimited(d.zstd, d.limit)
+	}
+	panic("BUG: invalid decode step")
+}
+
+// decode replaces the field in data with its decoded value. Results
+// which are not valid UTF-8 are shown as hex.
+func (d *fieldDecoder) decode(data map[string]interface{}) error {
+	if d.component != "" {
+		comp, _ := fieldString(data, "component")
+		msgType, _ := fieldString(data, "type")
+		if comp != d.component || msgType == "capabilities" {
+			return nil
+		}
+	}
+	val, err := castField(data, d.field)
+	if err != nil {
+		// Records without this field are left alone.
+		if _, ok := data[d.field]; !ok {
+			return nil
+		}
+		return err
+	}
+	buf := []byte(val)
+	for _, step := range d.steps {
+		if buf, err = d.decodeStep(step, buf); err != nil {
+			return fmt.Errorf("decoding field '%s' failed: %s: %w", d.field, step, err)
+		}
+	}
+	if utf8.Valid(buf) {
+		data[d.field] = string(buf)
+	} else {
+		data[d.field] = hex.EncodeToString(buf)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipped(t *testing.T, s string) string {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func zstded(t *testing.T, s string) string {
+	w, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	return string(w.EncodeAll([]byte(s), nil))
+}
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func decodeData(t *testing.T, dec *fieldDecoder, payload string) (string, error) {
+	data := map[string]interface{}{"component": "uart", "type": "read", "data": payload}
+	if err := dec.decode(data); err != nil {
+		return "", err
+	}
+	return data["data"].(string), nil
+}
+
+func TestDecodeField(t *testing.T) {
+	tests := []struct {
+		spec    string
+		payload string
+		want    string
+	}{
+		{"data=base64", b64("AT\r\n"), "AT\r\n"},
+		{"data=base64", "QVQ", "AT"},
+		{"data=base64url", base64.URLEncoding.EncodeToString([]byte{0xfb, 0xff}), "fbff"},
+		{"data=hex", "4f4b", "OK"},
+		{"data=hex", "ff00", "ff00"},
+		{"data=base64+gzip", b64(gzipped(t, "hello gzip")), "hello gzip"},
+		{"data=BASE64+ZSTD", b64(zstded(t, "hello zstd")), "hello zstd"},
+		{"data=base64+gzip+base64", b64(gzipped(t, b64("nested"))), "nested"},
+	}
+	for _, tt := range tests {
+		dec, err := parseFieldDecoder(tt.spec, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeData(t, dec, tt.payload)
+		if err != nil {
+			t.Errorf("%s of %q: %s", tt.spec, tt.payload, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s of %q: got %q, want %q", tt.spec, tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeLimit(t *testing.T) {
+	var (
+		exact = strings.Repeat("A", 4096)
+		large = strings.Repeat("A", 4097)
+	)
+	tests := []struct {
+		spec    string
+		payload string
+		ok      bool
+	}{
+		{"data=base64", b64(exact), true},
+		{"data=base64", b64(large), false},
+		{"data=hex", strings.Repeat("41", 4097), false},
+		{"data=base64+gzip", b64(gzipped(t, exact)), true},
+		{"data=base64+gzip", b64(gzipped(t, large)), false},
+		{"data=base64+zstd", b64(zstded(t, exact)), true},
+		{"data=base64+zstd", b64(zstded(t, large)), false},
+		// Bombs fail after limit bytes.
+		{"data=base64+gzip", b64(gzipped(t, strings.Repeat("A", 10<<20))), false},
+		{"data=base64+zstd", b64(zstded(t, strings.Repeat("A", 10<<20))), false},
+	}
+	for _, tt := range tests {
+		dec, err := parseFieldDecoder(tt.spec, 4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeData(t, dec, tt.payload)
+		if tt.ok {
+			if err != nil || got != exact {
+				t.Errorf("%s of %d bytes: got %q, %v", tt.spec, len(tt.payload), got, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "decoded size exceeds 4096 bytes") {
+			t.Errorf("%s of %d bytes: %v, want size error", tt.spec, len(tt.payload), err)
+		}
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	gz := gzipped(t, "truncated payload")
+	zs := zstded(t, "corrupt payload")
+	tests := []struct {
+		spec    string
+		payload string
+	}{
+		{"data=base64", "not base64!"},
+		{"data=hex", "4f4"},
+		{"data=hex", "zz"},
+		{"data=base64+gzip", b64("no gzip")},
+		{"data=base64+gzip", b64(gz[:len(gz)-10])},
+		{"data=base64+zstd", b64("no zstd")},
+		{"data=base64+zstd", b64(zs[:len(zs)/2])},
+	}
+	for _, tt := range tests {
+		dec, err := parseFieldDecoder(tt.spec, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := map[string]interface{}{"data": tt.payload}
+		if err := dec.decode(data); err == nil {
+			t.Errorf("%s of %q: no error", tt.spec, tt.payload)
+		}
+		// The field is left alone.
+		if data["data"] != tt.payload {
+			t.Errorf("%s of %q: field changed to %q", tt.spec, tt.payload, data["data"])
+		}
+	}
+}
+
+// The zstd decoder of a field is reused for all records, also after
+// a corrupt one.
+func TestDecodeZstdReuse(t *testing.T) {
+	dec, err := parseFieldDecoder("data=base64+zstd", 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, payload := range []string{"first", "", "second"} {
+		encoded := b64(zstded(t, payload))
+		if payload == "" {
+			if _, err := decodeData(t, dec, b64("corrupt")); err == nil {
+				t.Errorf("record %d: no error", i)
+			}
+			continue
+		}
+		got, err := decodeData(t, dec, encoded)
+		if err != nil || got != payload {
+			t.Errorf("record %d: got %q, %v, want %q", i, got, err, payload)
+		}
+	}
+}
+
+func TestParseFieldDecoder(t *testing.T) {
+	for _, spec := range []string{"", "data", "=base64", "data=", "data=base64+rot13"} {
+		if _, err := parseFieldDecoder(spec, 1<<20); err == nil {
+			t.Errorf("parseFieldDecoder(%q): no error", spec)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// These fields change with every record and are not compared.
+var diffIgnoredFields = map[string]bool{
+	"component":  true,
+	"type":       true,
+	"timestamp":  true,
+	"id":         true,
+	"line":       true,
+	"stacktrace": true,
+	"hmac":       true,
+	"hmac_seq":   true,
+}
+
+// differ reduces records to the fields which changed compared to
+// the previous record with the same component and type.
+type differ struct {
+	last map[string]map[string]interface{}
+}
+
+func newDiffer() *differ {
+	return &differ{last: make(map[string]map[string]interface{})}
+}
+
+func formatDiffValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// diff replaces the data field with a summary of changed fields. The
+// first record of every component and type is left untouched.
+func (df *differ) diff(data map[string]interface{}) {
+	var (
+		comp, _    = fieldString(data, "component")
+		msgType, _ = fieldString(data, "type")
+		key        = comp + "\x00" + msgType
+		prev, ok   = df.last[key]
+	)
+	df.last[key] = copyData(data)
+	if !ok {
+		return
+	}
+
+	var changed []string
+	for k, v := range data {
+		if diffIgnoredFields[k] {
+			continue
+		}
+		if old, ok := prev[k]; !ok || !reflect.DeepEqual(old, v) {
+			changed = append(changed, k)
+		}
+	}
+	for k := range prev {
+		if _, ok := data[k]; !ok && !diffIgnoredFields[k] {
+			changed = append(changed, k)
+		}
+	}
+	if len(changed) == 0 {
+		data["data"] = "(unchanged)"
+		return
+	}
+	// data comes first, the rest is sorted.
+	sort.Slice(changed, func(i, j int) bool {
+		if changed[i] == "data" || changed[j] == "data" {
+			return changed[i] == "data"
+		}
+		return changed[i] < changed[j]
+	})
+
+	parts := make([]string, 0, len(changed))
+	for _, k := range changed {
+		v, ok := data[k]
+		switch {
+		case !ok:
+			parts = append(parts, k+"=<removed>")
+		case k == "data":
+			parts = append(parts, formatDiffValue(v))
+		default:
+			parts = append(parts, k+"="+formatDiffValue(v))
+		}
+	}
+	data["data"] = strings.Join(parts, " ")
+}
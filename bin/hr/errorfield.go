@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// recordError is the structured field `error` of a record, see
+// penlog(7). Causes is the unwrapped chain, outermost first.
+type recordError struct {
+	message    string
+	typ        string
+	causes     []*recordError
+	stacktrace string
+}
+
+func parseRecordError(raw interface{}) (*recordError, bool) {
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	msg, ok := obj["message"].(string)
+	if !ok {
+		return nil, false
+	}
+	e := &recordError{message: msg}
+	e.typ, _ = obj["type"].(string)
+	e.stacktrace, _ = obj["stacktrace"].(string)
+	if causes, ok := obj["causes"].([]interface{}); ok {
+		for _, rawCause := range causes {
+			if cause, ok := parseRecordError(rawCause); ok {
+				e.causes = append(e.causes, cause)
+			}
+		}
+	}
+	return e, true
+}
+
+func (e *recordError) String() string {
+	if e.typ == "" {
+		return e.message
+	}
+	return e.typ + ": " + e.message
+}
+
+// render returns the lines to append to the rendered record. Without
+// expand only the error itself is shown, since the messages of
+// wrapping errors usually contain the messages of their causes.
+func (e *recordError) render(expand bool, colors bool) string {
+	var b strings.Builder
+	b.WriteString("\n  => error: " + e.String())
+	if !expand {
+		if n := len(e.causes); n > 0 {
+			hint := fmt.Sprintf(" (%d causes)", n)
+			if n == 1 {
+				hint = " (1 cause)"
+			}
+			if colors {
+				hint = colorize(colorGray, hint)
+			}
+			b.WriteString(hint)
+		}
+		return b.String()
+	}
+
+	prefix := "  |"
+	if colors {
+		prefix = colorize(colorGray, prefix)
+	}
+	for _, cause := range e.causes {
+		b.WriteString("\n" + prefix + "caused by: " + cause.String())
+	}
+	if trace := strings.TrimRight(e.stacktrace, "\n"); trace != "" {
+		b.WriteString("\n" + prefix + "stacktrace:")
+		for _, line := range strings.Split(trace, "\n") {
+			b.WriteString("\n" + prefix + "  " + line)
+		}
+	}
+	return b.String()
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"regexp"
 )
 
 const (
@@ -34,3 +35,10 @@ func colorEprintf(color string, colorized bool, format string, args ...interface
 		fmt.Fprintf(os.Stderr, format, args...)
 	}
 }
+
+var escapeSequence = regexp.MustCompile("\033\\[[0-9;?]*[A-Za-z]")
+
+// stripEscapes removes the colors and other CSI sequences from s.
+func stripEscapes(s string) string {
+	return escapeSequence.ReplaceAllString(s, "")
+}
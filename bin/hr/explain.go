@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+// explainer selects the records for which --explain reports the
+// verdict of every filter stage.
+type explainer struct {
+	sample    int
+	ids       map[string]bool
+	explained int
+}
+
+func newExplainer(sample int, ids []string) *explainer {
+	e := &explainer{sample: sample, ids: make(map[string]bool)}
+	for _, id := range ids {
+		e.ids[id] = true
+	}
+	return e
+}
+
+func (e *explainer) wants(data map[string]interface{}) bool {
+	if id, ok := fieldString(data, "id"); ok && e.ids[id] {
+		return true
+	}
+	if e.explained < e.sample {
+		e.explained++
+		return true
+	}
+	return false
+}
+
+// explain evaluates the stages of transform for data without side
+// effects. All stages are evaluated, such that every reason for a
+// dropped record is visible at once.
+func (c *converter) explain(data map[string]interface{}, lineno int) map[string]interface{} {
+	var (
+		stages []string
+		shown  = true
+	)
+	verdict := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			shown = false
+		}
+		stages = append(stages, fmt.Sprintf(format, args...))
+	}
+
+	for _, f := range c.stdoutFilters {
+		if f.spec.Match(data) {
+			verdict(true, "%s matched", f.source)
+		} else {
+			verdict(false, "%s rejected", f.source)
+		}
+	}
+	if prio, ok := fieldPrio(data); ok {
+		comp, _ := fieldString(data, "component")
+		level := c.threshold(comp, c.logLevel)
+		source := ""
+		if level != c.logLevel {
+			source = " of --prio-override"
+		}
+		switch {
+		case c.tui != nil:
+			// The TUI applies the threshold itself.
+		case prio > level:
+			verdict(false, "priority %s is above %s%s", prioName(prio), prioName(level), source)
+		default:
+			verdict(true, "priority %s is within %s%s", prioName(prio), prioName(level), source)
+		}
+	}
+	if c.id != "" {
+		if id, ok := fieldString(data, "id"); ok && id != c.id {
+			verdict(false, "id %s rejected by --id", id)
+		}
+	}
+	if c.quiet && !c.stopped {
+		verdict(false, "only the record stopping processing is shown")
+	}
+	for _, f := range c.filters {
+		if f.spec.Match(data) {
+			stages = append(stages, fmt.Sprintf("written to %s", f.filename))
+		}
+	}
+
+	result := "dropped"
+	if shown {
+		result = "shown"
+	}
+	msg := fmt.Sprintf("line %d: %s", lineno, result)
+	if id, ok := fieldString(data, "id"); ok {
+		msg = fmt.Sprintf("line %d (id %s): %s", lineno, id, result)
+	}
+	if len(stages) > 0 {
+		msg += "; " + strings.Join(stages, "; ")
+	}
+	record := createRecord("explain", penlog.PrioInfo, msg)
+	record["input_line"] = lineno
+	record["verdict"] = result
+	return record
+}
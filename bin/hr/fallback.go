@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+// fallbackBacklog limits the records kept since the last sync point.
+const fallbackBacklog = 1000
+
+// stderrMutex serializes the fallbacks of several file outputs.
+var stderrMutex sync.Mutex
+
+// sinkFallback keeps the records written to a file output since its
+// last sync point. If writing the file fails, these and all following
+// records are written to stderr instead of being lost. Records which
+// reached the file before the failure may appear twice.
+type sinkFallback struct {
+	filename  string
+	format    string
+	base      *penlog.HRFormatter
+	formatter *penlog.HRFormatter
+	unsynced  []map[string]interface{}
+	failed    bool
+	// failures is shared by all file outputs and decides the exit
+	// status.
+	failures *int32
+}
+
+func newSinkFallback(filename string, format string, formatter *penlog.HRFormatter, failures *int32) *sinkFallback {
+	return &sinkFallback{
+		filename: filename,
+		format:   format,
+		base:     formatter,
+		failures: failures,
+	}
+}
+
+func (s *sinkFallback) keep(data map[string]interface{}) {
+	s.unsynced = append(s.unsynced, data)
+	if len(s.unsynced) > fallbackBacklog {
+		s.unsynced = s.unsynced[len(s.unsynced)-fallbackBacklog:]
+	}
+}
+
+// synced discards the kept records once they are in the file.
+func (s *sinkFallback) synced() {
+	s.unsynced = s.unsynced[:0]
+}
+
+// fail switches to stderr and writes the kept records there, preceded
+// by an error record which explains the switch.
+func (s *sinkFallback) fail(err error) {
+	if s.failed {
+		return
+	}
+	s.failed = true
+	atomic.AddInt32(s.failures, 1)
+
+	stderrMutex.Lock()
+	defer stderrMutex.Unlock()
+	record := createRecord("output", penlog.PrioError, fmt.Sprintf(
+		"writing %s failed: %s; writing its %d unsynced and all following records to stderr",
+		s.filename,
+		err,
+		len(s.unsynced),
+	))
+	record["file"] = s.filename
+	s.print(record)
+	for _, data := range s.unsynced {
+		s.print(data)
+	}
+	s.unsynced = nil
+}
+
+func (s *sinkFallback) write(data map[string]interface{}) {
+	stderrMutex.Lock()
+	defer stderrMutex.Unlock()
+	s.print(data)
+}
+
+// print writes a record to stderr in the format of the sink; fixtures
+// are written as JSON records since they cannot be sorted anymore.
+func (s *sinkFallback) print(data map[string]interface{}) {
+	if s.format != sinkFormatHR {
+		if b, err := json.Marshal(data); err == nil {
+			os.Stderr.Write(append(b, '\n'))
+		}
+		return
+	}
+	// The formatter is configured completely once records flow.
+	if s.formatter == nil {
+		f := *s.base
+		f.ShowColors = false
+		s.formatter = &f
+	}
+	d := copyData(data)
+	normalizeRecord(d)
+	str, err := s.formatter.Format(d)
+	if err != nil {
+		raw, _ := json.Marshal(data)
+		str, _ = s.formatter.Format(createErrorRecord(string(raw)))
+	}
+	fmt.Fprintln(os.Stderr, str)
+}
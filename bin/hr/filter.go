@@ -4,95 +4,39 @@ package main
 
 import (
 	"fmt"
-	"strings"
-)
 
-const (
-	filterTypeSimple = iota
-	filterTypeJQ
+	"github.com/Fraunhofer-AISEC/penlog/filter"
 )
 
-type filter struct {
-	ftype      int
-	simpleSpec filterSimple
-	priority   int
-}
-
-func (f *filter) filter(line map[string]interface{}) (map[string]interface{}, error) {
-	switch f.ftype {
-	case filterTypeSimple:
-		if f.simpleSpec.isMatch(line) {
-			return line, nil
-		}
-		return nil, nil
-	}
-	panic("BUG: invalid filter type")
-}
-
-func determineFilterType(spec string) int {
-	return filterTypeSimple
-}
-
-type filterSimple struct {
-	filename     string
-	components   []string
-	messageTypes []string
+// outputFilter writes the records selected by spec to filename.
+type outputFilter struct {
+	spec     *filter.Filter
+	filename string
+	sink     sinkOptions
+	// source is the option which created the filter, for --explain.
+	source string
 }
 
-func parseSimpleFilter(filterexpr string) (*filter, error) {
-	var (
-		res   filterSimple
-		parts = strings.SplitN(filterexpr, ":", 3)
-	)
-	switch len(parts) {
-	// Only a filename ist specified, no filters.
-	case 1:
-		res.filename = parts[0]
-	// Filters and filename is availabe.
-	case 2:
-		res.messageTypes = removeEmpy(strings.Split(parts[0], ","))
-		res.filename = parts[1]
-	// Components, filters, and a filename specified.
-	case 3:
-		res.components = removeEmpy(strings.Split(parts[0], ","))
-		res.messageTypes = removeEmpy(strings.Split(parts[1], ","))
-		res.filename = parts[2]
-	// Filter expression is invalid.
-	default:
-		return nil, fmt.Errorf("invalid filter expression")
-	}
-	return &filter{ftype: filterTypeSimple, simpleSpec: res}, nil
-}
-
-func compare(candidate string, filters []string) bool {
-	if len(filters) == 0 {
-		return true
-	}
-	c := strings.ToLower(candidate)
-	for _, filter := range filters {
-		f := strings.ToLower(filter)
-		if c == f {
-			return true
-		}
-	}
-	return false
-}
-
-// FIXME: exclusive is broken, thus missing
-func (f *filterSimple) isMatch(data map[string]interface{}) bool {
-	comp, err := castField(data, "component")
+func parseOutputFilter(spec string) (*outputFilter, error) {
+	f, err := filter.Parse(spec)
 	if err != nil {
-		return false
+		return nil, err
 	}
-	msgType, err := castField(data, "type")
+	filename, sink, err := parseSinkOptions(f.Output)
 	if err != nil {
-		return false
-	}
-	if !compare(comp, f.components) {
-		return false
-	}
-	if !compare(msgType, f.messageTypes) {
-		return false
+		return nil, err
+	}
+	return &outputFilter{
+		spec:     f,
+		filename: filename,
+		sink:     sink,
+		source:   fmt.Sprintf("-f '%s'", spec),
+	}, nil
+}
+
+func (f *outputFilter) filter(data map[string]interface{}) (map[string]interface{}, error) {
+	if f.spec.Match(data) {
+		return data, nil
 	}
-	return true
+	return nil, nil
 }
@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Fraunhofer-AISEC/penlog/filter"
+)
+
+// fixtureDropFields are removed from fixtures; they identify a run or
+// a host, and signatures become invalid anyway.
+var fixtureDropFields = []string{"hmac", "hmac_seq", "id", "run_id", "via"}
+
+// fixtureNets are the IPv4 documentation networks of RFC5737.
+var fixtureNets = []string{"192.0.2", "198.51.100", "203.0.113"}
+
+var ipv4Pattern = regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4][0-9]|1?[0-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1?[0-9]?[0-9])\b`)
+
+// fixture collects records for the sink format "fixture": a
+// minimized and anonymized file which is stable across runs, such
+// that it can be committed as input for regression tests.
+type fixture struct {
+	records []map[string]interface{}
+	hosts   map[string]string
+	// names are the keys of hosts, longest first.
+	names []string
+	addrs map[string]string
+}
+
+func newFixture() *fixture {
+	return &fixture{
+		hosts: make(map[string]string),
+		addrs: make(map[string]string),
+	}
+}
+
+func (f *fixture) add(data map[string]interface{}) {
+	f.records = append(f.records, data)
+}
+
+// pseudonym returns a stable replacement for an address in the order
+// of appearance.
+func (f *fixture) pseudonym(addr string) string {
+	if p, ok := f.addrs[addr]; ok {
+		return p
+	}
+	n := len(f.addrs)
+	p := fmt.Sprintf("ipv4-%d", n+1)
+	if net := n / 254; net < len(fixtureNets) {
+		p = fmt.Sprintf("%s.%d", fixtureNets[net], n%254+1)
+	}
+	f.addrs[addr] = p
+	return p
+}
+
+func isHostnameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+}
+
+// replaceHostname replaces host where it is not part of a longer name,
+// such that the host "lab" does not change "label".
+func replaceHostname(s, host, repl string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, host)
+		if i < 0 {
+			break
+		}
+		end := i + len(host)
+		if (i == 0 || !isHostnameChar(s[i-1])) && (end == len(s) || !isHostnameChar(s[end])) {
+			b.WriteString(s[:i])
+			b.WriteString(repl)
+		} else {
+			b.WriteString(s[:end])
+		}
+		s = s[end:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+func (f *fixture) anonymize(s string) string {
+	for _, host := range f.names {
+		s = replaceHostname(s, host, f.hosts[host])
+	}
+	return ipv4Pattern.ReplaceAllStringFunc(s, f.pseudonym)
+}
+
+// sortedKeys makes the pseudonyms independent of the order of maps.
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// anonymizeValue walks nested objects and lists, e.g. a structured
+// target or error, including their keys.
+func (f *fixture) anonymizeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return f.anonymize(v)
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for _, k := range sortedKeys(v) {
+			res[f.anonymize(k)] = f.anonymizeValue(v[k])
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, val := range v {
+			res[i] = f.anonymizeValue(val)
+		}
+		return res
+	}
+	return v
+}
+
+// write sorts the records by timestamp, keeping the input order of
+// equal timestamps, and writes them in canonical form.
+func (f *fixture) write(w io.Writer) error {
+	sort.SliceStable(f.records, func(i, j int) bool {
+		a, _ := fieldString(f.records[i], "timestamp")
+		b, _ := fieldString(f.records[j], "timestamp")
+		ta, errA := filter.ParseTimestamp(a)
+		tb, errB := filter.ParseTimestamp(b)
+		if errA != nil || errB != nil {
+			return errA != nil && errB == nil
+		}
+		return ta.Before(tb)
+	})
+
+	// Hostnames are collected first, as they can appear in the data
+	// of earlier records.
+	for _, data := range f.records {
+		if host, ok := data["host"].(string); ok && host != "" {
+			if _, ok := f.hosts[host]; !ok {
+				f.hosts[host] = fmt.Sprintf("host-%d", len(f.hosts)+1)
+				f.names = append(f.names, host)
+			}
+		}
+	}
+	sort.SliceStable(f.names, func(i, j int) bool {
+		return len(f.names[i]) > len(f.names[j])
+	})
+	for _, data := range f.records {
+		d := copyData(data)
+		for _, field := range fixtureDropFields {
+			delete(d, field)
+		}
+		for _, k := range sortedKeys(d) {
+			if k != "timestamp" {
+				d[k] = f.anonymizeValue(d[k])
+			}
+		}
+		raw, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(raw, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/Fraunhofer-AISEC/penlog/filter"
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+var groupKeys = map[string]func(map[string]interface{}) string{
+	"target": filter.Target,
+	"component": func(data map[string]interface{}) string {
+		comp, _ := fieldString(data, "component")
+		return comp
+	},
+}
+
+// groupFilenameChars are replaced in the names of the files of --group-dir.
+var groupFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+type groupEntry struct {
+	data map[string]interface{}
+	line string
+}
+
+type group struct {
+	label    string
+	entries  []groupEntry
+	records  int
+	errors   int
+	warnings int
+	first    string
+	last     string
+	filename string
+	file     *os.File
+	writer   *bufio.Writer
+}
+
+// grouper collects the records shown on stdout by target or component.
+// At the end they are shown in one section per group, followed by a
+// summary of the group. With a directory, the records of every group
+// are written to a file instead and only the summaries are shown.
+type grouper struct {
+	conv   *converter
+	by     string
+	key    func(map[string]interface{}) string
+	dir    string
+	groups map[string]*group
+	order  []*group
+	// Sanitized labels may collide, e.g. "ecu 1" and "ecu/1".
+	filenames map[string]bool
+}
+
+func newGrouper(conv *converter, by string, dir string) (*grouper, error) {
+	key, ok := groupKeys[by]
+	if !ok {
+		return nil, fmt.Errorf("invalid value for --group-by: %s", by)
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &grouper{
+		conv:      conv,
+		by:        by,
+		key:       key,
+		dir:       dir,
+		groups:    make(map[string]*group),
+		filenames: make(map[string]bool),
+	}, nil
+}
+
+func (g *grouper) open(label string) (*group, error) {
+	grp := &group{label: label}
+	if g.dir == "" {
+		return grp, nil
+	}
+	name := groupFilenameChars.ReplaceAllString(label, "_")
+	if label == "" {
+		name = "no-" + g.by
+	}
+	filename := name
+	for i := 2; g.filenames[filename]; i++ {
+		filename = fmt.Sprintf("%s-%d", name, i)
+	}
+	g.filenames[filename] = true
+	grp.filename = filepath.Join(g.dir, filename+".log.json")
+	file, err := os.Create(grp.filename)
+	if err != nil {
+		return nil, err
+	}
+	grp.file = file
+	grp.writer = bufio.NewWriter(file)
+	return grp, nil
+}
+
+// add takes a record and its rendered line; line is empty for the
+// JSON based output modes.
+func (g *grouper) add(data map[string]interface{}, line string) error {
+	label := g.key(data)
+	grp, ok := g.groups[label]
+	if !ok {
+		var err error
+		if grp, err = g.open(label); err != nil {
+			return err
+		}
+		g.groups[label] = grp
+		g.order = append(g.order, grp)
+	}
+
+	grp.records++
+	if p, ok := fieldPrio(data); ok {
+		switch {
+		case p <= penlog.PrioError:
+			grp.errors++
+		case p == penlog.PrioWarning:
+			grp.warnings++
+		}
+	}
+	if ts, ok := fieldString(data, "timestamp"); ok {
+		if grp.first == "" {
+			grp.first = ts
+		}
+		grp.last = ts
+	}
+
+	if grp.writer == nil {
+		grp.entries = append(grp.entries, groupEntry{data: data, line: line})
+		return nil
+	}
+	d := copyData(data)
+	g.conv.stamp(d)
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	_, err = grp.writer.Write(append(raw, '\n'))
+	return err
+}
+
+func (g *grouper) display(grp *group) string {
+	if grp.label == "" {
+		return fmt.Sprintf("without %s", g.by)
+	}
+	return fmt.Sprintf("%s %s", g.by, grp.label)
+}
+
+func (g *grouper) header(grp *group) map[string]interface{} {
+	record := createRecord("group", penlog.PrioNotice, g.display(grp))
+	record["group_by"] = g.by
+	record["group"] = grp.label
+	return record
+}
+
+func (g *grouper) summary(grp *group) map[string]interface{} {
+	msg := fmt.Sprintf("%s: %d records, %d errors, %d warnings", g.display(grp), grp.records, grp.errors, grp.warnings)
+	if grp.first != "" {
+		msg += fmt.Sprintf(", from %s to %s", grp.first, grp.last)
+	}
+	if grp.filename != "" {
+		msg += fmt.Sprintf("; written to %s", grp.filename)
+	}
+	record := createRecord("group", penlog.PrioInfo, msg)
+	record["group_by"] = g.by
+	record["group"] = grp.label
+	record["records"] = grp.records
+	record["errors"] = grp.errors
+	record["warnings"] = grp.warnings
+	if grp.filename != "" {
+		record["file"] = grp.filename
+	}
+	return record
+}
+
+// finish shows the sections and summaries. Records without the key
+// come last.
+func (g *grouper) finish() {
+	if grp, ok := g.groups[""]; ok && len(g.order) > 1 {
+		for i, other := range g.order {
+			if other == grp {
+				g.order = append(append(g.order[:i:i], g.order[i+1:]...), grp)
+				break
+			}
+		}
+	}
+	for _, grp := range g.order {
+		if grp.writer == nil {
+			g.conv.printRecord(g.header(grp))
+			g.conv.stdoutMutex.Lock()
+			for _, e := range grp.entries {
+				if e.line == "" {
+					g.conv.printJSON(e.data)
+				} else {
+					fmt.Println(e.line)
+				}
+			}
+			g.conv.stdoutMutex.Unlock()
+		}
+		g.conv.printRecord(g.summary(grp))
+	}
+	g.close()
+}
+
+// close flushes the files of --group-dir; it is also called if hr is
+// terminated.
+func (g *grouper) close() {
+	for _, grp := range g.order {
+		if grp.writer != nil {
+			grp.writer.Flush()
+			grp.file.Close()
+			grp.writer = nil
+		}
+	}
+}
@@ -3,18 +3,27 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
 	"github.com/klauspost/compress/zstd"
 	"golang.org/x/sys/unix"
 )
 
+// timestampFormat is the ISO8601 format of timestamps which hr writes,
+// with microseconds and an explicit offset to UTC.
+const timestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+
 func padOrTruncate(s string, maxLen int) string {
 	res := s
 	if len(s) > maxLen {
@@ -40,6 +49,18 @@ func castField(data map[string]interface{}, field string) (string, error) {
 	return "", fmt.Errorf("%w: field '%s' does not exist in data", errInvalidData, field)
 }
 
+// createRecord creates a record issued by hr itself.
+func createRecord(msgType string, prio penlog.Prio, msg string) map[string]interface{} {
+	var record = map[string]interface{}{
+		"timestamp": time.Now().Format(timestampFormat),
+		"data":      msg,
+		"component": "hr",
+		"type":      msgType,
+		"priority":  float64(prio),
+	}
+	return record
+}
+
 func createErrorRecord(msg string) map[string]interface{} {
 	var record = map[string]interface{}{
 		"timestamp": "NONE",
@@ -82,16 +103,80 @@ func getReader(filename string) (io.Reader, error) {
 			return nil, err
 		}
 	case ".zst":
-		reader, err = zstd.NewReader(file)
+		dec, err := zstd.NewReader(file)
 		if err != nil {
 			return nil, err
 		}
+		reader = &zstdReader{dec: dec, filename: filename}
 	default:
 		reader = file
 	}
 	return reader, nil
 }
 
+var (
+	gzipHeader = []byte{0x1f, 0x8b}
+	zstdHeader = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// getStdinReader returns a reader for stdin. Streams have no file name
+// to tell their compression, thus gzip and zstd are detected by their
+// magic numbers. The sniffed bytes stay buffered for the decoder.
+func getStdinReader() (io.Reader, error) {
+	br := bufio.NewReader(os.Stdin)
+	// A short peek is fine; the stream is then neither gzip nor zstd.
+	magic, _ := br.Peek(len(zstdHeader))
+	switch {
+	case bytes.HasPrefix(magic, gzipHeader):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdHeader):
+		dec, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &zstdReader{dec: dec, filename: "<stdin>"}, nil
+	}
+	return br, nil
+}
+
+// zstdReader names the file in decoding errors. Checksums of frames
+// are verified by the decoder.
+type zstdReader struct {
+	dec      *zstd.Decoder
+	filename string
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	n, err := z.dec.Read(p)
+	switch {
+	case err == nil || errors.Is(err, io.EOF):
+	case errors.Is(err, zstd.ErrCRCMismatch):
+		err = fmt.Errorf("%s: zstd checksum mismatch, the file is corrupt", z.filename)
+	default:
+		err = fmt.Errorf("%s: %w", z.filename, err)
+	}
+	return n, err
+}
+
+// followReader keeps reading from a growing file, similar to tail -f.
+type followReader struct {
+	r        io.Reader
+	interval time.Duration
+}
+
+func (f *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+		time.Sleep(f.interval)
+	}
+}
+
 func copyData(data map[string]interface{}) map[string]interface{} {
 	d := make(map[string]interface{})
 	for k, v := range data {
@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	stdjson "encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// hmacVerifier checks the hash chain described in penlog(7):
+//
+//	hmac = HMAC-SHA256(key, hmac_prev || "\n" || canonical record without "hmac")
+//
+// hmac_seq is part of the signed record and allows to tell gaps
+// and reordering apart from modified records.
+type hmacVerifier struct {
+	key      []byte
+	prev     string
+	expected int64
+	failures int
+}
+
+func newHMACVerifier(keyFile string) (*hmacVerifier, error) {
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key = bytes.TrimRight(key, "\r\n")
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%s: empty hmac key", keyFile)
+	}
+	return &hmacVerifier{key: key}, nil
+}
+
+// canonicalJSON encodes data according to the JSON Canonicalization
+// Scheme of RFC8785, such that producers in other languages compute
+// the same bytes.
+func canonicalJSON(data map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case string:
+		writeCanonicalString(buf, v)
+	case stdjson.Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return fmt.Errorf("number %s: %w", v, err)
+		}
+		return writeCanonicalNumber(buf, f)
+	case float64:
+		return writeCanonicalNumber(buf, v)
+	case int:
+		return writeCanonicalNumber(buf, float64(v))
+	case rawJSON:
+		return writeCanonical(buf, v.value())
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		// Keys are sorted by their UTF-16 code units.
+		sort.Slice(keys, func(i, j int) bool {
+			a, b := utf16.Encode([]rune(keys[i])), utf16.Encode([]rune(keys[j]))
+			for n := 0; n < len(a) && n < len(b); n++ {
+				if a[n] != b[n] {
+					return a[n] < b[n]
+				}
+			}
+			return len(a) < len(b)
+		})
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, k)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("cannot canonicalize %T", v)
+	}
+	return nil
+}
+
+// writeCanonicalString only escapes what JSON requires; other
+// characters are written as UTF-8.
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// writeCanonicalNumber writes f like ECMAScript's Number.toString,
+// e.g. 1.0 as 1 and 1e-7 as 1e-7.
+func writeCanonicalNumber(buf *bytes.Buffer, f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("number %v is not valid JSON", f)
+	}
+	if f == 0 {
+		// Including -0.
+		buf.WriteByte('0')
+		return nil
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs < 1e-6 || abs >= 1e21 {
+		format = 'e'
+	}
+	s := strconv.FormatFloat(f, format, -1, 64)
+	if format == 'e' {
+		// Go writes at least two digits of the exponent.
+		if n := len(s); n >= 4 && s[n-4] == 'e' && s[n-2] == '0' {
+			s = s[:n-2] + s[n-1:]
+		}
+	}
+	buf.WriteString(s)
+	return nil
+}
+
+func (v *hmacVerifier) compute(prev string, data map[string]interface{}) (string, error) {
+	d := copyData(data)
+	delete(d, "hmac")
+	canonical, err := canonicalJSON(d)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, v.key)
+	mac.Write([]byte(prev))
+	mac.Write([]byte("\n"))
+	mac.Write(canonical)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verify checks the next record of the chain and returns a
+// description of the problem, or an empty string if it is fine.
+func (v *hmacVerifier) verify(data map[string]interface{}) string {
+	problem := v.check(data)
+	if problem != "" {
+		v.failures++
+	}
+	return problem
+}
+
+func (v *hmacVerifier) check(data map[string]interface{}) string {
+	expected := v.expected
+	v.expected++
+
+	sig, err := castField(data, "hmac")
+	if err != nil {
+		return fmt.Sprintf("hmac_seq %d: missing hmac", expected)
+	}
+	seq := expected
+	if n, ok := fieldInt64(data, "hmac_seq"); ok {
+		seq = n
+	}
+
+	mac, err := v.compute(v.prev, data)
+	if err != nil {
+		return fmt.Sprintf("hmac_seq %d: %s", expected, err)
+	}
+	if hmac.Equal([]byte(mac), []byte(strings.ToLower(sig))) {
+		v.prev = mac
+		return ""
+	}
+
+	// Resynchronize on the received record in order to
+	// continue checking the rest of the chain.
+	v.prev = strings.ToLower(sig)
+	v.expected = seq + 1
+	switch {
+	case seq > expected:
+		return fmt.Sprintf("hmac_seq %d: gap in hash chain, %d records missing", expected, seq-expected)
+	case seq < expected:
+		return fmt.Sprintf("hmac_seq %d: hash chain out of order, got sequence number %d", expected, seq)
+	}
+	return fmt.Sprintf("hmac_seq %d: hmac mismatch, record was modified", expected)
+}
@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+	"github.com/spf13/pflag"
+)
+
+// secretFlags take secrets; literal values are redacted when the
+// invocation is saved.
+var secretFlags = map[string]bool{"input-token": true, "serve-token": true}
+
+// unsavedFlags do not belong into a saved invocation.
+var unsavedFlags = map[string]bool{"config": true, "save-invocation": true, "version": true}
+
+const redacted = "REDACTED"
+
+// redactSecret keeps references to secrets, see resolveSecret.
+func redactSecret(val string) string {
+	for _, scheme := range []string{"env:", "file:", "keyring:"} {
+		if strings.HasPrefix(val, scheme) {
+			return val
+		}
+	}
+	if val == "" {
+		return val
+	}
+	return redacted
+}
+
+// applyConfigArgs parses the args of the config as if they preceded
+// the command line; flags of the command line take precedence.
+func applyConfigArgs(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	fs := pflag.NewFlagSet("config", pflag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	pflag.CommandLine.VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			fs.AddFlag(&pflag.Flag{
+				Name:        f.Name,
+				Shorthand:   f.Shorthand,
+				Value:       &ignoredValue{f.Value.Type()},
+				NoOptDefVal: f.NoOptDefVal,
+			})
+			return
+		}
+		fs.AddFlag(f)
+	})
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("args of config: %w", err)
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("args of config: unexpected argument '%s'", fs.Arg(0))
+	}
+	return nil
+}
+
+// ignoredValue swallows a flag which is already set on the command line.
+type ignoredValue struct {
+	typ string
+}
+
+func (v *ignoredValue) String() string   { return "" }
+func (v *ignoredValue) Set(string) error { return nil }
+func (v *ignoredValue) Type() string     { return v.typ }
+
+// savedArgs returns the flags which are set, such that they can be
+// replayed with the args of a config.
+func savedArgs() []string {
+	var args []string
+	pflag.CommandLine.VisitAll(func(f *pflag.Flag) {
+		if !f.Changed || unsavedFlags[f.Name] {
+			return
+		}
+		vals := []string{f.Value.String()}
+		if s, ok := f.Value.(pflag.SliceValue); ok {
+			vals = s.GetSlice()
+		}
+		for _, val := range vals {
+			if secretFlags[f.Name] {
+				val = redactSecret(val)
+			}
+			args = append(args, fmt.Sprintf("--%s=%s", f.Name, val))
+		}
+	})
+	return args
+}
+
+// savedConfig is the config with the flags of this invocation; secrets
+// of clients are redacted.
+func savedConfig(cfg *config) *config {
+	saved := *cfg
+	saved.Args = savedArgs()
+	saved.Clients = nil
+	for _, client := range cfg.Clients {
+		client.Token = redactSecret(client.Token)
+		saved.Clients = append(saved.Clients, client)
+	}
+	return &saved
+}
+
+func penlogEnv() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "PENLOG_") {
+			i := strings.IndexByte(kv, '=')
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	return env
+}
+
+// saveInvocation writes the config which reproduces this invocation to
+// path and returns the preamble record describing it.
+func saveInvocation(path string, cfg *config) (map[string]interface{}, error) {
+	saved := savedConfig(cfg)
+	raw, err := json.MarshalIndent(saved, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, append(raw, '\n'), 0644); err != nil {
+		return nil, err
+	}
+
+	record := createRecord("invocation", penlog.PrioInfo, fmt.Sprintf("invocation saved to %s", path))
+	record["invocation"] = map[string]interface{}{
+		"version": version,
+		"inputs":  pflag.Args(),
+		"env":     penlogEnv(),
+		"config":  saved,
+	}
+	return record, nil
+}
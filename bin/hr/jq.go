@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+const jqStderrLimit = 4096
+
+// jqRunner is either jq(1) or the embedded gojq. Read returns the
+// filter output as JSON lines.
+type jqRunner interface {
+	io.Reader
+	// kill stops processing before the end of the input.
+	kill()
+	// wait returns descriptions of all problems.
+	wait() []string
+}
+
+// limitedBuffer keeps the beginning of the written data and silently
+// discards the rest, such that a chatty child cannot exhaust memory.
+type limitedBuffer struct {
+	buf   []byte
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if n := b.limit - len(b.buf); n > 0 {
+		if len(p) < n {
+			n = len(p)
+		}
+		b.buf = append(b.buf, p[:n]...)
+	}
+	return len(p), nil
+}
+
+// firstLine returns the first line, jq repeats runtime errors for
+// every input record.
+func (b *limitedBuffer) firstLine() string {
+	s := strings.TrimSpace(string(b.buf))
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// jqProcess pipes the input through jq(1). Data is copied through
+// OS pipes, so a slow reader blocks the feeder instead of piling up
+// data in memory.
+type jqProcess struct {
+	cmd     *exec.Cmd
+	stdout  io.ReadCloser
+	stderr  limitedBuffer
+	fed     chan struct{}
+	feedErr error
+	killed  bool
+}
+
+func startJQ(filter string, in io.Reader) (*jqProcess, error) {
+	cmd := exec.Command("jq", "-c", filter)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	p := &jqProcess{
+		cmd:    cmd,
+		stdout: stdout,
+		stderr: limitedBuffer{limit: jqStderrLimit},
+		fed:    make(chan struct{}),
+	}
+	cmd.Stderr = &p.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		_, err := io.Copy(stdin, in)
+		// EPIPE means that jq exited before consuming all the
+		// input; this is reported by its exit status instead.
+		if err != nil && !errors.Is(err, syscall.EPIPE) && !errors.Is(err, os.ErrClosed) {
+			p.feedErr = err
+		}
+		// fed is closed before jq can see EOF, thus a jq which
+		// consumed all input always observes the feeder result.
+		close(p.fed)
+		stdin.Close()
+	}()
+
+	return p, nil
+}
+
+func (p *jqProcess) Read(b []byte) (int, error) {
+	return p.stdout.Read(b)
+}
+
+// kill stops jq if the output is not needed anymore. The feeder then
+// fails with EPIPE unless it is blocked reading the input; in that
+// case it is abandoned.
+func (p *jqProcess) kill() {
+	p.killed = true
+	p.cmd.Process.Kill()
+}
+
+// wait reaps jq.
+func (p *jqProcess) wait() []string {
+	var problems []string
+
+	// Drain the remaining output such that jq does not block on a
+	// full pipe; Wait closes the pipe afterwards.
+	io.Copy(ioutil.Discard, p.stdout)
+	err := p.cmd.Wait()
+
+	select {
+	case <-p.fed:
+		if p.feedErr != nil {
+			problems = append(problems, fmt.Sprintf("reading input failed: %s", p.feedErr))
+		}
+	default:
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil, p.killed:
+	case errors.As(err, &exitErr):
+		var msg string
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			msg = fmt.Sprintf("jq was killed by signal %s", ws.Signal())
+		} else {
+			msg = fmt.Sprintf("jq failed with status %d", exitErr.ExitCode())
+		}
+		if s := p.stderr.firstLine(); s != "" {
+			msg += ": " + s
+		}
+		problems = append(problems, msg)
+	default:
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
+// transformJQ is transform with jq as a preprocessor. The embedded
+// implementation is used if requested or jq(1) is not installed.
+// Problems are reported as records of type "jq".
+func (c *converter) transformJQ(r io.Reader, filter string, native bool) {
+	var (
+		p   jqRunner
+		err error
+	)
+	if _, lookErr := exec.LookPath("jq"); native || lookErr != nil {
+		p, err = startJQNative(filter, r)
+	} else {
+		p, err = startJQ(filter, r)
+	}
+	if err != nil {
+		c.jqFailures++
+		c.printRecord(createRecord("jq", penlog.PrioError, err.Error()))
+		return
+	}
+	c.transform(p)
+	if c.stopped {
+		p.kill()
+	}
+	for _, problem := range p.wait() {
+		c.jqFailures++
+		c.printRecord(createRecord("jq", penlog.PrioError, problem))
+	}
+}
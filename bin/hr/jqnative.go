@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/itchyny/gojq"
+)
+
+// Exit codes of jq(1) which are mimicked by the native implementation.
+const (
+	jqExitError   = 5
+	jqExitCompile = 3
+	jqExitParse   = 2
+)
+
+// jqNative runs jq filters in-process using gojq. Problems are
+// reported with the same wording as for jq(1).
+type jqNative struct {
+	code    *gojq.Code
+	in      *bufio.Reader
+	buf     bytes.Buffer
+	line    int
+	done    bool
+	status  int
+	problem string
+}
+
+func startJQNative(filter string, in io.Reader) (*jqNative, error) {
+	query, err := gojq.Parse(filter)
+	if err != nil {
+		return nil, fmt.Errorf("jq failed with status %d: jq: error: %s", jqExitCompile, err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("jq failed with status %d: jq: error: %s", jqExitCompile, err)
+	}
+	return &jqNative{code: code, in: bufio.NewReader(in)}, nil
+}
+
+func (j *jqNative) fail(status int, msg string) {
+	if j.problem == "" {
+		j.status = status
+		j.problem = msg
+	}
+}
+
+func (j *jqNative) Read(p []byte) (int, error) {
+	for j.buf.Len() == 0 {
+		if j.done {
+			return 0, io.EOF
+		}
+		j.next()
+	}
+	return j.buf.Read(p)
+}
+
+func (j *jqNative) next() {
+	line, err := j.in.ReadBytes('\n')
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			j.fail(jqExitError, fmt.Sprintf("reading input failed: %s", err))
+		}
+		j.done = true
+	}
+	if len(bytes.TrimSpace(line)) == 0 {
+		return
+	}
+	j.line++
+
+	var v interface{}
+	if err := json.Unmarshal(line, &v); err != nil {
+		// jq cannot resynchronize after invalid input either.
+		j.fail(jqExitParse, fmt.Sprintf("jq: error (at <stdin>:%d): parse error: invalid JSON", j.line))
+		j.done = true
+		return
+	}
+
+	iter := j.code.Run(v)
+	for {
+		out, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, ok := out.(error); ok {
+			j.handleError(err)
+			if j.done {
+				return
+			}
+			continue
+		}
+		b, err := gojq.Marshal(out)
+		if err != nil {
+			j.fail(jqExitError, fmt.Sprintf("jq: error (at <stdin>:%d): %s", j.line, err))
+			continue
+		}
+		j.buf.Write(b)
+		j.buf.WriteByte('\n')
+	}
+}
+
+func (j *jqNative) handleError(err error) {
+	var halt interface {
+		IsHaltError() bool
+		ExitCode() int
+		Value() interface{}
+	}
+	if errors.As(err, &halt) && halt.IsHaltError() {
+		j.done = true
+		if code := halt.ExitCode(); code != 0 {
+			msg := fmt.Sprintf("jq: error (at <stdin>:%d): halted", j.line)
+			if v := halt.Value(); v != nil {
+				msg = formatDiffValue(v)
+			}
+			j.fail(code, msg)
+		}
+		return
+	}
+	msg := err.Error()
+	// Values raised with error/1 are shown as is by jq.
+	var valueErr gojq.ValueError
+	if errors.As(err, &valueErr) {
+		msg = formatDiffValue(valueErr.Value())
+	}
+	j.fail(jqExitError, fmt.Sprintf("jq: error (at <stdin>:%d): %s", j.line, msg))
+}
+
+func (j *jqNative) kill() {
+	j.done = true
+}
+
+func (j *jqNative) wait() []string {
+	if j.problem == "" {
+		return nil
+	}
+	return []string{fmt.Sprintf("jq failed with status %d: %s", j.status, j.problem)}
+}
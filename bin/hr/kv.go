@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import "strings"
+
+// liftKV converts records whose data is a list of "key=value" strings,
+// as written by some producers, such that they can be filtered and
+// rendered: the pairs are lifted into the object "kv", data becomes
+// the tokens joined by spaces, and the original list is kept in
+// "data_list". Tokens without "=" only remain in data; of repeated
+// keys the last one wins. Lists with other elements are left alone.
+func liftKV(data map[string]interface{}) bool {
+	list, ok := data["data"].([]interface{})
+	if !ok {
+		return false
+	}
+	var (
+		tokens = make([]string, 0, len(list))
+		kv     = make(map[string]interface{})
+	)
+	for _, item := range list {
+		token, ok := item.(string)
+		if !ok {
+			return false
+		}
+		tokens = append(tokens, token)
+		if i := strings.IndexByte(token, '='); i > 0 {
+			kv[token[:i]] = token[i+1:]
+		}
+	}
+	data["data"] = strings.Join(tokens, " ")
+	data["data_list"] = list
+	if len(kv) > 0 {
+		data["kv"] = kv
+	}
+	return true
+}
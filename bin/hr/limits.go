@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+// countRecord implements --max-records; processing stops after the
+// record which reaches the limit.
+func (c *converter) countRecord() {
+	if c.maxRecords <= 0 {
+		return
+	}
+	c.records++
+	if c.records >= c.maxRecords {
+		c.stopped = true
+		c.limited = true
+	}
+}
+
+func recordLimitEpilogue(limit int) map[string]interface{} {
+	record := createRecord("limit", penlog.PrioNotice, fmt.Sprintf("record limit of %d reached; stopping", limit))
+	record["limit"] = "records"
+	record["max_records"] = limit
+	return record
+}
+
+func durationLimitEpilogue(limit time.Duration) map[string]interface{} {
+	record := createRecord("limit", penlog.PrioNotice, fmt.Sprintf("time limit of %s reached; stopping", limit))
+	record["limit"] = "duration"
+	record["max_duration"] = limit.Seconds()
+	return record
+}
+
+// emitRecord shows the record and writes it to all files and clients,
+// e.g. such that truncated captures explain themselves.
+func (c *converter) emitRecord(record map[string]interface{}) {
+	c.printRecord(copyData(record))
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.cleanedUp {
+		return
+	}
+	if c.workers > 0 {
+		d := copyData(record)
+		c.stamp(d)
+		c.broadcastCh <- d
+	}
+	if c.server != nil {
+		d := copyData(record)
+		c.stamp(d)
+		c.publish(d)
+	}
+}
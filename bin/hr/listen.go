@@ -0,0 +1,273 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// clientConfig is a config entry for a client of --listen.
+type clientConfig struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	// CN authenticates the client by the common name of its
+	// verified certificate.
+	CN      string   `json:"cn"`
+	Filters []string `json:"filters"`
+}
+
+// defaultClientFilter is used for clients without filters.
+const defaultClientFilter = "records.log.json"
+
+const (
+	// collectorMaxBody limits the size of a request to --listen.
+	collectorMaxBody = 64 << 20
+	// collectorMaxLine limits the size of a record in a request.
+	collectorMaxLine = 1 << 20
+)
+
+type collectorClient struct {
+	name    string
+	token   []byte
+	cn      string
+	writers []chan map[string]interface{}
+}
+
+// collector receives records which clients POST as NDJSON to --listen.
+// Clients authenticate with a bearer token or a client certificate;
+// their records are stamped
+// with the field "client" and merged into the input of hr. With
+// --collect-dir, each client additionally has its own directory with
+// the files of its filters.
+type collector struct {
+	listener net.Listener
+	clients  []*collectorClient
+	r        *io.PipeReader
+	w        *io.PipeWriter
+	mutex    sync.Mutex
+	closed   bool
+	handlers sync.WaitGroup
+	workers  sync.WaitGroup
+}
+
+func checkClientName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid client name '%s'", name)
+	}
+	return nil
+}
+
+func newCollector(c *converter, addr string, cfg *tls.Config, cfgs []clientConfig, dir string) (*collector, error) {
+	if len(cfgs) == 0 {
+		return nil, errors.New("--listen requires clients in the config")
+	}
+	col := &collector{}
+	seen := make(map[string]bool)
+	for _, clientCfg := range cfgs {
+		if err := checkClientName(clientCfg.Name); err != nil {
+			return nil, err
+		}
+		if seen[clientCfg.Name] {
+			return nil, fmt.Errorf("duplicate client '%s'", clientCfg.Name)
+		}
+		seen[clientCfg.Name] = true
+		if clientCfg.Token == "" && clientCfg.CN == "" {
+			return nil, fmt.Errorf("client '%s' has neither token nor cn", clientCfg.Name)
+		}
+		if clientCfg.CN != "" && (cfg == nil || cfg.ClientCAs == nil) {
+			return nil, fmt.Errorf("client '%s': cn requires --tls-cert and --tls-client-ca", clientCfg.Name)
+		}
+		token, err := resolveSecret(clientCfg.Token)
+		if err != nil {
+			return nil, fmt.Errorf("client '%s': %w", clientCfg.Name, err)
+		}
+		client := &collectorClient{
+			name:  clientCfg.Name,
+			token: []byte(token),
+			cn:    clientCfg.CN,
+		}
+		if dir != "" {
+			if err := col.addClientFilters(c, client, dir, clientCfg.Filters); err != nil {
+				return nil, err
+			}
+		}
+		col.clients = append(col.clients, client)
+	}
+
+	ln, err := listen(addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	col.listener = ln
+	col.r, col.w = io.Pipe()
+	return col, nil
+}
+
+// addClientFilters starts a file worker for every filter of client.
+// Filenames are relative to the directory of the client.
+func (col *collector) addClientFilters(c *converter, client *collectorClient, dir string, specs []string) error {
+	clientDir := filepath.Join(dir, client.name)
+	if err := os.MkdirAll(clientDir, 0755); err != nil {
+		return err
+	}
+	if len(specs) == 0 {
+		specs = []string{defaultClientFilter}
+	}
+	for _, spec := range specs {
+		f, err := parseOutputFilter(spec)
+		if err != nil {
+			return fmt.Errorf("client '%s': %w", client.name, err)
+		}
+		if f.filename == "-" || filepath.IsAbs(f.filename) || strings.HasPrefix(filepath.Clean(f.filename), "..") {
+			return fmt.Errorf("client '%s': filter '%s' must write to a file in the client directory", client.name, spec)
+		}
+		file, err := os.Create(filepath.Join(clientDir, f.filename))
+		if err != nil {
+			return err
+		}
+		var stamper *timestamper
+		if c.tsaURL != "" {
+			if stamper, err = newTimestamper(c.tsaURL, file.Name(), c.formatter.ShowColors); err != nil {
+				return err
+			}
+		}
+		ch := make(chan map[string]interface{})
+		client.writers = append(client.writers, ch)
+		col.workers.Add(1)
+		go c.fileWorker(&col.workers, ch, file, f, stamper)
+	}
+	return nil
+}
+
+func (col *collector) serve() {
+	go http.Serve(col.listener, col)
+}
+
+func (col *collector) Read(p []byte) (int, error) {
+	return col.r.Read(p)
+}
+
+// authenticate prefers the verified client certificate and compares
+// the token with all clients in constant time otherwise.
+func (col *collector) authenticate(r *http.Request) *collectorClient {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		for _, client := range col.clients {
+			if client.cn != "" && client.cn == cn {
+				return client
+			}
+		}
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return nil
+	}
+	var found *collectorClient
+	for _, client := range col.clients {
+		if len(client.token) > 0 && subtle.ConstantTimeCompare([]byte(token), client.token) == 1 {
+			found = client
+		}
+	}
+	return found
+}
+
+func (col *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	client := col.authenticate(r)
+	if client == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	col.mutex.Lock()
+	if col.closed {
+		col.mutex.Unlock()
+		http.Error(w, "the collector has stopped", http.StatusServiceUnavailable)
+		return
+	}
+	col.handlers.Add(1)
+	col.mutex.Unlock()
+	defer col.handlers.Done()
+
+	// Records are processed as they arrive; those before an
+	// oversized record or body are kept.
+	var (
+		body   = &countingReader{r: http.MaxBytesReader(w, r.Body, collectorMaxBody)}
+		reader = bufio.NewReaderSize(body, collectorMaxLine)
+	)
+	for {
+		line, err := reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			http.Error(w, fmt.Sprintf("record exceeds %d bytes", collectorMaxLine), http.StatusRequestEntityTooLarge)
+			return
+		}
+		// A broken connection must not leave a truncated record; a
+		// missing newline at the end of the body is fine.
+		if err == nil || (errors.Is(err, io.EOF) && len(line) > 0) {
+			if err := col.receive(client, line); err != nil {
+				http.Error(w, "the collector has stopped", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && body.n >= collectorMaxBody {
+				http.Error(w, fmt.Sprintf("body exceeds %d bytes", collectorMaxBody), http.StatusRequestEntityTooLarge)
+				return
+			}
+			break
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (col *collector) receive(client *collectorClient, line []byte) error {
+	line = []byte(strings.TrimSpace(string(line)))
+	if len(line) == 0 {
+		return nil
+	}
+	data, err := decodeRecord(line)
+	if err != nil {
+		data = createErrorRecord(string(line))
+	}
+	data["client"] = client.name
+	for _, ch := range client.writers {
+		ch <- copyData(data)
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil
+	}
+	_, err = col.w.Write(append(raw, '\n'))
+	return err
+}
+
+// close stops accepting records and closes the files of the clients.
+func (col *collector) close() {
+	col.mutex.Lock()
+	col.closed = true
+	col.mutex.Unlock()
+	col.listener.Close()
+	// Pending writes fail and the input of hr ends.
+	col.w.Close()
+	col.handlers.Wait()
+	for _, client := range col.clients {
+		for _, ch := range client.writers {
+			close(ch)
+		}
+	}
+	col.workers.Wait()
+}
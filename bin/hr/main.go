@@ -4,21 +4,28 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"runtime/pprof"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"codeberg.org/rumpelsepp/helpers"
+	"github.com/Fraunhofer-AISEC/penlog/filter"
+	"github.com/Fraunhofer-AISEC/penlog/pipeline"
 	penlog "github.com/Fraunhofer-AISEC/penlogger"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/klauspost/compress/zstd"
@@ -27,110 +34,325 @@ import (
 
 var (
 	version string
-	json    = jsoniter.ConfigCompatibleWithStandardLibrary
+	// Same as jsoniter.ConfigCompatibleWithStandardLibrary, but
+	// payloads with '<', '>', and '&' are written as they are.
+	json = jsoniter.Config{
+		EscapeHTML:             false,
+		SortMapKeys:            true,
+		ValidateJsonRawMessage: true,
+		// Numbers are kept as json.Number such that 64-bit
+		// integers do not lose precision; see fieldNumber.
+		UseNumber: true,
+	}.Froze()
 )
 
 var (
 	errInvalidData = errors.New("Invalid data")
 )
 
+const (
+	// exitMatched is the exit code if processing was stopped by --until-match.
+	exitMatched = 3
+	// exitTimeout is the exit code if --wait-for timed out; same as timeout(1).
+	exitTimeout = 124
+)
+
 type compressor interface {
 	io.WriteCloser
 	Flush() error
 }
 
 type converter struct {
-	formatter    *penlog.HRFormatter
-	logFmt       string
-	logLevel     penlog.Prio
-	filters      []*filter
-	stdoutFilter *filter
-	id           string
-	volatileInfo bool
+	formatter   *penlog.HRFormatter
+	renderers   []*renderer
+	decoders    []*fieldDecoder
+	decodeLimit int64
+	// syncRecords and syncInterval define the sync points of file
+	// outputs; zero disables them.
+	syncRecords  int
+	syncInterval time.Duration
+	// sinkFailures counts the file outputs which fell back to
+	// stderr.
+	sinkFailures int32
+	// tsaURL is the time-stamping authority for file outputs.
+	tsaURL        string
+	tsaInterval   time.Duration
+	liftKV        bool
+	hmacVerifier  *hmacVerifier
+	differ        *differ
+	tui           *tui
+	checkpoints   *checkpoints
+	logFmt        string
+	logLevel      penlog.Prio
+	prioOverrides []prioOverride
+	filters       []*outputFilter
+	stdoutFilters []*outputFilter
+	explainer     *explainer
+	id            string
+	volatileInfo  bool
+	untilMatch    *filter.Expression
+	quiet         bool
+	stopped       bool
+	maxRecords    int
+	records       int
+	limited       bool
+	jqFailures    int
+	strict        bool
+	showPriority  string
+	phaseClock    *phaseClock
+	phaseBudgets  *phaseBudgets
+	orderChecker  *orderChecker
+	streamChecker *streamChecker
+	output        string
+	printedJSON   bool
+	server        *streamServer
+	control       *controlSocket
+	pacer         *pacer
+	pacerFull     sync.Once
+	collector     *collector
+	runID         string
+	stacktraces   *stacktraceFolder
+	then          *pipeline.Pipeline
+	grouper       *grouper
+	session       *sessionLog
+	expandErrors  bool
+	narrow        *narrowProfile
+	columns       *columnLayout
+	provenance    *provenance
 
+	cleanupOnce sync.Once
 	cleanedUp   bool
+	// aborted is set if hr stops before the end of the input, e.g.
+	// on a signal; spooled records are dropped instead of drained.
+	aborted     int32
 	workers     int
 	broadcastCh chan map[string]interface{}
 	writers     []chan map[string]interface{}
 	mutex       sync.Mutex
+	// stdoutMutex serializes stdout between transform and the
+	// records of timers, e.g. of --stats-interval.
+	stdoutMutex sync.Mutex
 	wg          sync.WaitGroup
 }
 
+// abort stops hr before the end of the input. It does not take the
+// mutex, as cleanup may be draining the spool of --max-rate.
+func (c *converter) abort() {
+	if !atomic.CompareAndSwapInt32(&c.aborted, 0, 1) {
+		return
+	}
+	if c.pacer != nil {
+		if n := c.pacer.abort(); n > 0 {
+			colorEprintf(colorYellow, c.formatter.ShowColors, "warning: dropped %d records spooled by --max-rate\n", n)
+		}
+	}
+}
+
+func (c *converter) isAborted() bool {
+	return atomic.LoadInt32(&c.aborted) == 1
+}
+
+// cleanup flushes and closes all outputs. Concurrent calls wait until
+// the first one has finished.
 func (c *converter) cleanup() {
+	c.cleanupOnce.Do(c.shutdown)
+}
+
+func (c *converter) shutdown() {
 	c.mutex.Lock()
-	if c.cleanedUp {
-		c.mutex.Unlock()
-		return
+	if c.collector != nil {
+		c.collector.close()
 	}
 	if c.workers > 0 {
 		close(c.broadcastCh)
 		c.wg.Wait()
 	}
+	if c.checkpoints != nil {
+		c.checkpoints.stop()
+	}
+	if c.grouper != nil {
+		c.grouper.close()
+	}
 	c.cleanedUp = true
 	c.mutex.Unlock()
+
+	// The spool is drained without the mutex, such that a signal
+	// can abort it.
+	if c.pacer != nil && !c.isAborted() {
+		c.pacer.close()
+	}
+	if c.server != nil {
+		c.server.close()
+	}
+	if c.control != nil {
+		c.control.close()
+	}
+	if c.phaseBudgets != nil {
+		c.phaseBudgets.stop()
+	}
+	if c.session != nil {
+		c.session.close()
+	}
 }
 
 func (c *converter) addFilterSpecs(specs []string) error {
 	for _, spec := range specs {
-		switch determineFilterType(spec) {
-		case filterTypeSimple:
-			filter, err := parseSimpleFilter(spec)
-			if err != nil {
-				return err
-			}
-			// stdout requires special treatment.
-			if filter.simpleSpec.filename == "-" {
-				c.stdoutFilter = filter
-				continue
-			}
+		f, err := parseOutputFilter(spec)
+		if err != nil {
+			return err
+		}
+		// stdout requires special treatment.
+		if f.filename == "-" {
+			c.stdoutFilters = append(c.stdoutFilters, f)
+			continue
+		}
 
-			file, err := os.Create(filter.simpleSpec.filename)
-			if err != nil {
+		c.filters = append(c.filters, f)
+		file, err := os.Create(f.filename)
+		if err != nil {
+			return err
+		}
+
+		var stamper *timestamper
+		if c.tsaURL != "" {
+			if stamper, err = newTimestamper(c.tsaURL, f.filename, c.formatter.ShowColors); err != nil {
 				return err
 			}
-
-			dataCh := make(chan map[string]interface{})
-			c.workers++
-			c.writers = append(c.writers, dataCh)
-			go c.fileWorker(&c.wg, dataCh, file, filter)
-		default:
-			panic("BUG: bogos filter spec")
 		}
+
+		dataCh := make(chan map[string]interface{})
+		c.workers++
+		c.writers = append(c.writers, dataCh)
+		go c.fileWorker(&c.wg, dataCh, file, f, stamper)
 	}
 	c.initializeOutstreams()
 	return nil
 }
 
+// addStdoutCondition adds a filter which only applies to stdout. The
+// spec is a single condition; it is not split at ';'.
+func (c *converter) addStdoutCondition(option, spec string) error {
+	cond, err := filter.ParseCondition(spec)
+	if err != nil {
+		return err
+	}
+	f := &filter.Filter{
+		Kind:   filter.KindExpr,
+		Expr:   &filter.Expression{Conditions: []*filter.Condition{cond}},
+		Output: "-",
+	}
+	c.stdoutFilters = append(c.stdoutFilters, &outputFilter{
+		spec:     f,
+		filename: "-",
+		source:   fmt.Sprintf("%s '%s'", option, cond.Value),
+	})
+	return nil
+}
+
+// filterSources describes the filters of stdout by the options which
+// created them.
+func (c *converter) filterSources() []string {
+	var sources []string
+	for _, f := range c.stdoutFilters {
+		sources = append(sources, f.source)
+	}
+	if c.id != "" {
+		sources = append(sources, fmt.Sprintf("--id '%s'", c.id))
+	}
+	return sources
+}
+
+func prioName(prio penlog.Prio) string {
+	switch prio {
+	case penlog.PrioTrace:
+		return "trace"
+	case penlog.PrioDebug:
+		return "debug"
+	case penlog.PrioInfo:
+		return "info"
+	case penlog.PrioNotice:
+		return "notice"
+	case penlog.PrioWarning:
+		return "warning"
+	case penlog.PrioError:
+		return "error"
+	case penlog.PrioCritical:
+		return "critical"
+	case penlog.PrioAlert:
+		return "alert"
+	case penlog.PrioEmergency:
+		return "emergency"
+	}
+	return strconv.Itoa(int(prio))
+}
+
+// addPriorityColumn prefixes data with the priority as a padded
+// column, since colors do not survive copy and paste.
+func (c *converter) addPriorityColumn(data map[string]interface{}) {
+	var (
+		col   string
+		width = len("emergency")
+	)
+	if c.showPriority == "number" {
+		width = 1
+	}
+	if p, ok := fieldPrio(data); ok {
+		if c.showPriority == "number" {
+			col = strconv.Itoa(int(p))
+		} else {
+			col = prioName(p)
+		}
+	}
+	payload, _ := fieldString(data, "data")
+	data["data"] = padOrTruncate(col, width) + " " + payload
+}
+
 func (c *converter) addPrioFilter(spec string) error {
-	if val, err := strconv.ParseInt(spec, 10, 64); err == nil {
-		c.logLevel = penlog.Prio(val)
-		return nil
-	}
-	switch strings.ToLower(spec) {
-	case "trace":
-		c.logLevel = penlog.PrioTrace
-	case "debug":
-		c.logLevel = penlog.PrioDebug
-	case "info":
-		c.logLevel = penlog.PrioInfo
-	case "notice":
-		c.logLevel = penlog.PrioNotice
-	case "warning":
-		c.logLevel = penlog.PrioWarning
-	case "error":
-		c.logLevel = penlog.PrioError
-	case "critical":
-		c.logLevel = penlog.PrioCritical
-	case "alert":
-		c.logLevel = penlog.PrioAlert
-	case "emergency":
-		c.logLevel = penlog.PrioEmergency
-	default:
-		return fmt.Errorf("invalid loglevel '%s'", spec)
+	prio, err := filter.ParsePrio(spec)
+	if err != nil {
+		return err
+	}
+	c.logLevel = prio
+	return nil
+}
+
+// prioOverride replaces the priority threshold of stdout for the
+// components matching pattern.
+type prioOverride struct {
+	pattern string
+	prio    penlog.Prio
+}
+
+// addPrioOverride parses "comp=level"; comp is a case insensitive glob.
+func (c *converter) addPrioOverride(spec string) error {
+	i := strings.LastIndex(spec, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid priority override '%s': expected component=level", spec)
+	}
+	pattern := strings.ToLower(strings.TrimSpace(spec[:i]))
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid priority override '%s': %w", spec, err)
 	}
+	prio, err := filter.ParsePrio(strings.TrimSpace(spec[i+1:]))
+	if err != nil {
+		return fmt.Errorf("invalid priority override '%s': %w", spec, err)
+	}
+	c.prioOverrides = append(c.prioOverrides, prioOverride{pattern: pattern, prio: prio})
 	return nil
 }
 
+// threshold returns the priority threshold of stdout for a record of
+// comp. The first matching override applies.
+func (c *converter) threshold(comp string, level penlog.Prio) penlog.Prio {
+	comp = strings.ToLower(comp)
+	for _, o := range c.prioOverrides {
+		if ok, _ := path.Match(o.pattern, comp); ok {
+			return o.prio
+		}
+	}
+	return level
+}
+
 func (c *converter) initializeOutstreams() {
 	if c.workers > 0 {
 		c.workers++
@@ -150,10 +372,25 @@ func fPrintError(w io.Writer, msg string) {
 	fmt.Fprintln(w, string(str))
 }
 
+// printRecord shows a record which hr creates itself. It is safe for
+// concurrent use.
+func (c *converter) printRecord(record map[string]interface{}) {
+	if c.tui != nil {
+		c.tui.add(record)
+		return
+	}
+	c.stdoutMutex.Lock()
+	defer c.stdoutMutex.Unlock()
+	if c.output != outputHR {
+		c.printJSON(record)
+		return
+	}
+	str, _ := c.formatter.Format(record)
+	fmt.Println(str)
+}
+
 func (c *converter) printError(msg string) {
-	line := createErrorRecord(msg)
-	str, _ := c.formatter.Format(line)
-	fmt.Print(str)
+	c.printRecord(createErrorRecord(strings.TrimRight(msg, "\n")))
 }
 
 func (c *converter) transform(r io.Reader) {
@@ -162,22 +399,29 @@ func (c *converter) transform(r io.Reader) {
 		jsonLine    []byte
 		reader      = bufio.NewReader(r)
 		cursorReset = false
+		lineno      = 0
 	)
 	// ErrUnexpectedEOF occurs when reading a compressed file which is not yet
 	// finalized. Let's just error out in this case.
-	for !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+	for !c.stopped && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
 		jsonLine, err = reader.ReadBytes('\n')
+		lineno++
 		if err != nil {
 			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				// Decoders keep failing, e.g. on corrupt files.
 				c.printError(err.Error())
+				break
 			}
 			continue
 		}
+		if len(bytes.TrimSpace(jsonLine)) == 0 {
+			continue
+		}
 		var (
 			data         map[string]interface{}
 			deferredCont = false
 		)
-		if err := json.Unmarshal(jsonLine, &data); err != nil {
+		if data, err = decodeRecord(jsonLine); err != nil {
 			c.printError(string(jsonLine))
 			deferredCont = true
 			// If there are workers avail, send
@@ -186,6 +430,45 @@ func (c *converter) transform(r io.Reader) {
 			// as well.
 			data = createErrorRecord(string(jsonLine))
 		}
+		// The chain covers the record as it was read, hence it is
+		// verified before anything rewrites it.
+		if c.hmacVerifier != nil {
+			if problem := c.hmacVerifier.verify(data); problem != "" {
+				c.printRecord(createRecord("hmac", penlog.PrioError, problem))
+			}
+		}
+		if c.strict && !deferredCont {
+			for _, v := range validateRecord(data) {
+				c.printRecord(createRecord("strict", penlog.PrioWarning, fmt.Sprintf("line %d: %s", lineno, v)))
+			}
+		}
+		if c.liftKV && !deferredCont {
+			liftKV(data)
+		}
+		if c.orderChecker != nil && !deferredCont {
+			if regression, ok := c.orderChecker.check(data); ok {
+				c.printRecord(createRecord("order", penlog.PrioWarning, fmt.Sprintf("line %d: timestamp regressed by %s", lineno, regression)))
+			}
+		}
+		if c.streamChecker != nil && !deferredCont {
+			for _, msg := range c.streamChecker.check(data) {
+				c.printRecord(createRecord("stream", penlog.PrioWarning, fmt.Sprintf("line %d: %s", lineno, msg)))
+			}
+		}
+		if c.checkpoints != nil {
+			c.checkpoints.add(data)
+		}
+		if msgType, _ := fieldString(data, "type"); msgType == "capabilities" && !deferredCont {
+			for _, record := range c.capabilities(data) {
+				c.printRecord(record)
+			}
+		}
+		// The matching record is still processed; the loop
+		// terminates afterwards.
+		if c.untilMatch != nil && c.untilMatch.Match(data) {
+			c.stopped = true
+		}
+		c.countRecord()
 		if c.workers > 0 {
 			c.mutex.Lock()
 			// Avoid sends on closed channel by signal handler.
@@ -194,6 +477,7 @@ func (c *converter) transform(r io.Reader) {
 				break
 			}
 			d := copyData(data)
+			c.stamp(d)
 			c.broadcastCh <- d
 			c.mutex.Unlock()
 		}
@@ -206,25 +490,44 @@ func (c *converter) transform(r io.Reader) {
 			err error
 			d   = copyData(data)
 		)
-		if c.stdoutFilter != nil {
-			d, err = c.stdoutFilter.filter(d)
-			if err != nil {
-				c.printError(string(jsonLine))
-				continue
-			}
-			if d == nil {
-				continue
+		normalizeRecord(d)
+		// Phase records are tracked before filtering such that a
+		// filtered phase-start still begins the phase.
+		var (
+			elapsed time.Duration
+			inPhase bool
+		)
+		if c.phaseClock != nil {
+			elapsed, inPhase = c.phaseClock.elapsed(d)
+		}
+		if c.phaseBudgets != nil {
+			c.phaseBudgets.track(d)
+		}
+		if c.explainer != nil && c.explainer.wants(d) {
+			c.printRecord(c.explain(d, lineno))
+		}
+		for _, filter := range c.stdoutFilters {
+			d, err = filter.filter(d)
+			if err != nil || d == nil {
+				break
 			}
 		}
+		if err != nil {
+			c.printError(string(jsonLine))
+			continue
+		}
+		if d == nil {
+			continue
+		}
 
 		var priority penlog.Prio
 
-		if prio, ok := d["priority"]; ok {
-			if p, ok := prio.(float64); ok {
-				priority = penlog.Prio(p)
-				if priority > c.logLevel {
-					continue
-				}
+		if p, ok := fieldPrio(d); ok {
+			priority = p
+			// The TUI applies the priority threshold itself.
+			comp, _ := fieldString(d, "component")
+			if priority > c.threshold(comp, c.logLevel) && c.tui == nil {
+				continue
 			}
 		}
 		if idRaw, ok := d["id"]; ok && c.id != "" {
@@ -234,7 +537,64 @@ func (c *converter) transform(r io.Reader) {
 				}
 			}
 		}
-		if hrLine, err := c.formatter.Format(d); err == nil {
+		// In quiet mode only the record which stopped processing is shown.
+		if c.quiet && !c.stopped {
+			continue
+		}
+		for _, dec := range c.decoders {
+			if err := dec.decode(d); err != nil {
+				c.printError(err.Error())
+			}
+		}
+		if c.control != nil {
+			c.control.publish(d)
+		}
+		if c.server != nil {
+			if c.runID != "" || c.provenance != nil {
+				published := copyData(d)
+				c.stamp(published)
+				c.publish(published)
+			} else {
+				c.publish(d)
+			}
+		}
+		if c.then != nil {
+			if d, err = c.then.Process(d); err != nil {
+				c.printError(err.Error())
+				continue
+			}
+			if d == nil {
+				continue
+			}
+		}
+		if c.tui != nil {
+			c.tui.add(c.diffed(d))
+			continue
+		}
+		if c.grouper != nil {
+			var line string
+			if c.output == outputHR {
+				if line, err = c.renderShown(c.diffed(copyData(d)), inPhase, elapsed); err != nil {
+					msg := string(jsonLine)
+					if errors.Is(err, errInvalidData) {
+						msg = err.Error()
+					}
+					line, _ = c.formatter.Format(createErrorRecord(msg))
+				}
+			}
+			if err := c.grouper.add(d, line); err != nil {
+				c.printError(err.Error())
+			}
+			continue
+		}
+		if c.output != outputHR {
+			c.stdoutMutex.Lock()
+			c.printJSON(d)
+			c.stdoutMutex.Unlock()
+			continue
+		}
+		if hrLine, err := c.renderShown(c.diffed(d), inPhase, elapsed); err == nil {
+			c.stdoutMutex.Lock()
 			if c.volatileInfo && isatty(uintptr(syscall.Stdout)) {
 				// If the cursor has been reset, the line has to be cleared
 				// before new content can be written
@@ -253,6 +613,7 @@ func (c *converter) transform(r io.Reader) {
 			} else {
 				fmt.Println(hrLine)
 			}
+			c.stdoutMutex.Unlock()
 		} else {
 			if errors.Is(err, errInvalidData) {
 				c.printError(err.Error())
@@ -266,39 +627,209 @@ func (c *converter) transform(r io.Reader) {
 	}
 }
 
-func (c *converter) fileWorker(wg *sync.WaitGroup, data chan map[string]interface{}, file *os.File, fil *filter) {
+// diffed applies --diff-fields in place to a record which is about to
+// be rendered; JSON output, files, and --then stages keep the full
+// records.
+func (c *converter) diffed(data map[string]interface{}) map[string]interface{} {
+	if c.differ == nil {
+		return data
+	}
+	c.differ.diff(data)
+	return data
+}
+
+// renderShown renders a record shown on stdout in the hr format,
+// including the priority column, phase times, errors and stacktraces.
+// data is modified.
+func (c *converter) renderShown(data map[string]interface{}, inPhase bool, elapsed time.Duration) (string, error) {
+	if c.showPriority != "" && !c.narrow.isActive() {
+		c.addPriorityColumn(data)
+	}
+	var trace string
+	if c.stacktraces != nil {
+		data, trace = c.stacktraces.take(data)
+	}
+	hrLine, err := c.render(data)
+	if err != nil {
+		return "", err
+	}
+	if inPhase {
+		hrLine = c.replaceTimestamp(hrLine, data, elapsed)
+	}
+	if recErr, ok := parseRecordError(data["error"]); ok {
+		hrLine += recErr.render(c.expandErrors, c.formatter.ShowColors)
+	}
+	if trace != "" {
+		hrLine += c.stacktraces.render(data, trace, c.formatter.ShowColors)
+	}
+	return hrLine, nil
+}
+
+// fileWorker writes the records of a file output. If stamper is not
+// nil, timestamps are requested for the digest of the uncompressed
+// output at sync points.
+func (c *converter) fileWorker(wg *sync.WaitGroup, data chan map[string]interface{}, file *os.File, fil *outputFilter, stamper *timestamper) {
 	var (
-		fileWriter *bufio.Writer
-		comp       compressor
+		out    io.Writer = file
+		comp   compressor
+		hashed *hashingWriter
 	)
 
 	switch filepath.Ext(file.Name()) {
 	case ".gz":
 		comp = gzip.NewWriter(file)
-		fileWriter = bufio.NewWriter(comp)
+		out = comp
 	case ".zst":
-		// error is always nil without options.
-		comp, _ = zstd.NewWriter(file)
-		fileWriter = bufio.NewWriter(comp)
-	default:
-		fileWriter = bufio.NewWriter(file)
+		// The options are validated by parseSinkOptions.
+		comp, _ = zstd.NewWriter(file, fil.sink.zstdOptions()...)
+		out = comp
+	}
+	if stamper != nil {
+		hashed = newHashingWriter(out)
+		out = hashed
 	}
+	fileWriter := bufio.NewWriter(out)
 
-	encoder := json.NewEncoder(fileWriter)
-	for line := range data {
-		l, err := fil.filter(line)
-		if l == nil || err != nil {
-			continue
+	var (
+		encoder   = json.NewEncoder(fileWriter)
+		formatter *penlog.HRFormatter
+		fix       *fixture
+		// pending counts the records since the last sync point;
+		// closed is set if a sync point ended the compressed
+		// stream and no record was written since.
+		pending   int
+		closed    bool
+		tick      <-chan time.Time
+		lastStamp = time.Now()
+		fallback  = newSinkFallback(file.Name(), fil.sink.format, c.formatter, &c.sinkFailures)
+	)
+	if fil.sink.format == sinkFormatFixture {
+		fix = newFixture()
+	}
+	// Sync points are opt-in, as every frame starts with an empty
+	// window. Without --sync-interval, --tsa-interval adds the sync
+	// points which intermediate timestamps need.
+	interval := c.syncInterval
+	if interval == 0 && stamper != nil {
+		interval = c.tsaInterval
+	}
+	if interval > 0 && fil.sink.format != sinkFormatFixture {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	// syncPoint writes everything to the file such that it stays
+	// readable up to here if hr is killed. Compressed streams are
+	// closed, i.e. a zstd frame or gzip member ends; the next record
+	// starts a new one.
+	syncPoint := func() {
+		if pending == 0 || fallback.failed {
+			return
+		}
+		err := fileWriter.Flush()
+		if comp != nil {
+			if cerr := comp.Close(); err == nil {
+				err = cerr
+			}
+			closed = true
+		}
+		pending = 0
+		if err != nil {
+			fallback.fail(err)
+			return
+		}
+		fallback.synced()
+		if stamper != nil && time.Since(lastStamp) >= c.tsaInterval {
+			stamper.stamp(hashed)
+			lastStamp = time.Now()
 		}
-		encoder.Encode(l)
+	}
+	write := func(l map[string]interface{}) error {
+		if closed {
+			switch w := comp.(type) {
+			case *gzip.Writer:
+				w.Reset(file)
+			case *zstd.Encoder:
+				w.Reset(file)
+			}
+			closed = false
+		}
+		switch fil.sink.format {
+		case sinkFormatJSON:
+			return encoder.Encode(l)
+		case sinkFormatFixture:
+			fix.add(l)
+			return nil
+		}
+		// The formatter is configured completely once records flow.
+		if formatter == nil {
+			f := *c.formatter
+			f.ShowColors = fil.sink.showColors(false)
+			formatter = &f
+		}
+		d := copyData(l)
+		normalizeRecord(d)
+		str, err := formatter.Format(d)
+		if err != nil {
+			// Same as on stdout: show the raw record as an error.
+			raw, _ := json.Marshal(l)
+			str, _ = formatter.Format(createErrorRecord(string(raw)))
+		}
+		_, err = fileWriter.WriteString(str + "\n")
+		return err
 	}
 
-	fileWriter.Flush()
-	if comp != nil {
+loop:
+	for {
+		select {
+		case line, ok := <-data:
+			if !ok {
+				break loop
+			}
+			l, err := fil.filter(line)
+			if l == nil || err != nil {
+				continue
+			}
+			if fallback.failed {
+				fallback.write(l)
+				continue
+			}
+			fallback.keep(l)
+			if err := write(l); err != nil {
+				fallback.fail(err)
+				continue
+			}
+			pending++
+			if c.syncRecords > 0 && pending >= c.syncRecords && fix == nil {
+				syncPoint()
+			}
+		case <-tick:
+			syncPoint()
+		}
+	}
+
+	var err error
+	if fix != nil && !fallback.failed {
+		if err = fix.write(fileWriter); err != nil {
+			fallback.fail(err)
+		}
+	}
+	if err = fileWriter.Flush(); err == nil && comp != nil && !closed {
 		comp.Flush()
-		comp.Close()
+		err = comp.Close()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fallback.fail(err)
+	}
+	if stamper != nil {
+		if !fallback.failed {
+			stamper.stamp(hashed)
+		}
+		stamper.close()
 	}
-	file.Close()
 	wg.Done()
 }
 
@@ -321,29 +852,165 @@ func main() {
 		err           error
 		filterSpecs   []string
 		prioLevelRaw  string
+		prioOverrides []string
 		colorsCli     bool
 		linesCli      bool
 		stacktraceCli bool
+		expandTraces  bool
 		hrFormatRaw   string
+		configPath    string
+		saveInvoc     string
+		narrowWidth   int
+		columnsRaw    string
+		maxRate       string
+		untilMatchRaw string
+		waitForRaw    string
+		timeout       time.Duration
+		maxDuration   time.Duration
+		explainSample int
+		explainIDs    []string
+		follow        bool
+		since         string
+		until         string
+		grep          string
+		target        string
+		groupBy       string
+		groupDir      string
+		sessionDir    string
+		validateCli   bool
+		statsCli      bool
+		blameWindow   time.Duration
+		reportFormat  string
+		repair        bool
+		verifyTSA     bool
+		tsaCA         string
+		statsFormat   string
+		statsBucket   time.Duration
+		statsTop      int
+		statsInterval time.Duration
+		statsFile     string
+		inputFormat   string
+		jqFilter      string
+		jqNative      bool
+		decodeSpecs   []string
+		decodeLimit   int64
+		hmacKeyFile   string
+		diffFields    bool
+		interactive   bool
+		marksFile     string
+		thenStages    []string
+		phaseTime     bool
+		inputURL      string
+		inputToken    string
+		serveToken    string
+		serveAddr     string
+		controlPath   string
+		listenAddr    string
+		tlsOpts       tlsOptions
+		collectDir    string
+		runIDSpec     string
+		withVia       bool
+		phaseBudgets  []string
+		budgetExec    string
+		checkOrder    bool
+		checkStream   bool
+		timeJump      time.Duration
+		orderThresh   time.Duration
 		conv          = converter{
 			formatter:   penlog.NewHRFormatter(),
 			workers:     0,
 			broadcastCh: make(chan map[string]interface{}),
-			cleanedUp:   false,
 		}
 	)
 
 	pflag.BoolVar(&colorsCli, "show-colors", true, "enable colorized output based on priorities")
 	pflag.BoolVar(&linesCli, "show-lines", false, "show line numbers if available")
 	pflag.BoolVar(&stacktraceCli, "show-stacktraces", false, "show stacktrace if available")
+	pflag.BoolVar(&expandTraces, "expand-stacktraces", false, "show repeated stacktraces in full instead of a reference")
+	pflag.BoolVar(&conv.expandErrors, "expand-errors", false, "show the causes and stacktrace of structured errors")
 	pflag.BoolVar(&conv.formatter.ShowID, "show-ids", false, "show unique message id")
 	pflag.BoolVar(&conv.formatter.ShowTags, "show-tags", false, "show penlog message tags")
 	pflag.StringVarP(&conv.id, "id", "i", "", "only show this particular message")
+	pflag.IntVar(&explainSample, "explain", 0, "explain why the first `n` records are shown or dropped")
+	pflag.Lookup("explain").NoOptDefVal = "10"
+	pflag.StringSliceVar(&explainIDs, "explain-id", []string{}, "explain why the records with these `ids` are shown or dropped")
 	pflag.IntVarP(&conv.formatter.CompLen, "complen", "c", 8, "len of component field")
 	pflag.IntVarP(&conv.formatter.TypeLen, "typelen", "t", 8, "len of type field")
+	pflag.StringVar(&columnsRaw, "columns", "", "render these `columns` in order: timestamp, host, component, target, type, prio, data, line")
 	pflag.StringVarP(&prioLevelRaw, "priority", "p", "debug", "show messages with a lower priority level")
+	pflag.StringArrayVar(&prioOverrides, "prio-override", nil, "use another priority level for components matching a glob, e.g. `doip=debug`")
+	pflag.StringVarP(&jqFilter, "jq", "j", "", "preprocess the input with jq(1) using `filter`")
+	pflag.BoolVar(&jqNative, "jq-native", false, "always use the embedded jq implementation for --jq")
 	pflag.StringVarP(&hrFormatRaw, "hr-format", "F", "hr-full", "specify hr format: hr-full, hr-tiny, hr-nona")
 	pflag.StringArrayVarP(&filterSpecs, "filter", "f", []string{}, "write logs to a file with filters")
+	pflag.BoolVar(&conv.liftKV, "lift-kv", false, "lift data lists of key=value strings into the object kv")
+	pflag.StringArrayVar(&decodeSpecs, "decode-field", []string{}, "decode a field before rendering, e.g. data=base64+gzip")
+	pflag.Int64Var(&decodeLimit, "decode-limit", 1<<20, "maximum size in bytes of a decoded field")
+	pflag.StringVar(&hmacKeyFile, "verify-hmac", "", "verify the hmac hash chain with the key in `file`")
+	pflag.BoolVar(&diffFields, "diff-fields", false, "only show fields which changed since the previous record of the same component and type")
+	pflag.BoolVarP(&interactive, "interactive", "I", false, "browse records in a terminal user interface")
+	pflag.StringVar(&marksFile, "marks", "", "write the records marked in --interactive to `file` (default hr-marks-TIMESTAMP.txt)")
+	pflag.StringArrayVar(&thenStages, "then", nil, "pass the shown records to a `stage` in the same process: stats, fixture")
+	pflag.StringVar(&configPath, "config", "", "read config from `file`")
+	pflag.StringVar(&sessionDir, "session-log", "", "keep a copy of stdout with a header describing the invocation in `dir`")
+	pflag.StringVar(&saveInvoc, "save-invocation", "", "save flags and config to `file` and record them in a preamble")
+	pflag.StringVar(&since, "since", "", "only show records at or after `timestamp`")
+	pflag.StringVar(&until, "until", "", "only show records at or before `timestamp`")
+	pflag.StringVar(&grep, "grep", "", "only show records whose data matches `regex`")
+	pflag.StringVar(&target, "target", "", "only show records of targets matching the glob `pattern`")
+	pflag.StringVar(&groupBy, "group-by", "", "show the records in sections per `key` with summaries: target, component")
+	pflag.StringVar(&groupDir, "group-dir", "", "write the records of each group to a file in `dir` instead")
+	pflag.StringVar(&untilMatchRaw, "until-match", "", "stop processing after the first record matching `expr`")
+	pflag.StringVar(&waitForRaw, "wait-for", "", "only show the first record matching `expr` and exit")
+	pflag.DurationVar(&timeout, "timeout", 0, "give up waiting for --wait-for after this duration")
+	pflag.DurationVar(&maxDuration, "max-duration", 0, "stop processing after this `duration`")
+	pflag.IntVar(&conv.maxRecords, "max-records", 0, "stop processing after `n` records")
+	pflag.StringVar(&inputURL, "input", "", "read records from an HTTP endpoint with SSE or NDJSON at `url`")
+	pflag.StringVar(&serveAddr, "serve", "", "publish the stream of stdout via HTTP on `addr`")
+	pflag.StringVar(&controlPath, "control-socket", "", "publish the shown records as events on the unix socket `path`")
+	pflag.StringVar(&inputToken, "input-token", "", "send the bearer token `ref` to --input, e.g. env:NAME")
+	pflag.StringVar(&serveToken, "serve-token", "", "require the bearer token `ref` from clients of --serve, e.g. env:NAME")
+	pflag.StringVar(&maxRate, "max-rate", "", "publish at most `rate` records via --serve, e.g. 100/s")
+	pflag.StringVar(&listenAddr, "listen", "", "receive records of authenticated clients via HTTP on `addr`")
+	pflag.StringVar(&collectDir, "collect-dir", "", "write the records of each --listen client to a subdirectory of `dir`")
+	pflag.StringVar(&tlsOpts.cert, "tls-cert", "", "certificate of --serve and --listen, client certificate of --input")
+	pflag.StringVar(&tlsOpts.key, "tls-key", "", "private key of --tls-cert")
+	pflag.StringVar(&tlsOpts.ca, "tls-ca", "", "CA certificates which verify the server of --input")
+	pflag.StringVar(&tlsOpts.clientCA, "tls-client-ca", "", "CA certificates which verify the required client certificates of --serve and --listen")
+	pflag.StringVar(&tlsOpts.serverName, "tls-server-name", "", "server name for SNI and verification of --input")
+	pflag.StringVar(&runIDSpec, "run-id", "", "add `id` as run_id to records written to files or --serve: auto, none, or a string")
+	pflag.Lookup("run-id").NoOptDefVal = runIDAuto
+	pflag.BoolVar(&withVia, "provenance", false, "append this host to the via field of records written to files or --serve")
+	pflag.StringVar(&inputFormat, "input-format", inputFormatAuto, "input encoding: auto, json, cbor, msgpack")
+	pflag.BoolVar(&follow, "follow", false, "keep reading when the end of file is reached")
+	pflag.BoolVar(&validateCli, "validate", false, "check records against the penlog specification and exit")
+	pflag.BoolVar(&statsCli, "stats", false, "print statistics about the input and exit")
+	pflag.DurationVar(&blameWindow, "blame-window", 0, "list the records of other components within `duration` before each error and exit")
+	pflag.StringVar(&conv.tsaURL, "tsa", "", "obtain RFC 3161 timestamps for file outputs from the time-stamping authority at `url`")
+	pflag.DurationVar(&conv.tsaInterval, "tsa-interval", 5*time.Minute, "minimum time between two timestamps of a file output")
+	pflag.BoolVar(&verifyTSA, "verify-timestamps", false, "verify the RFC 3161 timestamps of the given files and exit")
+	pflag.StringVar(&tsaCA, "tsa-ca", "", "trust the time-stamping authorities issued by the certificates in `file` instead of the system roots")
+	pflag.BoolVar(&repair, "repair", false, "truncate the given files to their last sync point and exit")
+	pflag.IntVar(&conv.syncRecords, "sync-records", 0, "write file outputs up to a sync point every `n` records")
+	pflag.DurationVar(&conv.syncInterval, "sync-interval", 0, "write file outputs up to a sync point after `duration`")
+	pflag.StringVar(&reportFormat, "report", "", "print a test report of the check records in `format` and exit: junit, json")
+	pflag.StringVar(&statsFormat, "stats-format", "hr", "output format of --stats: hr, json")
+	pflag.DurationVar(&statsBucket, "stats-bucket", 0, "bucket size for error rates of --stats (default auto)")
+	pflag.IntVar(&statsTop, "stats-top", 10, "number of most frequent payloads shown by --stats")
+	pflag.DurationVar(&statsInterval, "stats-interval", 0, "emit a stats record periodically after this `duration`")
+	pflag.StringVar(&statsFile, "stats-file", "", "additionally write the records of --stats-interval to `file`")
+	pflag.BoolVar(&checkOrder, "check-order", false, "warn about timestamps which regress by more than --order-threshold")
+	pflag.DurationVar(&orderThresh, "order-threshold", time.Second, "tolerated regression of timestamps")
+	pflag.BoolVar(&checkStream, "check-stream", false, "warn about mixed spec versions, run_ids, and time bases")
+	pflag.DurationVar(&timeJump, "time-jump", 24*time.Hour, "timestamp difference which --check-stream considers a different time base")
+	pflag.StringArrayVar(&phaseBudgets, "phase-budget", []string{}, "warn if a phase takes longer than its budget, e.g. `enumeration=10m`")
+	pflag.StringVar(&budgetExec, "phase-budget-exec", "", "run `command` when a phase exceeds its budget")
+	pflag.IntVar(&narrowWidth, "narrow-width", 80, "render compactly if the terminal is narrower than `n` columns; 0 disables")
+	pflag.BoolVar(&phaseTime, "phase-time", false, "show the time since the phase began instead of the timestamp")
+	pflag.StringVarP(&conv.output, "output", "o", outputHR, "output format of stdout: hr, json, jsonl-pretty")
+	pflag.StringVar(&conv.showPriority, "show-priority", "", "show the priority as a column: name, number")
+	pflag.Lookup("show-priority").NoOptDefVal = "name"
+	pflag.BoolVar(&conv.strict, "strict", false, "report records which violate the penlog specification")
 	pflag.BoolVar(&conv.volatileInfo, "volatile-info", false, "Overwrite info messages in the same line")
 	showVersion := pflag.BoolP("version", "V", false, "Show version and exit")
 	cpuprofile := pflag.String("cpuprofile", "", "write cpu profile to `file`")
@@ -354,6 +1021,224 @@ func main() {
 		os.Exit(0)
 	}
 
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+		os.Exit(1)
+	}
+	if err := applyConfigArgs(cfg.Args); err != nil {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+		os.Exit(1)
+	}
+
+	if conv.showPriority != "" && conv.showPriority != "name" && conv.showPriority != "number" {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: invalid value for --show-priority: %s\n", conv.showPriority)
+		os.Exit(1)
+	}
+	if err := checkOutput(conv.output); err != nil {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+		os.Exit(1)
+	}
+	if err := checkInputFormat(inputFormat); err != nil {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+		os.Exit(1)
+	}
+
+	if validateCli {
+		v := newValidator(os.Stdout)
+		if pflag.NArg() > 0 {
+			for _, file := range pflag.Args() {
+				reader, err := getReader(file)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				v.validate(newInputReader(reader, inputFormat), file)
+			}
+		} else {
+			reader, err := getStdinReader()
+			if err != nil {
+				colorEprintf(colorRed, conv.formatter.ShowColors, "error: <stdin>: %s\n", err)
+				os.Exit(1)
+			}
+			v.validate(newInputReader(reader, inputFormat), "<stdin>")
+		}
+		v.summary()
+		if v.total() > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if verifyTSA {
+		if pflag.NArg() == 0 {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: --verify-timestamps requires files\n")
+			os.Exit(1)
+		}
+		var roots *x509.CertPool
+		if tsaCA != "" {
+			if roots, err = loadCertPool(tsaCA); err != nil {
+				colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		failed := false
+		for _, file := range pflag.Args() {
+			n, err := verifyTimestamps(os.Stdout, file, roots)
+			if err != nil {
+				colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+				failed = true
+			}
+			if n > 0 {
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if repair {
+		if pflag.NArg() == 0 {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: --repair requires files\n")
+			os.Exit(1)
+		}
+		failed := false
+		for _, file := range pflag.Args() {
+			res, err := repairFile(file)
+			if err != nil {
+				colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+				failed = true
+				continue
+			}
+			fmt.Printf("%s: %s\n", file, res)
+		}
+		if failed {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if reportFormat != "" {
+		if err := checkReportFormat(reportFormat); err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+		r := newCheckReport()
+		if pflag.NArg() > 0 {
+			for _, file := range pflag.Args() {
+				reader, err := getReader(file)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				r.read(newInputReader(reader, inputFormat))
+			}
+		} else {
+			reader, err := getStdinReader()
+			if err != nil {
+				colorEprintf(colorRed, conv.formatter.ShowColors, "error: <stdin>: %s\n", err)
+				os.Exit(1)
+			}
+			r.read(newInputReader(reader, inputFormat))
+		}
+		if err := r.write(os.Stdout, reportFormat); err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+		if r.Failures > 0 || r.Invalid > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if statsCli {
+		s := newStats(orderThresh)
+		if pflag.NArg() > 0 {
+			for _, file := range pflag.Args() {
+				reader, err := getReader(file)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				s.read(newInputReader(reader, inputFormat))
+			}
+		} else {
+			reader, err := getStdinReader()
+			if err != nil {
+				colorEprintf(colorRed, conv.formatter.ShowColors, "error: <stdin>: %s\n", err)
+				os.Exit(1)
+			}
+			s.read(newInputReader(reader, inputFormat))
+		}
+		if err := s.report(statsBucket, statsTop).write(os.Stdout, statsFormat); err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if blameWindow < 0 {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: --blame-window must be positive\n")
+		os.Exit(1)
+	}
+	if blameWindow > 0 {
+		b := newBlamer(blameWindow)
+		if pflag.NArg() > 0 {
+			for _, file := range pflag.Args() {
+				reader, err := getReader(file)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				b.read(newInputReader(reader, inputFormat))
+			}
+		} else {
+			reader, err := getStdinReader()
+			if err != nil {
+				colorEprintf(colorRed, conv.formatter.ShowColors, "error: <stdin>: %s\n", err)
+				os.Exit(1)
+			}
+			b.read(newInputReader(reader, inputFormat))
+		}
+		b.write(os.Stdout)
+		os.Exit(0)
+	}
+
+	if len(thenStages) > 0 {
+		if interactive {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: --then cannot be combined with --interactive\n")
+			os.Exit(1)
+		}
+		conv.then, err = newThenPipeline(thenStages, thenOptions{
+			orderThreshold: orderThresh,
+			statsBucket:    statsBucket,
+			statsTop:       statsTop,
+			statsFormat:    statsFormat,
+		})
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if groupDir != "" && groupBy == "" {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: --group-dir requires --group-by\n")
+		os.Exit(1)
+	}
+	if groupBy != "" {
+		if interactive || conv.then != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: --group-by cannot be combined with --interactive or --then\n")
+			os.Exit(1)
+		}
+		conv.grouper, err = newGrouper(&conv, groupBy, groupDir)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
 	conv.logFmt = "%s {%s} [%s]: %s"
 
 	if err := configureFormatter(hrFormatRaw, conv.formatter); err != nil {
@@ -361,6 +1246,11 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := conv.addRenderers(cfg.Renderers); err != nil {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+		os.Exit(1)
+	}
+
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -379,15 +1269,210 @@ func main() {
 		colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
 		os.Exit(1)
 	}
+	for _, cond := range []struct{ option, field, value string }{
+		{"--since", "since=", since},
+		{"--until", "until=", until},
+		{"--grep", "data~", grep},
+		{"--target", "target=", target},
+	} {
+		if cond.value == "" {
+			continue
+		}
+		if err := conv.addStdoutCondition(cond.option, cond.field+cond.value); err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if explainSample > 0 || len(explainIDs) > 0 {
+		conv.explainer = newExplainer(explainSample, explainIDs)
+	}
+	if diffFields {
+		conv.differ = newDiffer()
+	}
+	if hmacKeyFile != "" {
+		conv.hmacVerifier, err = newHMACVerifier(hmacKeyFile)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	conv.decodeLimit = decodeLimit
+	for _, spec := range decodeSpecs {
+		dec, err := parseFieldDecoder(spec, decodeLimit)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+		conv.decoders = append(conv.decoders, dec)
+	}
 	if err := conv.addPrioFilter(prioLevelRaw); err != nil {
 		colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
 		os.Exit(1)
 	}
+	for _, spec := range prioOverrides {
+		if err := conv.addPrioOverride(spec); err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if untilMatchRaw != "" && waitForRaw != "" {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: --until-match and --wait-for are mutually exclusive\n")
+		os.Exit(1)
+	}
+	if timeout != 0 && waitForRaw == "" {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: --timeout requires --wait-for\n")
+		os.Exit(1)
+	}
+	if waitForRaw != "" {
+		untilMatchRaw = waitForRaw
+		conv.quiet = true
+	}
+	if untilMatchRaw != "" {
+		conv.untilMatch, err = filter.ParseExpression(untilMatchRaw)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if statsFile != "" && statsInterval <= 0 {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: --stats-file requires --stats-interval\n")
+		os.Exit(1)
+	}
+	if checkOrder {
+		conv.orderChecker = newOrderChecker(orderThresh)
+	}
+	if checkStream {
+		conv.streamChecker = newStreamChecker(timeJump)
+	}
+	if len(phaseBudgets) > 0 {
+		// Stdin, remote, and collected input are live.
+		live := pflag.NArg() == 0 || follow
+		conv.phaseBudgets, err = newPhaseBudgets(&conv, phaseBudgets, budgetExec, live)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	} else if budgetExec != "" {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: --phase-budget-exec requires --phase-budget\n")
+		os.Exit(1)
+	}
+	if phaseTime {
+		conv.phaseClock = newPhaseClock()
+	}
+	if statsInterval > 0 {
+		conv.checkpoints, err = newCheckpoints(statsFile)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if inputURL != "" {
+		if pflag.NArg() > 0 || follow {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: --input cannot be combined with files\n")
+			os.Exit(1)
+		}
+		if err := checkRemoteURL(inputURL); err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+		inputToken, err = resolveSecret(inputToken)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := tlsOpts.check(); err != nil {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+		os.Exit(1)
+	}
+	var serverTLS *tls.Config
+	if listenAddr != "" || serveAddr != "" {
+		serverTLS, err = tlsOpts.serverConfig()
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	clientTLS, err := tlsOpts.clientConfig()
+	if err != nil {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+		os.Exit(1)
+	}
+	if listenAddr != "" {
+		if pflag.NArg() > 0 || follow || inputURL != "" {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: --listen cannot be combined with other inputs\n")
+			os.Exit(1)
+		}
+		conv.collector, err = newCollector(&conv, listenAddr, serverTLS, cfg.Clients, collectDir)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	} else if collectDir != "" {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: --collect-dir requires --listen\n")
+		os.Exit(1)
+	}
+	if controlPath != "" {
+		conv.control, err = newControlSocket(controlPath)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if serveAddr != "" {
+		serveToken, err = resolveSecret(serveToken)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+		conv.server, err = newStreamServer(serveAddr, serverTLS, serveToken)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if maxRate != "" {
+		if conv.server == nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: --max-rate requires --serve\n")
+			os.Exit(1)
+		}
+		interval, err := parseRate(maxRate)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+		conv.pacer = newPacer(interval, conv.server.publish)
+	}
+	conv.runID, err = resolveRunID(runIDSpec, conv.server != nil)
+	if err != nil {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+		os.Exit(1)
+	}
+	if withVia {
+		conv.provenance, err = newProvenance()
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if follow && pflag.NArg() != 1 {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: --follow requires exactly one file\n")
+		os.Exit(1)
+	}
 
 	var (
-		reader io.Reader = os.Stdin
-		c                = make(chan os.Signal)
+		// Stdin unless the input is remote or collected.
+		reader io.Reader
+		c      = make(chan os.Signal, 1)
 	)
+	var remote *remoteInput
+	if inputURL != "" {
+		remote = openRemoteInput(inputURL, inputToken, clientTLS)
+		reader = remote
+	}
+	if conv.collector != nil {
+		reader = conv.collector
+	}
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	go func() {
 		sig := <-c
@@ -395,11 +1480,38 @@ func main() {
 		if s, ok := sig.(syscall.Signal); ok {
 			exitCode = 128 + int(s)
 		}
+		if conv.tui != nil {
+			conv.tui.restore()
+		}
 		time.Sleep(1 * time.Second)
+		conv.abort()
 		conv.cleanup()
 		os.Exit(exitCode)
 	}()
 
+	if maxDuration > 0 {
+		time.AfterFunc(maxDuration, func() {
+			// The spool is dropped first, such that the epilogue
+			// bypasses the pacer.
+			conv.abort()
+			conv.emitRecord(durationLimitEpilogue(maxDuration))
+			if conv.tui != nil {
+				conv.tui.restore()
+			}
+			conv.cleanup()
+			os.Exit(0)
+		})
+	}
+
+	if conv.quiet && timeout > 0 {
+		time.AfterFunc(timeout, func() {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: no matching record within %s\n", timeout)
+			conv.abort()
+			conv.cleanup()
+			os.Exit(exitTimeout)
+		})
+	}
+
 	conv.formatter.ShowColors = colorsCli
 	if colorsCli {
 		if !isatty(uintptr(syscall.Stdout)) {
@@ -409,6 +1521,10 @@ func main() {
 			conv.formatter.ShowColors = colorsCli
 		}
 	}
+	// Sink options of stdout filters take precedence.
+	for _, f := range conv.stdoutFilters {
+		conv.formatter.ShowColors = f.sink.showColors(conv.formatter.ShowColors)
+	}
 	conv.formatter.ShowLines = linesCli
 	if valRaw, ok := os.LookupEnv("PENLOG_SHOW_LINES"); ok {
 		if val, err := strconv.ParseBool(valRaw); val && err == nil {
@@ -421,18 +1537,174 @@ func main() {
 			conv.formatter.ShowStacktraces = val
 		}
 	}
+	// After the formatter is configured completely.
+	conv.narrow = newNarrowProfile(narrowWidth, conv.formatter)
+	if columnsRaw != "" {
+		conv.columns, err = parseColumns(strings.Split(columnsRaw, ","), conv.formatter)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if conv.formatter.ShowStacktraces && !expandTraces {
+		conv.stacktraces = newStacktraceFolder(conv.formatter.Timespec)
+	}
 
-	if pflag.NArg() > 0 {
-		for _, file := range pflag.Args() {
-			reader, err = getReader(file)
-			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+	if sessionDir != "" {
+		if interactive {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: --session-log cannot be combined with --interactive\n")
+			os.Exit(1)
+		}
+		conv.session, err = openSessionLog(sessionDir, &conv)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if conv.checkpoints != nil {
+		conv.checkpoints.run(&conv, statsInterval)
+	}
+	if conv.server != nil {
+		conv.server.serve(conv.formatter)
+	}
+	if conv.collector != nil {
+		conv.collector.serve()
+	}
+	if saveInvoc != "" {
+		preamble, err := saveInvocation(saveInvoc, cfg)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+		conv.emitRecord(preamble)
+	}
+	// The run id is shown to cross-reference the written records.
+	if conv.runID != "" && !conv.quiet {
+		conv.printRecord(createRecord("run", penlog.PrioInfo, fmt.Sprintf("run id %s", conv.runID)))
+	}
+
+	process := func(r io.Reader) {
+		r = newInputReader(r, inputFormat)
+		if jqFilter != "" {
+			conv.transformJQ(r, jqFilter, jqNative)
+			return
+		}
+		conv.transform(r)
+	}
+
+	readInputs := func() {
+		if pflag.NArg() > 0 {
+			for _, file := range pflag.Args() {
+				reader, err := getReader(file)
+				if err != nil {
+					if conv.tui != nil {
+						conv.printError(err.Error())
+						continue
+					}
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				if follow {
+					reader = &followReader{r: reader, interval: 250 * time.Millisecond}
+				}
+				process(reader)
+				if conv.stopped {
+					break
+				}
+			}
+		} else {
+			if reader == nil {
+				var err error
+				reader, err = getStdinReader()
+				if err != nil {
+					err = fmt.Errorf("<stdin>: %w", err)
+					if conv.tui != nil {
+						conv.printError(err.Error())
+						return
+					}
+					colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+					os.Exit(1)
+				}
 			}
-			conv.transform(reader)
+			process(reader)
+		}
+		if conv.limited {
+			conv.emitRecord(recordLimitEpilogue(conv.maxRecords))
+		}
+	}
+
+	// The TUI falls back to the line based output if stdout is no terminal.
+	if interactive && isatty(uintptr(syscall.Stdout)) {
+		conv.tui, err = newTUI(&conv)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+		go func() {
+			readInputs()
+			conv.tui.done()
+		}()
+		if err := conv.tui.run(); err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+			os.Exit(1)
+		}
+		if marksFile == "" {
+			marksFile = marksFileName(time.Now())
 		}
-	} else {
-		conv.transform(reader)
+		n, err := conv.tui.exportMarks(marksFile)
+		if err != nil {
+			colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", err)
+		} else if n > 0 {
+			fmt.Fprintf(os.Stderr, "%d marked records written to %s\n", n, marksFile)
+		}
+		conv.cleanup()
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	readInputs()
+	if conv.grouper != nil {
+		conv.grouper.finish()
+	}
+	if conv.orderChecker != nil {
+		conv.printRecord(conv.orderChecker.summary())
+	}
+	if conv.streamChecker != nil {
+		conv.printRecord(conv.streamChecker.summary())
+	}
+	var thenErr error
+	if conv.then != nil {
+		thenErr = conv.then.Finish()
 	}
 	conv.cleanup()
+	// An abort, e.g. by a signal, exits with its own status.
+	if conv.isAborted() {
+		select {}
+	}
+	if thenErr != nil {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: %s\n", thenErr)
+		os.Exit(1)
+	}
+	if conv.hmacVerifier != nil && conv.hmacVerifier.failures > 0 {
+		colorEprintf(colorRed, conv.formatter.ShowColors, "error: hmac verification failed for %d records\n", conv.hmacVerifier.failures)
+		os.Exit(1)
+	}
+	if conv.jqFailures > 0 || conv.sinkFailures > 0 {
+		os.Exit(1)
+	}
+	if remote != nil && remote.failed {
+		os.Exit(1)
+	}
+	if conv.stopped && !conv.limited {
+		if conv.quiet {
+			os.Exit(0)
+		}
+		os.Exit(exitMatched)
+	}
+	if conv.quiet {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// marksFileName is the default file for the marked records of the TUI.
+func marksFileName(now time.Time) string {
+	return "hr-marks-" + now.Format("20060102T150405") + ".txt"
+}
+
+// toggleMark marks or unmarks the selected record.
+func (t *tui) toggleMark() {
+	if t.cursor >= len(t.visible) {
+		return
+	}
+	i := t.visible[t.cursor]
+	if t.marked[i] {
+		delete(t.marked, i)
+		t.message = fmt.Sprintf("unmarked record %d, %d marked", i+1, len(t.marked))
+		return
+	}
+	t.marked[i] = true
+	t.message = fmt.Sprintf("marked record %d, %d marked", i+1, len(t.marked))
+}
+
+// nextMark selects the next visible marked record.
+func (t *tui) nextMark() {
+	for i := 1; i <= len(t.visible); i++ {
+		pos := (t.cursor + i) % len(t.visible)
+		if t.marked[t.visible[pos]] {
+			t.cursor = pos
+			t.follow = false
+			return
+		}
+	}
+	t.message = "no marked records"
+}
+
+// exportMarks writes the marked records in the order of the input,
+// each in its rendered form followed by the raw JSON record, such that
+// they can be pasted into a report. It returns the number of records;
+// nothing is written if no record is marked.
+func (t *tui) exportMarks(filename string) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.marked) == 0 {
+		return 0, nil
+	}
+	indices := make([]int, 0, len(t.marked))
+	for i := range t.marked {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(file)
+	fmt.Fprintf(w, "# hr marks exported at %s: %d records\n", time.Now().Format(time.RFC3339), len(indices))
+	if filters := t.conv.filterSources(); len(filters) > 0 {
+		fmt.Fprintf(w, "# filters: %s\n", strings.Join(filters, " "))
+	}
+	for _, i := range indices {
+		data := t.records[i]
+		rendered, err := t.conv.render(data)
+		if err != nil {
+			rendered = err.Error()
+		}
+		raw, err := json.Marshal(data)
+		if err != nil {
+			file.Close()
+			return 0, err
+		}
+		fmt.Fprintf(w, "\n# record %d\n%s\n%s\n", i+1, strings.TrimRight(stripEscapes(rendered), "\n"), raw)
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return 0, err
+	}
+	return len(indices), file.Close()
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	narrowTimespec = "15:04:05"
+	narrowCompLen  = 6
+)
+
+var prioLetters = map[penlog.Prio]string{
+	penlog.PrioEmergency: "!",
+	penlog.PrioAlert:     "A",
+	penlog.PrioCritical:  "C",
+	penlog.PrioError:     "E",
+	penlog.PrioWarning:   "W",
+	penlog.PrioNotice:    "N",
+	penlog.PrioInfo:      "I",
+	penlog.PrioDebug:     "D",
+	penlog.PrioTrace:     "T",
+}
+
+// narrowProfile renders records compactly while the terminal on
+// stdout is narrower than threshold: the type is dropped, timestamps
+// are shortened, and the priority is a single letter.
+type narrowProfile struct {
+	threshold int
+	active    int32
+	formatter *penlog.HRFormatter
+}
+
+// newNarrowProfile returns nil if stdout is no terminal. The width is
+// checked again whenever the terminal is resized.
+func newNarrowProfile(threshold int, formatter *penlog.HRFormatter) *narrowProfile {
+	if threshold <= 0 || !isatty(uintptr(syscall.Stdout)) {
+		return nil
+	}
+	f := *formatter
+	f.Dialect = penlog.HRTiny
+	f.Timespec = narrowTimespec
+	n := &narrowProfile{threshold: threshold, formatter: &f}
+	n.update()
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, unix.SIGWINCH)
+	go func() {
+		for range winch {
+			n.update()
+		}
+	}()
+	return n
+}
+
+func (n *narrowProfile) update() {
+	var active int32
+	ws, err := unix.IoctlGetWinsize(syscall.Stdout, unix.TIOCGWINSZ)
+	if err == nil && ws.Col > 0 && int(ws.Col) < n.threshold {
+		active = 1
+	}
+	atomic.StoreInt32(&n.active, active)
+}
+
+func (n *narrowProfile) isActive() bool {
+	return n != nil && atomic.LoadInt32(&n.active) == 1
+}
+
+func (n *narrowProfile) format(data map[string]interface{}) (string, error) {
+	var (
+		d          = copyData(data)
+		comp, _    = fieldString(d, "component")
+		payload, _ = fieldString(d, "data")
+		letter     = " "
+	)
+	if p, ok := fieldPrio(d); ok {
+		if l, ok := prioLetters[p]; ok {
+			letter = l
+		}
+	}
+	d["data"] = padOrTruncate(comp, narrowCompLen) + " " + letter + " " + payload
+	return n.formatter.Format(d)
+}
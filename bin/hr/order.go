@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Fraunhofer-AISEC/penlog/filter"
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+// orderChecker detects timestamps which regress compared to the latest
+// timestamp seen so far. Small regressions are expected when several
+// components write to the same log, hence the threshold.
+type orderChecker struct {
+	threshold   time.Duration
+	latest      time.Time
+	regressions int
+	max         time.Duration
+}
+
+func newOrderChecker(threshold time.Duration) *orderChecker {
+	return &orderChecker{threshold: threshold}
+}
+
+// check returns the regression of the record if it exceeds the threshold.
+func (o *orderChecker) check(data map[string]interface{}) (time.Duration, bool) {
+	raw, _ := fieldString(data, "timestamp")
+	ts, err := filter.ParseTimestamp(raw)
+	if err != nil {
+		return 0, false
+	}
+	if ts.After(o.latest) {
+		o.latest = ts
+		return 0, false
+	}
+	regression := o.latest.Sub(ts)
+	if regression <= o.threshold {
+		return 0, false
+	}
+	o.regressions++
+	if regression > o.max {
+		o.max = regression
+	}
+	return regression, true
+}
+
+func (o *orderChecker) summary() map[string]interface{} {
+	if o.regressions == 0 {
+		return createRecord("order", penlog.PrioInfo, fmt.Sprintf("no timestamps regressed by more than %s", o.threshold))
+	}
+	record := createRecord("order", penlog.PrioWarning, fmt.Sprintf(
+		"%d timestamps regressed by more than %s, at most by %s; sort the input before trusting the timeline",
+		o.regressions,
+		o.threshold,
+		o.max,
+	))
+	record["regressions"] = o.regressions
+	record["max_regression"] = o.max.Seconds()
+	return record
+}
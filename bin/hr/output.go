@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"fmt"
+)
+
+// Output modes of stdout, see --output.
+const (
+	outputHR         = "hr"
+	outputJSON       = "json"
+	outputJSONPretty = "jsonl-pretty"
+)
+
+// jsonPrettyDelimiter separates the records of --output jsonl-pretty.
+const jsonPrettyDelimiter = "---"
+
+func checkOutput(output string) error {
+	switch output {
+	case outputHR, outputJSON, outputJSONPretty:
+		return nil
+	}
+	return fmt.Errorf("invalid output: %s", output)
+}
+
+// printJSON writes a record to stdout in the JSON based output modes.
+// The caller holds stdoutMutex.
+func (c *converter) printJSON(record map[string]interface{}) {
+	b, err := json.Marshal(record)
+	if err == nil && c.output == outputJSONPretty {
+		// Indenting the encoded record also indents the values of
+		// extension fields, which are written as they were read.
+		var buf bytes.Buffer
+		err = stdjson.Indent(&buf, b, "", "  ")
+		b = buf.Bytes()
+	}
+	if err != nil {
+		colorEprintf(colorRed, c.formatter.ShowColors, "error: %s\n", err)
+		return
+	}
+	if c.output == outputJSONPretty {
+		if c.printedJSON {
+			fmt.Println(jsonPrettyDelimiter)
+		}
+		c.printedJSON = true
+	}
+	fmt.Println(string(b))
+}
@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+// parseRate parses rates like "100/s" or "600/m" into the interval
+// between two records. A plain number is per second.
+func parseRate(spec string) (time.Duration, error) {
+	var (
+		count = spec
+		per   = time.Second
+	)
+	if i := strings.IndexByte(spec, '/'); i >= 0 {
+		count = spec[:i]
+		switch spec[i+1:] {
+		case "s":
+		case "m":
+			per = time.Minute
+		case "h":
+			per = time.Hour
+		default:
+			return 0, fmt.Errorf("invalid rate '%s': expected N/s, N/m, or N/h", spec)
+		}
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate '%s': expected a positive number", spec)
+	}
+	return time.Duration(float64(per) / n), nil
+}
+
+// pacerMaxSpool limits the records spooled by --max-rate.
+const pacerMaxSpool = 100000
+
+// publish sends data to the clients of --serve, paced by --max-rate.
+// Once aborted, records bypass the pacer, e.g. the epilogue of
+// --max-duration.
+func (c *converter) publish(data map[string]interface{}) {
+	if c.pacer != nil && !c.isAborted() {
+		if !c.pacer.push(data) {
+			c.pacerFull.Do(func() {
+				c.printRecord(createRecord("serve", penlog.PrioWarning, fmt.Sprintf("the spool of --max-rate is full with %d records; further records are not served", pacerMaxSpool)))
+			})
+		}
+		return
+	}
+	c.server.publish(data)
+}
+
+// pacer forwards records with a minimum interval. Records which arrive
+// faster are spooled in memory, such that bursts are smoothed instead
+// of dropped. A full spool drops new records.
+type pacer struct {
+	interval time.Duration
+	forward  func(map[string]interface{})
+
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	spool  []map[string]interface{}
+	closed bool
+	// waiting is set while a dequeued record waits for its turn.
+	waiting bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newPacer(interval time.Duration, forward func(map[string]interface{})) *pacer {
+	p := &pacer{
+		interval: interval,
+		forward:  forward,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.mutex)
+	go p.run()
+	return p
+}
+
+// push spools data and reports whether there was room for it.
+func (p *pacer) push(data map[string]interface{}) bool {
+	p.mutex.Lock()
+	if len(p.spool) >= pacerMaxSpool {
+		p.mutex.Unlock()
+		return false
+	}
+	p.spool = append(p.spool, data)
+	p.mutex.Unlock()
+	p.cond.Signal()
+	return true
+}
+
+func (p *pacer) run() {
+	defer close(p.done)
+	var next time.Time
+	for {
+		p.mutex.Lock()
+		for len(p.spool) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if len(p.spool) == 0 {
+			p.mutex.Unlock()
+			return
+		}
+		data := p.spool[0]
+		p.spool[0] = nil
+		p.spool = p.spool[1:]
+		p.waiting = true
+		p.mutex.Unlock()
+
+		if d := time.Until(next); d > 0 {
+			timer := time.NewTimer(d)
+			select {
+			case <-timer.C:
+			case <-p.stop:
+				timer.Stop()
+				return
+			}
+		}
+		p.forward(data)
+		next = time.Now().Add(p.interval)
+
+		p.mutex.Lock()
+		p.waiting = false
+		p.mutex.Unlock()
+	}
+}
+
+// close waits until the spool is drained or abort is called.
+func (p *pacer) close() {
+	p.mutex.Lock()
+	p.closed = true
+	p.mutex.Unlock()
+	p.cond.Signal()
+	<-p.done
+}
+
+// abort stops without draining the spool and returns the number of
+// records which were not forwarded.
+func (p *pacer) abort() int {
+	p.mutex.Lock()
+	dropped := len(p.spool)
+	p.spool = nil
+	p.closed = true
+	p.mutex.Unlock()
+	p.cond.Signal()
+	close(p.stop)
+	<-p.done
+	if p.waiting {
+		dropped++
+	}
+	return dropped
+}
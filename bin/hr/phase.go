@@ -0,0 +1,254 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Fraunhofer-AISEC/penlog/filter"
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+// phaseClock tracks the phases of penlog(7) per component for
+// --phase-time.
+type phaseClock struct {
+	starts map[string]time.Time
+}
+
+func newPhaseClock() *phaseClock {
+	return &phaseClock{starts: make(map[string]time.Time)}
+}
+
+// elapsed returns the time since the current phase of the component
+// of data began. ok is false if the component is not within a phase.
+func (p *phaseClock) elapsed(data map[string]interface{}) (time.Duration, bool) {
+	comp, _ := fieldString(data, "component")
+	msgType, _ := fieldString(data, "type")
+	rawTS, _ := fieldString(data, "timestamp")
+	ts, err := filter.ParseTimestamp(rawTS)
+	if err != nil {
+		return 0, false
+	}
+	switch msgType {
+	case "phase-start":
+		p.starts[comp] = ts
+		return 0, true
+	case "phase-end":
+		start, ok := p.starts[comp]
+		delete(p.starts, comp)
+		return ts.Sub(start), ok
+	}
+	start, ok := p.starts[comp]
+	return ts.Sub(start), ok
+}
+
+func formatElapsed(d time.Duration) string {
+	sign := "+"
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+	d = d.Round(time.Millisecond)
+	return fmt.Sprintf("%s%02d:%02d:%02d.%03d",
+		sign,
+		d/time.Hour,
+		d%time.Hour/time.Minute,
+		d%time.Minute/time.Second,
+		d%time.Second/time.Millisecond,
+	)
+}
+
+// replaceTimestamp replaces the rendered timestamp at the beginning of
+// line with the elapsed time, padded to the same width. Lines without
+// a timestamp, e.g. from custom renderers, are returned unchanged.
+func (c *converter) replaceTimestamp(line string, data map[string]interface{}, elapsed time.Duration) string {
+	rawTS, _ := fieldString(data, "timestamp")
+	ts, err := filter.ParseTimestamp(rawTS)
+	if err != nil {
+		return line
+	}
+	rendered := ts.Format(c.formatter.Timespec)
+	if !strings.HasPrefix(line, rendered) {
+		return line
+	}
+	return fmt.Sprintf("%*s", len(rendered), formatElapsed(elapsed)) + line[len(rendered):]
+}
+
+// runningPhase is a phase with a budget of --phase-budget.
+type runningPhase struct {
+	name      string
+	component string
+	start     time.Time
+	budget    time.Duration
+	timer     *time.Timer
+	alerted   bool
+}
+
+// phaseBudgets alerts when a phase takes longer than its budget. The
+// elapsed time is taken from the timestamps of the records; for live
+// input a timer additionally fires if no records arrive.
+type phaseBudgets struct {
+	mutex   sync.Mutex
+	budgets map[string]time.Duration
+	command string
+	// live is false when files are replayed; the wall clock says
+	// nothing about their phases then.
+	live     bool
+	running  map[string]*runningPhase
+	commands sync.WaitGroup
+	conv     *converter
+}
+
+func newPhaseBudgets(c *converter, specs []string, command string, live bool) (*phaseBudgets, error) {
+	b := &phaseBudgets{
+		budgets: make(map[string]time.Duration),
+		command: command,
+		live:    live,
+		running: make(map[string]*runningPhase),
+		conv:    c,
+	}
+	for _, spec := range specs {
+		i := strings.LastIndex(spec, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid phase budget '%s': expected 'phase=duration'", spec)
+		}
+		budget, err := time.ParseDuration(spec[i+1:])
+		if err != nil || budget <= 0 {
+			return nil, fmt.Errorf("invalid phase budget '%s': invalid duration", spec)
+		}
+		b.budgets[spec[:i]] = budget
+	}
+	return b, nil
+}
+
+func (b *phaseBudgets) track(data map[string]interface{}) {
+	var (
+		comp, _    = fieldString(data, "component")
+		msgType, _ = fieldString(data, "type")
+		phase, _   = fieldString(data, "phase")
+		rawTS, _   = fieldString(data, "timestamp")
+		alerts     []map[string]interface{}
+	)
+	ts, err := filter.ParseTimestamp(rawTS)
+	if err != nil {
+		return
+	}
+
+	b.mutex.Lock()
+	rp := b.running[comp]
+	switch msgType {
+	case "phase-start":
+		b.end(comp)
+		if budget, ok := b.budgets[phase]; ok {
+			rp = &runningPhase{name: phase, component: comp, start: ts, budget: budget}
+			if b.live {
+				rp.timer = time.AfterFunc(budget, func() { b.expire(rp) })
+			}
+			b.running[comp] = rp
+		}
+	case "phase-end":
+		if rp != nil {
+			if alert := b.check(rp, ts.Sub(rp.start)); alert != nil {
+				alerts = append(alerts, alert)
+			}
+			b.end(comp)
+		}
+	default:
+		if rp != nil {
+			if alert := b.check(rp, ts.Sub(rp.start)); alert != nil {
+				alerts = append(alerts, alert)
+			}
+		}
+	}
+	b.mutex.Unlock()
+
+	for _, alert := range alerts {
+		b.emit(alert)
+	}
+}
+
+func (b *phaseBudgets) end(comp string) {
+	if rp, ok := b.running[comp]; ok {
+		if rp.timer != nil {
+			rp.timer.Stop()
+		}
+		delete(b.running, comp)
+	}
+}
+
+// check creates an alert the first time elapsed exceeds the budget.
+func (b *phaseBudgets) check(rp *runningPhase, elapsed time.Duration) map[string]interface{} {
+	if rp.alerted || elapsed <= rp.budget {
+		return nil
+	}
+	rp.alerted = true
+	record := createRecord("phase-budget", penlog.PrioWarning, fmt.Sprintf(
+		"phase %s of %s exceeded its budget of %s after %s",
+		rp.name,
+		rp.component,
+		rp.budget,
+		elapsed.Round(time.Millisecond),
+	))
+	record["phase"] = rp.name
+	record["phase_component"] = rp.component
+	record["phase_budget"] = rp.budget.Seconds()
+	record["phase_duration"] = elapsed.Seconds()
+	return record
+}
+
+func (b *phaseBudgets) expire(rp *runningPhase) {
+	b.mutex.Lock()
+	var alert map[string]interface{}
+	if b.running[rp.component] == rp {
+		// Just after the budget, as the timer fired.
+		alert = b.check(rp, rp.budget+time.Millisecond)
+	}
+	b.mutex.Unlock()
+	if alert != nil {
+		b.emit(alert)
+	}
+}
+
+// emit shows the alert and runs the command of --phase-budget-exec with
+// the alert on stdin.
+func (b *phaseBudgets) emit(alert map[string]interface{}) {
+	b.conv.printRecord(copyData(alert))
+	if b.command == "" {
+		return
+	}
+	raw, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+	cmd := exec.Command("sh", "-c", b.command)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("PENLOG_PHASE=%s", alert["phase"]),
+		fmt.Sprintf("PENLOG_PHASE_COMPONENT=%s", alert["phase_component"]),
+	)
+	cmd.Stdin = bytes.NewReader(append(raw, '\n'))
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	b.commands.Add(1)
+	go func() {
+		defer b.commands.Done()
+		if err := cmd.Run(); err != nil {
+			b.conv.printRecord(createRecord("phase-budget", penlog.PrioError, fmt.Sprintf("--phase-budget-exec failed: %s", err)))
+		}
+	}()
+}
+
+// stop cancels the timers and waits for running commands.
+func (b *phaseBudgets) stop() {
+	b.mutex.Lock()
+	for comp := range b.running {
+		b.end(comp)
+	}
+	b.mutex.Unlock()
+	b.commands.Wait()
+}
@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// provenance describes this instance of hr in the via field of
+// forwarded records, such that multi-hop pipelines can be audited.
+type provenance struct {
+	host string
+	tool string
+}
+
+func newProvenance() (*provenance, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	tool := "hr"
+	if version != "" {
+		tool += " " + version
+	}
+	return &provenance{host: host, tool: tool}, nil
+}
+
+// appendTo returns via with an entry for this hop appended. The list
+// is copied, as records share it with their copies.
+func (p *provenance) appendTo(via interface{}) []interface{} {
+	var res []interface{}
+	switch v := via.(type) {
+	case nil:
+	case []interface{}:
+		res = append(res, v...)
+	default:
+		res = append(res, v)
+	}
+	return append(res, map[string]interface{}{
+		"host":      p.host,
+		"tool":      p.tool,
+		"timestamp": time.Now().UTC().Format(timestampFormat),
+	})
+}
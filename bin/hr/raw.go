@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// interpretedFields are the fields of penlog(7) and of hr which are
+// decoded; all other fields are extensions which hr passes through.
+var interpretedFields = map[string]bool{
+	"capabilities": true,
+	"component":    true,
+	"data":         true,
+	"error":        true,
+	"hmac":         true,
+	"hmac_seq":     true,
+	"host":         true,
+	"id":           true,
+	"line":         true,
+	"priority":     true,
+	"run_id":       true,
+	"stacktrace":   true,
+	"tags":         true,
+	"target":       true,
+	"timestamp":    true,
+	"type":         true,
+	"via":          true,
+	"client":       true,
+}
+
+// rawJSON is the undecoded value of an extension field. Decoding into
+// interface{} turns numbers into float64, e.g. 18446744073709551615
+// becomes 1.8446744073709552e+19, and reorders objects; rawJSON
+// writes the value exactly as it was read, only without whitespace.
+type rawJSON []byte
+
+func (r rawJSON) MarshalJSON() ([]byte, error) {
+	return r, nil
+}
+
+// String returns the JSON text, e.g. for filters and templates.
+func (r rawJSON) String() string {
+	return string(r)
+}
+
+// value decodes r for consumers which need the structure.
+func (r rawJSON) value() interface{} {
+	var v interface{}
+	json.Unmarshal(r, &v)
+	return v
+}
+
+// decodeRecord decodes a line like json.Unmarshal, but keeps numbers,
+// objects, and arrays of extension fields as rawJSON. Strings, booleans,
+// and null survive decoding anyway and remain easy to use.
+func decodeRecord(line []byte) (map[string]interface{}, error) {
+	var fields map[string]jsoniter.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return nil, err
+	}
+	data := make(map[string]interface{}, len(fields))
+	for k, raw := range fields {
+		if !interpretedFields[k] && len(raw) > 0 && bytes.IndexByte([]byte("{[-0123456789"), raw[0]) >= 0 {
+			var b bytes.Buffer
+			if err := stdjson.Compact(&b, raw); err != nil {
+				return nil, err
+			}
+			data[k] = rawJSON(b.Bytes())
+			continue
+		}
+		// null is decoded as an empty RawMessage.
+		if len(raw) == 0 {
+			data[k] = nil
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		data[k] = v
+	}
+	return data, nil
+}
+
+// plainRecord returns a copy of data with all rawJSON values decoded.
+func plainRecord(data map[string]interface{}) map[string]interface{} {
+	d := copyData(data)
+	for k, v := range d {
+		if r, ok := v.(rawJSON); ok {
+			d[k] = r.value()
+		}
+	}
+	return d
+}
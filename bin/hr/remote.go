@@ -0,0 +1,260 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+const (
+	remoteRetryMin = time.Second
+	remoteRetryMax = 30 * time.Second
+)
+
+// remoteInput reads records from an HTTP endpoint which either sends
+// Server-Sent Events with one record per event or chunked NDJSON. Lost
+// connections are reestablished; for SSE the last event id is sent
+// such that the server can resume the stream, for NDJSON the offset of
+// the last complete line. Connection problems are inserted into the
+// stream as records of type "input".
+type remoteInput struct {
+	url         string
+	client      *http.Client
+	r           *io.PipeReader
+	w           *io.PipeWriter
+	lastEventID string
+	retry       time.Duration
+	// offset is the number of bytes of NDJSON forwarded so far;
+	// firstLine identifies the stream if a server starts over.
+	offset    int64
+	firstLine []byte
+	// token is sent as bearer token if not empty.
+	token string
+	// failed is set if the server refused the stream.
+	failed bool
+}
+
+func checkRemoteURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid input url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid input url '%s': only http and https are supported", rawURL)
+	}
+	return nil
+}
+
+func openRemoteInput(rawURL string, token string, cfg *tls.Config) *remoteInput {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	r, w := io.Pipe()
+	in := &remoteInput{
+		url:    rawURL,
+		client: &http.Client{Transport: transport},
+		r:      r,
+		w:      w,
+		retry:  remoteRetryMin,
+		token:  token,
+	}
+	go in.run()
+	return in
+}
+
+func (in *remoteInput) Read(p []byte) (int, error) {
+	return in.r.Read(p)
+}
+
+func (in *remoteInput) emit(prio penlog.Prio, format string, args ...interface{}) error {
+	b, err := json.Marshal(createRecord("input", prio, fmt.Sprintf(format, args...)))
+	if err != nil {
+		return err
+	}
+	_, err = in.w.Write(append(b, '\n'))
+	return err
+}
+
+func (in *remoteInput) run() {
+	delay := in.retry
+	for {
+		connected, err := in.stream()
+		if errors.Is(err, io.ErrClosedPipe) {
+			return
+		}
+		var permanent *remoteStatusError
+		if errors.As(err, &permanent) && !permanent.retryable() {
+			in.emit(penlog.PrioError, "reading %s failed: %s", in.url, err)
+			in.failed = true
+			in.w.Close()
+			return
+		}
+		if connected {
+			delay = in.retry
+		}
+		if err == nil {
+			err = io.EOF
+		}
+		if in.emit(penlog.PrioWarning, "connection to %s lost: %s; reconnecting in %s", in.url, err, delay) != nil {
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+		if delay > remoteRetryMax {
+			delay = remoteRetryMax
+		}
+	}
+}
+
+type remoteStatusError struct {
+	code   int
+	status string
+}
+
+func (e *remoteStatusError) Error() string {
+	return "unexpected status: " + e.status
+}
+
+// retryable is true for server errors and explicit requests to retry.
+// A range which is not satisfiable means that there are no new lines.
+func (e *remoteStatusError) retryable() bool {
+	return e.code >= 500 ||
+		e.code == http.StatusRequestTimeout ||
+		e.code == http.StatusTooManyRequests ||
+		e.code == http.StatusRequestedRangeNotSatisfiable
+}
+
+// stream reads one connection until it breaks. connected is true if
+// the server accepted the request.
+func (in *remoteInput) stream() (connected bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, in.url, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream, application/x-ndjson, application/json")
+	if in.token != "" {
+		req.Header.Set("Authorization", "Bearer "+in.token)
+	}
+	if in.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", in.lastEventID)
+	}
+	if in.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", in.offset))
+	}
+	resp, err := in.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", in.offset)) {
+			return false, fmt.Errorf("unexpected content range '%s'", resp.Header.Get("Content-Range"))
+		}
+	default:
+		return false, &remoteStatusError{code: resp.StatusCode, status: resp.Status}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/event-stream" {
+		return true, in.readEvents(resp.Body)
+	}
+	return true, in.readLines(resp.Body, resp.StatusCode == http.StatusOK)
+}
+
+// readLines forwards complete lines only, such that a broken
+// connection does not leave a truncated record behind. If the server
+// ignores the range and starts over, which is recognized by the first
+// line, the lines which were already forwarded are skipped.
+func (in *remoteInput) readLines(r io.Reader, restarted bool) error {
+	var (
+		reader = bufio.NewReader(r)
+		skip   int64
+	)
+	if restarted {
+		skip = in.offset
+		in.offset = 0
+	}
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+		if in.offset == 0 {
+			if !bytes.Equal(line, in.firstLine) {
+				skip = 0
+			}
+			in.firstLine = line
+		}
+		in.offset += int64(len(line))
+		if in.offset <= skip {
+			continue
+		}
+		if _, err := in.w.Write(line); err != nil {
+			return err
+		}
+	}
+}
+
+// readEvents implements the event stream format of the HTML standard.
+// The event type is ignored; the data of each event is one record.
+func (in *remoteInput) readEvents(r io.Reader) error {
+	var (
+		reader = bufio.NewReader(r)
+		data   bytes.Buffer
+	)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+				if _, err := in.w.Write(data.Bytes()); err != nil {
+					return err
+				}
+				data.Reset()
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "data":
+			// Records spanning several data lines are joined with
+			// spaces; JSON strings cannot contain raw newlines.
+			if data.Len() > 0 {
+				data.WriteByte(' ')
+			}
+			data.WriteString(value)
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				in.lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+				in.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/Fraunhofer-AISEC/penlog/filter"
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+// rendererConfig is a config entry which overrides the human readable
+// format for records of a particular component and type. Both
+// Component and Type are comma separated lists; empty means any.
+type rendererConfig struct {
+	Component string `json:"component"`
+	Type      string `json:"type"`
+	Template  string `json:"template"`
+}
+
+type renderer struct {
+	components []string
+	types      []string
+	tmpl       *template.Template
+}
+
+func newRenderer(cfg rendererConfig, formatter *penlog.HRFormatter) (*renderer, error) {
+	if cfg.Template == "" {
+		return nil, fmt.Errorf("renderer for '%s:%s' has no template", cfg.Component, cfg.Type)
+	}
+	funcs := template.FuncMap{
+		"hr": func(data map[string]interface{}) (string, error) {
+			return formatter.Format(data)
+		},
+		"set": func(data map[string]interface{}, field string, val interface{}) map[string]interface{} {
+			d := copyData(data)
+			d[field] = val
+			return d
+		},
+		"hex": func(s string) string {
+			return hex.EncodeToString([]byte(s))
+		},
+		"box": box,
+	}
+	name := cfg.Component + ":" + cfg.Type
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=zero").Parse(cfg.Template)
+	if err != nil {
+		return nil, err
+	}
+	return &renderer{
+		components: removeEmpy(strings.Split(cfg.Component, ",")),
+		types:      removeEmpy(strings.Split(cfg.Type, ",")),
+		tmpl:       tmpl,
+	}, nil
+}
+
+func (r *renderer) isMatch(data map[string]interface{}) bool {
+	comp, _ := fieldString(data, "component")
+	msgType, _ := fieldString(data, "type")
+	return filter.MatchName(comp, r.components) && filter.MatchName(msgType, r.types)
+}
+
+func (r *renderer) render(data map[string]interface{}) (string, error) {
+	var b strings.Builder
+	if err := r.tmpl.Execute(&b, plainRecord(data)); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+func (c *converter) addRenderers(cfgs []rendererConfig) error {
+	for _, cfg := range cfgs {
+		r, err := newRenderer(cfg, c.formatter)
+		if err != nil {
+			return err
+		}
+		c.renderers = append(c.renderers, r)
+	}
+	return nil
+}
+
+// render converts a record into its human readable form. The first
+// matching custom renderer wins; the columns of --columns or the
+// formatter are the fallback.
+func (c *converter) render(data map[string]interface{}) (string, error) {
+	for _, r := range c.renderers {
+		if r.isMatch(data) {
+			return r.render(data)
+		}
+	}
+	if c.narrow.isActive() {
+		return c.narrow.format(data)
+	}
+	if c.columns != nil {
+		return c.columns.format(data)
+	}
+	return c.formatter.Format(data)
+}
+
+// box draws a frame around a possibly multi-line string.
+func box(s string) string {
+	var (
+		lines = strings.Split(strings.TrimRight(s, "\n"), "\n")
+		width = 0
+		b     strings.Builder
+	)
+	for _, line := range lines {
+		if n := utf8.RuneCountInString(line); n > width {
+			width = n
+		}
+	}
+	b.WriteString("┌" + strings.Repeat("─", width+2) + "┐\n")
+	for _, line := range lines {
+		pad := width - utf8.RuneCountInString(line)
+		b.WriteString("│ " + line + strings.Repeat(" ", pad) + " │\n")
+	}
+	b.WriteString("└" + strings.Repeat("─", width+2) + "┘")
+	return b.String()
+}
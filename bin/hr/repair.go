@@ -0,0 +1,276 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	zstdMagic          = 0xfd2fb528
+	zstdSkippableMagic = 0x184d2a50
+	zstdSkippableMask  = 0xfffffff0
+)
+
+var errTruncatedFrame = errors.New("truncated frame")
+
+// repairResult describes the outcome of repairFile. units are the
+// complete zstd frames, gzip members, or lines which were kept.
+type repairResult struct {
+	size  int64
+	valid int64
+	units int
+}
+
+func (r repairResult) String() string {
+	if r.valid == r.size {
+		return fmt.Sprintf("intact, %d bytes", r.size)
+	}
+	return fmt.Sprintf("truncated from %d to %d bytes", r.size, r.valid)
+}
+
+// repairFile truncates a log file which was not closed properly, e.g.
+// because hr was killed, to its last valid sync point: the last
+// complete zstd frame, gzip member, or line.
+func repairFile(filename string) (repairResult, error) {
+	var res repairResult
+	file, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		return res, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return res, err
+	}
+	res.size = info.Size()
+
+	switch filepath.Ext(filename) {
+	case ".zst":
+		res.valid, res.units, err = validZstdFrames(file, res.size)
+	case ".gz":
+		res.valid, res.units, err = validGzipMembers(file)
+	default:
+		res.valid, res.units, err = validLines(file)
+	}
+	if err != nil {
+		return res, fmt.Errorf("%s: %w", filename, err)
+	}
+	if res.valid < res.size {
+		if err := file.Truncate(res.valid); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// zstdFrameLen returns the length of the frame at the start of r by
+// walking its block headers; the content is not decoded.
+func zstdFrameLen(r *bufio.Reader) (int64, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, errTruncatedFrame
+		}
+		return 0, err
+	}
+	discard := func(n int) error {
+		if m, _ := r.Discard(n); m < n {
+			return errTruncatedFrame
+		}
+		return nil
+	}
+
+	magic := binary.LittleEndian.Uint32(head[:])
+	if magic&zstdSkippableMask == zstdSkippableMagic {
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return 0, errTruncatedFrame
+		}
+		size := binary.LittleEndian.Uint32(head[:])
+		if err := discard(int(size)); err != nil {
+			return 0, err
+		}
+		return 8 + int64(size), nil
+	}
+	if magic != zstdMagic {
+		return 0, fmt.Errorf("invalid zstd magic %#08x", magic)
+	}
+
+	desc, err := r.ReadByte()
+	if err != nil {
+		return 0, errTruncatedFrame
+	}
+	var (
+		single   = desc&0x20 != 0
+		checksum = desc&0x04 != 0
+		hdrLen   = []int{0, 1, 2, 4}[desc&0x03]
+	)
+	if !single {
+		hdrLen++
+	}
+	switch desc >> 6 {
+	case 0:
+		if single {
+			hdrLen++
+		}
+	case 1:
+		hdrLen += 2
+	case 2:
+		hdrLen += 4
+	case 3:
+		hdrLen += 8
+	}
+	if err := discard(hdrLen); err != nil {
+		return 0, err
+	}
+	n := int64(5 + hdrLen)
+	for {
+		var bh [3]byte
+		if _, err := io.ReadFull(r, bh[:]); err != nil {
+			return 0, errTruncatedFrame
+		}
+		var (
+			header = uint32(bh[0]) | uint32(bh[1])<<8 | uint32(bh[2])<<16
+			last   = header&1 != 0
+			size   = int(header >> 3)
+		)
+		switch (header >> 1) & 3 {
+		case 1:
+			// RLE blocks store a single byte.
+			size = 1
+		case 3:
+			return 0, errors.New("reserved zstd block type")
+		}
+		if err := discard(size); err != nil {
+			return 0, err
+		}
+		n += 3 + int64(size)
+		if last {
+			break
+		}
+	}
+	if checksum {
+		if err := discard(4); err != nil {
+			return 0, err
+		}
+		n += 4
+	}
+	return n, nil
+}
+
+// validZstdFrames returns the end of the last frame which is complete
+// and decodes without errors, including its checksum.
+func validZstdFrames(file *os.File, size int64) (int64, int, error) {
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer dec.Close()
+
+	var (
+		r      = bufio.NewReader(file)
+		offset int64
+		frames int
+	)
+	for offset < size {
+		n, err := zstdFrameLen(r)
+		if err != nil {
+			if errors.Is(err, errTruncatedFrame) || errors.Is(err, io.EOF) {
+				break
+			}
+			if frames == 0 {
+				return 0, 0, err
+			}
+			break
+		}
+		if err := dec.Reset(io.NewSectionReader(file, offset, n)); err != nil {
+			break
+		}
+		if _, err := io.Copy(ioutil.Discard, dec); err != nil {
+			break
+		}
+		offset += n
+		frames++
+	}
+	return offset, frames, nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// validGzipMembers returns the end of the last member which is
+// complete and passes its checksum.
+func validGzipMembers(file *os.File) (int64, int, error) {
+	var (
+		cr      = &countingReader{r: file}
+		r       = bufio.NewReader(cr)
+		offset  int64
+		members int
+	)
+	for {
+		if _, err := r.Peek(1); err != nil {
+			break
+		}
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			if members == 0 && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+				return 0, 0, err
+			}
+			break
+		}
+		zr.Multistream(false)
+		if _, err := io.Copy(ioutil.Discard, zr); err != nil {
+			break
+		}
+		// The bufio.Reader is used directly by gzip, hence
+		// the position is exact.
+		offset = cr.n - int64(r.Buffered())
+		members++
+	}
+	return offset, members, nil
+}
+
+// validLines returns the end of the last complete line.
+func validLines(file *os.File) (int64, int, error) {
+	var (
+		buf    = make([]byte, 32*1024)
+		offset int64
+		valid  int64
+		lines  int
+	)
+	for {
+		n, err := file.Read(buf)
+		chunk := buf[:n]
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			valid = offset + int64(i) + 1
+			lines += bytes.Count(chunk, []byte{'\n'})
+		}
+		offset += int64(n)
+		if errors.Is(err, io.EOF) {
+			return valid, lines, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+}
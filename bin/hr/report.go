@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	stdjson "encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/Fraunhofer-AISEC/penlog/filter"
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+const (
+	reportFormatJSON  = "json"
+	reportFormatJUnit = "junit"
+	reportFormatSARIF = "sarif"
+)
+
+// checkResult is a record of type "check" or a finding, see penlog(7).
+// Findings always fail.
+type checkResult struct {
+	Kind      string      `json:"kind"`
+	Name      string      `json:"name"`
+	Passed    bool        `json:"passed"`
+	Timestamp string      `json:"timestamp,omitempty"`
+	Data      string      `json:"data,omitempty"`
+	Target    string      `json:"target,omitempty"`
+	Line      string      `json:"line,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+
+	prio    penlog.Prio
+	hasPrio bool
+}
+
+type checkSuite struct {
+	Name     string         `json:"name"`
+	Tests    int            `json:"tests"`
+	Failures int            `json:"failures"`
+	Checks   []*checkResult `json:"checks"`
+}
+
+// checkReport aggregates the check records and findings per component
+// into a test report. Suites and checks keep the order of the input.
+type checkReport struct {
+	Tests    int           `json:"tests"`
+	Failures int           `json:"failures"`
+	Invalid  int           `json:"invalid"`
+	Suites   []*checkSuite `json:"suites"`
+
+	suites map[string]*checkSuite
+}
+
+func newCheckReport() *checkReport {
+	return &checkReport{
+		Suites: []*checkSuite{},
+		suites: make(map[string]*checkSuite),
+	}
+}
+
+// isFinding is true for records of type "finding" and records tagged
+// as finding.
+func isFinding(data map[string]interface{}) bool {
+	if msgType, _ := fieldString(data, "type"); msgType == "finding" {
+		return true
+	}
+	tags, _ := fieldStrings(data, "tags")
+	for _, tag := range tags {
+		if tag == "finding" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *checkReport) add(data map[string]interface{}) {
+	res := &checkResult{Details: data["details"]}
+	switch msgType, _ := fieldString(data, "type"); {
+	case msgType == "check":
+		name, ok := fieldString(data, "check")
+		passed, okPassed := data["passed"].(bool)
+		if !ok || !okPassed {
+			// The validator explains what is wrong.
+			r.Invalid++
+			return
+		}
+		res.Kind, res.Name, res.Passed = "check", name, passed
+	case isFinding(data):
+		res.Kind = "finding"
+		if rule, ok := fieldString(data, "rule"); ok && rule != "" {
+			res.Name = rule
+		} else {
+			res.Name, _ = fieldString(data, "data")
+		}
+	default:
+		return
+	}
+	res.Timestamp, _ = fieldString(data, "timestamp")
+	res.Data, _ = fieldString(data, "data")
+	res.Line, _ = fieldString(data, "line")
+	res.Target = filter.Target(data)
+	res.prio, res.hasPrio = fieldPrio(data)
+
+	comp, _ := fieldString(data, "component")
+	suite, ok := r.suites[comp]
+	if !ok {
+		suite = &checkSuite{Name: comp, Checks: []*checkResult{}}
+		r.suites[comp] = suite
+		r.Suites = append(r.Suites, suite)
+	}
+	suite.Checks = append(suite.Checks, res)
+	suite.Tests++
+	r.Tests++
+	if !res.Passed {
+		suite.Failures++
+		r.Failures++
+	}
+}
+
+func (r *checkReport) read(rd io.Reader) {
+	reader := bufio.NewReader(rd)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if data, err := decodeRecord(line); err == nil {
+				r.add(data)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitTestcase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitTestsuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Testcases []junitTestcase `xml:"testcase"`
+}
+
+type junitTestsuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Testsuites []junitTestsuite `xml:"testsuite"`
+}
+
+// detailsString renders the details of a check for JUnit, which only
+// knows text.
+func detailsString(details interface{}) string {
+	switch d := details.(type) {
+	case nil:
+		return ""
+	case string:
+		return d
+	case rawJSON:
+		return d.String()
+	default:
+		raw, _ := json.Marshal(d)
+		return string(raw)
+	}
+}
+
+func (r *checkReport) junit() *junitTestsuites {
+	res := &junitTestsuites{Tests: r.Tests, Failures: r.Failures}
+	for _, suite := range r.Suites {
+		name := suite.Name
+		if name == "" {
+			name = "penlog"
+		}
+		ts := junitTestsuite{Name: name, Tests: suite.Tests, Failures: suite.Failures}
+		if len(suite.Checks) > 0 {
+			ts.Timestamp = suite.Checks[0].Timestamp
+		}
+		for _, check := range suite.Checks {
+			tc := junitTestcase{Name: check.Name, Classname: name}
+			details := detailsString(check.Details)
+			if check.Passed {
+				tc.SystemOut = details
+			} else {
+				tc.Failure = &junitFailure{Message: check.Data, Text: details}
+			}
+			ts.Testcases = append(ts.Testcases, tc)
+		}
+		res.Testsuites = append(res.Testsuites, ts)
+	}
+	return res
+}
+
+func (r *checkReport) write(w io.Writer, format string) error {
+	switch format {
+	case reportFormatJSON:
+		return writeIndented(w, r)
+	case reportFormatJUnit:
+		raw, err := xml.MarshalIndent(r.junit(), "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, raw)
+		return err
+	case reportFormatSARIF:
+		return writeIndented(w, r.sarif())
+	default:
+		return fmt.Errorf("invalid report format: %s", format)
+	}
+}
+
+// writeIndented writes v as indented JSON. Details are rawJSON, which
+// the encoder does not indent.
+func writeIndented(w io.Writer, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := stdjson.Indent(&buf, raw, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+func checkReportFormat(format string) error {
+	if format != reportFormatJSON && format != reportFormatJUnit && format != reportFormatSARIF {
+		return fmt.Errorf("invalid report format: %s", format)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+const (
+	runIDAuto = "auto"
+	runIDNone = "none"
+)
+
+// newUUID creates a random UUID according to RFC4122, version 4.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// resolveRunID turns the value of --run-id into the run id. Without
+// the option, a run id is only created for network sinks.
+func resolveRunID(spec string, shipping bool) (string, error) {
+	switch spec {
+	case "":
+		if !shipping {
+			return "", nil
+		}
+		return newUUID()
+	case runIDAuto:
+		return newUUID()
+	case runIDNone:
+		return "", nil
+	}
+	return spec, nil
+}
+
+// stampRunID adds the run id to records which do not have one yet;
+// records keep the id of the run which created them.
+func (c *converter) stampRunID(data map[string]interface{}) {
+	if c.runID == "" {
+		return
+	}
+	if _, ok := data["run_id"]; !ok {
+		data["run_id"] = c.runID
+	}
+}
+
+// stamp adds the fields of --run-id and --provenance to a record which
+// is written to a file or published.
+func (c *converter) stamp(data map[string]interface{}) {
+	c.stampRunID(data)
+	if c.provenance != nil {
+		data["via"] = c.provenance.appendTo(data["via"])
+	}
+}
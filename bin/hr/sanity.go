@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Fraunhofer-AISEC/penlog/filter"
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+// streamChecker detects inputs which interleave records of different
+// spec versions, runs, or time bases. Usually, several captures were
+// concatenated by accident.
+type streamChecker struct {
+	jump time.Duration
+	// compSpecs is the spec version announced per component and
+	// run_id in records of type "capabilities".
+	compSpecs map[string]int64
+	specs     map[int64]int
+	runIDs    map[string]int
+	lastRunID string
+	switches  int
+	last      time.Time
+	jumps     int
+	// warned suppresses repeated warnings of the same kind; all
+	// occurrences are counted in the summary.
+	warned map[string]bool
+}
+
+func newStreamChecker(jump time.Duration) *streamChecker {
+	return &streamChecker{
+		jump:      jump,
+		compSpecs: make(map[string]int64),
+		specs:     make(map[int64]int),
+		runIDs:    make(map[string]int),
+		warned:    make(map[string]bool),
+	}
+}
+
+// check returns warnings for the first occurrence of each kind of
+// inconsistency.
+func (s *streamChecker) check(data map[string]interface{}) []string {
+	var (
+		res      []string
+		comp, _  = fieldString(data, "component")
+		runID, _ = fieldString(data, "run_id")
+		key      = comp + "\x00" + runID
+		warn     = func(kind, format string, args ...interface{}) {
+			if !s.warned[kind] {
+				s.warned[kind] = true
+				res = append(res, fmt.Sprintf(format, args...))
+			}
+		}
+	)
+	if caps, ok := data["capabilities"].(map[string]interface{}); ok {
+		if n, ok := numberOf(caps["spec"]); ok {
+			if v, err := n.Int64(); err == nil {
+				s.compSpecs[key] = v
+			}
+		}
+	}
+	if v, ok := s.compSpecs[key]; ok {
+		s.specs[v]++
+		if len(s.specs) > 1 {
+			warn("spec", "%s uses version %d of penlog(7), unlike previous records", comp, v)
+		}
+	}
+	if runID != "" {
+		if s.lastRunID != "" && runID != s.lastRunID {
+			s.switches++
+			if _, seen := s.runIDs[runID]; seen {
+				warn("interleaved", "run_id %s continues after run_id %s; runs are interleaved", runID, s.lastRunID)
+			} else {
+				warn("run_id", "run_id %s follows run_id %s", runID, s.lastRunID)
+			}
+		}
+		s.runIDs[runID]++
+		s.lastRunID = runID
+	}
+	raw, _ := fieldString(data, "timestamp")
+	if ts, err := filter.ParseTimestamp(raw); err == nil {
+		if !s.last.IsZero() {
+			diff := ts.Sub(s.last)
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff > s.jump {
+				s.jumps++
+				warn("jump", "timestamp jumps by %s from %s to %s; time bases differ", diff.Round(time.Second), s.last.Format(time.RFC3339), ts.Format(time.RFC3339))
+			}
+		}
+		s.last = ts
+	}
+	return res
+}
+
+func (s *streamChecker) summary() map[string]interface{} {
+	var problems []string
+	if len(s.specs) > 1 {
+		problems = append(problems, fmt.Sprintf("%d spec versions (%s)", len(s.specs), formatSpecCounts(s.specs)))
+	}
+	if len(s.runIDs) > 1 {
+		problems = append(problems, fmt.Sprintf("%d run_ids (%s) with %d switches", len(s.runIDs), formatCounts(s.runIDs), s.switches))
+	}
+	if s.jumps > 0 {
+		problems = append(problems, fmt.Sprintf("%d timestamp jumps over %s", s.jumps, s.jump))
+	}
+	if len(problems) == 0 {
+		return createRecord("stream", penlog.PrioInfo, "the input is consistent: one spec version, one run, and no timestamp jumps")
+	}
+	record := createRecord("stream", penlog.PrioWarning, fmt.Sprintf(
+		"the input mixes %s; were several captures concatenated?",
+		strings.Join(problems, ", "),
+	))
+	if len(s.specs) > 1 {
+		specs := make(map[string]int, len(s.specs))
+		for v, n := range s.specs {
+			specs[fmt.Sprint(v)] = n
+		}
+		record["spec_versions"] = specs
+	}
+	if len(s.runIDs) > 1 {
+		record["run_ids"] = s.runIDs
+		record["run_id_switches"] = s.switches
+	}
+	if s.jumps > 0 {
+		record["timestamp_jumps"] = s.jumps
+	}
+	return record
+}
+
+// formatCounts renders counts as "a: 3 records, b: 1 record" sorted by
+// key.
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		unit := "records"
+		if counts[k] == 1 {
+			unit = "record"
+		}
+		parts = append(parts, fmt.Sprintf("%s: %d %s", k, counts[k], unit))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatSpecCounts(specs map[int64]int) string {
+	counts := make(map[string]int, len(specs))
+	for v, n := range specs {
+		counts[fmt.Sprintf("v%d", v)] = n
+	}
+	return formatCounts(counts)
+}
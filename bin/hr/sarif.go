@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// The types cover the subset of SARIF 2.1.0 which hr writes.
+
+type sarifLog struct {
+	Version string      `json:"version"`
+	Schema  string      `json:"$schema"`
+	Runs    []*sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool      `json:"tool"`
+	Results []*sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string       `json:"name"`
+	Rules []*sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string                 `json:"ruleId"`
+	RuleIndex  int                    `json:"ruleIndex"`
+	Level      string                 `json:"level"`
+	Message    sarifMessage           `json:"message"`
+	Locations  []*sarifLocation       `json:"locations,omitempty"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []*sarifLogical        `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+	Region           *sarifRegion  `json:"region,omitempty"`
+}
+
+type sarifArtifact struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+type sarifLogical struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// sarifLevel maps the priority of a finding; failed checks are errors.
+func (c *checkResult) sarifLevel() string {
+	if c.Kind == "check" || !c.hasPrio {
+		return "error"
+	}
+	switch {
+	case c.prio <= penlog.PrioError:
+		return "error"
+	case c.prio == penlog.PrioWarning:
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+// sarifLocation uses the field line as source location and the target
+// as logical location.
+func (c *checkResult) sarifLocation() *sarifLocation {
+	var loc sarifLocation
+	if i := strings.LastIndex(c.Line, ":"); i > 0 {
+		phys := &sarifPhysicalLocation{ArtifactLocation: sarifArtifact{URI: c.Line[:i]}}
+		if n, err := strconv.Atoi(c.Line[i+1:]); err == nil && n > 0 {
+			phys.Region = &sarifRegion{StartLine: n}
+		}
+		loc.PhysicalLocation = phys
+	}
+	if c.Target != "" {
+		loc.LogicalLocations = []*sarifLogical{{Name: c.Target, Kind: "target"}}
+	}
+	if loc.PhysicalLocation == nil && loc.LogicalLocations == nil {
+		return nil
+	}
+	return &loc
+}
+
+// sarif converts the findings and failed checks into one run per
+// component; passed checks are no results.
+func (r *checkReport) sarif() *sarifLog {
+	log := &sarifLog{Version: sarifVersion, Schema: sarifSchema, Runs: []*sarifRun{}}
+	for _, suite := range r.Suites {
+		name := suite.Name
+		if name == "" {
+			name = "penlog"
+		}
+		run := &sarifRun{
+			Tool:    sarifTool{Driver: sarifDriver{Name: name, Rules: []*sarifRule{}}},
+			Results: []*sarifResult{},
+		}
+		rules := make(map[string]int)
+		for _, check := range suite.Checks {
+			if check.Passed {
+				continue
+			}
+			index, ok := rules[check.Name]
+			if !ok {
+				index = len(run.Tool.Driver.Rules)
+				rules[check.Name] = index
+				run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, &sarifRule{ID: check.Name})
+			}
+			res := &sarifResult{
+				RuleID:    check.Name,
+				RuleIndex: index,
+				Level:     check.sarifLevel(),
+				Message:   sarifMessage{Text: check.Data},
+				Properties: map[string]interface{}{
+					"kind":      check.Kind,
+					"timestamp": check.Timestamp,
+				},
+			}
+			if check.Details != nil {
+				res.Properties["details"] = check.Details
+			}
+			if loc := check.sarifLocation(); loc != nil {
+				res.Locations = []*sarifLocation{loc}
+			}
+			run.Results = append(run.Results, res)
+		}
+		log.Runs = append(log.Runs, run)
+	}
+	return log
+}
@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// resolveSecret returns the secret which ref refers to, such that
+// secrets neither end up in the shell history nor in config files:
+//
+//	env:NAME                 the environment variable NAME
+//	file:PATH                the first line of the file at PATH
+//	keyring:SERVICE/ACCOUNT  an entry of the keyring of the OS
+//
+// Other values are literal secrets.
+func resolveSecret(ref string) (string, error) {
+	i := strings.IndexByte(ref, ':')
+	if i < 0 {
+		return ref, nil
+	}
+	scheme, arg := ref[:i], ref[i+1:]
+	switch scheme {
+	case "env":
+		val, ok := os.LookupEnv(arg)
+		if !ok || val == "" {
+			return "", fmt.Errorf("secret %s: environment variable is not set", ref)
+		}
+		return val, nil
+	case "file":
+		raw, err := ioutil.ReadFile(arg)
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %w", ref, err)
+		}
+		val := strings.TrimRight(strings.SplitN(string(raw), "\n", 2)[0], "\r")
+		if val == "" {
+			return "", fmt.Errorf("secret %s: file is empty", ref)
+		}
+		return val, nil
+	case "keyring":
+		val, err := keyringLookup(arg)
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %w", ref, err)
+		}
+		return val, nil
+	}
+	return ref, nil
+}
+
+// keyringLookup uses secret-tool(1) of libsecret on Linux and
+// security(1) on macOS.
+func keyringLookup(entry string) (string, error) {
+	i := strings.LastIndexByte(entry, '/')
+	if i <= 0 || i == len(entry)-1 {
+		return "", fmt.Errorf("expected 'service/account'")
+	}
+	service, account := entry[:i], entry[i+1:]
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	default:
+		cmd = exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %s", cmd.Path, msg)
+		}
+		return "", fmt.Errorf("%s: %w", cmd.Path, err)
+	}
+	val := strings.TrimRight(string(out), "\r\n")
+	if val == "" {
+		return "", fmt.Errorf("no keyring entry")
+	}
+	return val, nil
+}
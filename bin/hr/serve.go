@@ -0,0 +1,276 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+const (
+	// serveBacklog is the number of events kept for clients which
+	// resume with Last-Event-ID.
+	serveBacklog = 1000
+	// serveQueue is the number of events a client may lag behind
+	// before it is disconnected.
+	serveQueue = 256
+	// serveCloseTimeout limits the time to send pending events at
+	// the end of the input.
+	serveCloseTimeout = 2 * time.Second
+)
+
+type serveEvent struct {
+	id   uint64
+	json []byte
+	hr   string
+}
+
+type subscriber struct {
+	ch chan *serveEvent
+}
+
+// streamServer publishes the records shown on stdout to HTTP clients,
+// either as Server-Sent Events or as chunked NDJSON or text.
+type streamServer struct {
+	mutex       sync.Mutex
+	listener    net.Listener
+	token       []byte
+	formatter   *penlog.HRFormatter
+	nextID      uint64
+	backlog     []*serveEvent
+	subscribers map[*subscriber]struct{}
+	closed      bool
+	handlers    sync.WaitGroup
+}
+
+// newStreamServer refuses to publish records to the network without
+// authentication: addresses other than loopback require a token or
+// client certificates.
+func newStreamServer(addr string, cfg *tls.Config, token string) (*streamServer, error) {
+	mutualTLS := cfg != nil && cfg.ClientAuth == tls.RequireAndVerifyClientCert
+	if token == "" && !mutualTLS && !isLoopback(addr) {
+		return nil, errors.New("--serve on other addresses than loopback requires --serve-token or client certificates with --tls-client-ca")
+	}
+	ln, err := listen(addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &streamServer{
+		listener:    ln,
+		token:       []byte(token),
+		nextID:      1,
+		subscribers: make(map[*subscriber]struct{}),
+	}, nil
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// serve accepts clients in the background. The rendered format uses a
+// copy of formatter without colors.
+func (s *streamServer) serve(formatter *penlog.HRFormatter) {
+	f := *formatter
+	f.ShowColors = false
+	s.formatter = &f
+	go http.Serve(s.listener, s)
+}
+
+func (s *streamServer) publish(data map[string]interface{}) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	str, err := s.formatter.Format(data)
+	if err != nil {
+		str, _ = s.formatter.Format(createErrorRecord(string(raw)))
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ev := &serveEvent{id: s.nextID, json: raw, hr: str}
+	s.nextID++
+	s.backlog = append(s.backlog, ev)
+	if len(s.backlog) > serveBacklog {
+		s.backlog = s.backlog[len(s.backlog)-serveBacklog:]
+	}
+	for sub := range s.subscribers {
+		select {
+		case sub.ch <- ev:
+		default:
+			// Slow clients are dropped; they can resume with
+			// Last-Event-ID as long as the backlog suffices.
+			close(sub.ch)
+			delete(s.subscribers, sub)
+		}
+	}
+}
+
+// close ends all streams after the pending events have been sent.
+func (s *streamServer) close() {
+	s.mutex.Lock()
+	s.closed = true
+	for sub := range s.subscribers {
+		close(sub.ch)
+		delete(s.subscribers, sub)
+	}
+	s.mutex.Unlock()
+	s.listener.Close()
+
+	// Clients which do not read must not block the exit.
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(serveCloseTimeout):
+	}
+}
+
+// subscribe registers a client and returns the events after lastID
+// which are still in the backlog.
+func (s *streamServer) subscribe(lastID uint64, resume bool) (*subscriber, []*serveEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, nil
+	}
+	var replay []*serveEvent
+	if resume {
+		for _, ev := range s.backlog {
+			if ev.id > lastID {
+				replay = append(replay, ev)
+			}
+		}
+	}
+	sub := &subscriber{ch: make(chan *serveEvent, serveQueue)}
+	s.subscribers[sub] = struct{}{}
+	s.handlers.Add(1)
+	return sub, replay
+}
+
+func (s *streamServer) unsubscribe(sub *subscriber) {
+	s.mutex.Lock()
+	if _, ok := s.subscribers[sub]; ok {
+		close(sub.ch)
+		delete(s.subscribers, sub)
+	}
+	s.mutex.Unlock()
+	s.handlers.Done()
+}
+
+func (s *streamServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(s.token) > 0 {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), s.token) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = sinkFormatJSON
+	case sinkFormatJSON, sinkFormatHR:
+	default:
+		http.Error(w, fmt.Sprintf("invalid format: %s", format), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var (
+		sse         = strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+		rawID       = r.Header.Get("Last-Event-ID")
+		lastID, err = strconv.ParseUint(rawID, 10, 64)
+	)
+	sub, replay := s.subscribe(lastID, rawID != "" && err == nil)
+	if sub == nil {
+		http.Error(w, "the stream has ended", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.unsubscribe(sub)
+
+	switch {
+	case sse:
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	case format == sinkFormatJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	write := func(ev *serveEvent) error {
+		var b strings.Builder
+		payload := string(ev.json)
+		if format == sinkFormatHR {
+			payload = ev.hr
+		}
+		if sse {
+			fmt.Fprintf(&b, "id: %d\n", ev.id)
+			for _, line := range strings.Split(payload, "\n") {
+				fmt.Fprintf(&b, "data: %s\n", line)
+			}
+			b.WriteString("\n")
+		} else {
+			b.WriteString(payload + "\n")
+		}
+		if _, err := w.Write([]byte(b.String())); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	for _, ev := range replay {
+		if err := write(ev); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case ev, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			if err := write(ev); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// sessionLog keeps a copy of everything written to stdout in a file,
+// such that a session can be reconstructed later. The file starts with
+// a header describing the invocation and the filters.
+type sessionLog struct {
+	file   *os.File
+	stdout *os.File
+	w      *os.File
+	done   chan struct{}
+}
+
+// createSessionFile creates a file in dir which does not exist yet,
+// named after the start of the session.
+func createSessionFile(dir string, start time.Time) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	base := filepath.Join(dir, "hr-"+start.Format("20060102T150405"))
+	for i := 0; ; i++ {
+		name := base + ".log"
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d.log", base, i)
+		}
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		return file, err
+	}
+}
+
+// openSessionLog replaces os.Stdout with a pipe which is copied to the
+// terminal and to the session log.
+func openSessionLog(dir string, c *converter) (*sessionLog, error) {
+	start := time.Now()
+	file, err := createSessionFile(dir, start)
+	if err != nil {
+		return nil, err
+	}
+	var header strings.Builder
+	fmt.Fprintf(&header, "# hr session started at %s\n", start.Format(time.RFC3339))
+	if version != "" {
+		fmt.Fprintf(&header, "# version: %s\n", version)
+	}
+	if wd, err := os.Getwd(); err == nil {
+		fmt.Fprintf(&header, "# directory: %s\n", wd)
+	}
+	fmt.Fprintf(&header, "# args: %s\n", strings.Join(savedArgs(), " "))
+	inputs := "stdin"
+	if pflag.NArg() > 0 {
+		inputs = strings.Join(pflag.Args(), " ")
+	}
+	fmt.Fprintf(&header, "# inputs: %s\n", inputs)
+	prio := "<=" + prioName(c.logLevel)
+	for _, o := range c.prioOverrides {
+		prio += fmt.Sprintf(", %s<=%s", o.pattern, prioName(o.prio))
+	}
+	fmt.Fprintf(&header, "# priority: %s\n", prio)
+	filters := "none"
+	if sources := c.filterSources(); len(sources) > 0 {
+		filters = strings.Join(sources, " ")
+	}
+	fmt.Fprintf(&header, "# filters: %s\n", filters)
+	if _, err := file.WriteString(header.String()); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	s := &sessionLog{
+		file:   file,
+		stdout: os.Stdout,
+		w:      w,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		// Errors of the log must not disturb the terminal.
+		io.Copy(s.stdout, io.TeeReader(r, &errorlessWriter{w: file}))
+		r.Close()
+		close(s.done)
+	}()
+	os.Stdout = w
+	return s, nil
+}
+
+// close restores stdout after all output has been copied.
+func (s *sessionLog) close() {
+	os.Stdout = s.stdout
+	s.w.Close()
+	<-s.done
+	fmt.Fprintf(s.file, "# session ended at %s\n", time.Now().Format(time.RFC3339))
+	s.file.Close()
+}
+
+// errorlessWriter stops writing after the first error but never fails.
+type errorlessWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errorlessWriter) Write(p []byte) (int, error) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	sinkFormatJSON    = "json"
+	sinkFormatHR      = "hr"
+	sinkFormatFixture = "fixture"
+
+	// zstdLongWindowLog is the window of long mode, as with zstd --long.
+	zstdLongWindowLog = 27
+)
+
+// sinkOptions are appended to the filename of a filter like a URL
+// query, e.g. "error:errors.log?format=hr&colors=1".
+type sinkOptions struct {
+	format string
+	// colors is nil if the default of the sink applies: disabled
+	// for files, enabled for stdout if it is a terminal.
+	colors *bool
+	// The zstd options apply to .zst files only. checksum is nil
+	// for the default, which is to write checksums; windowLog is 0
+	// for the default of the compression level.
+	long      bool
+	checksum  *bool
+	windowLog int
+}
+
+// parseSinkOptions splits the options from filename.
+func parseSinkOptions(filename string) (string, sinkOptions, error) {
+	opts := sinkOptions{format: sinkFormatJSON}
+	i := strings.LastIndex(filename, "?")
+	if i < 0 {
+		return filename, opts, nil
+	}
+	query, err := url.ParseQuery(filename[i+1:])
+	if err != nil {
+		return "", opts, fmt.Errorf("invalid sink options '%s': %w", filename[i+1:], err)
+	}
+	filename = filename[:i]
+	for key, vals := range query {
+		val := vals[len(vals)-1]
+		switch key {
+		case "format":
+			if val != sinkFormatJSON && val != sinkFormatHR && val != sinkFormatFixture {
+				return "", opts, fmt.Errorf("invalid sink format: %s", val)
+			}
+			opts.format = val
+		case "colors":
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return "", opts, fmt.Errorf("invalid value for colors: %s", val)
+			}
+			opts.colors = &b
+		case "long":
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return "", opts, fmt.Errorf("invalid value for long: %s", val)
+			}
+			opts.long = b
+		case "checksum":
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return "", opts, fmt.Errorf("invalid value for checksum: %s", val)
+			}
+			opts.checksum = &b
+		case "windowlog":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 10 || 1<<n > zstd.MaxWindowSize {
+				return "", opts, fmt.Errorf("invalid value for windowlog: %s, expected 10 to 29", val)
+			}
+			opts.windowLog = n
+		default:
+			return "", opts, fmt.Errorf("unknown sink option: %s", key)
+		}
+	}
+	if _, ok := query["format"]; ok && filename == "-" {
+		return "", opts, fmt.Errorf("only colors can be set for stdout")
+	}
+	if opts.hasZstdOptions() && filepath.Ext(filename) != ".zst" {
+		return "", opts, fmt.Errorf("long, checksum, and windowlog require a .zst file")
+	}
+	return filename, opts, nil
+}
+
+func (o sinkOptions) hasZstdOptions() bool {
+	return o.long || o.checksum != nil || o.windowLog != 0
+}
+
+// zstdOptions returns the options of the zstd encoder. Long mode
+// selects the window of zstd --long and the best compression level;
+// the encoder has no separate long distance matcher, but with the
+// large window repetitions far apart are found as well.
+func (o sinkOptions) zstdOptions() []zstd.EOption {
+	var opts []zstd.EOption
+	windowLog := o.windowLog
+	if o.long {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+		if windowLog == 0 {
+			windowLog = zstdLongWindowLog
+		}
+	}
+	if windowLog != 0 {
+		opts = append(opts, zstd.WithWindowSize(1<<windowLog))
+	}
+	if o.checksum != nil {
+		opts = append(opts, zstd.WithEncoderCRC(*o.checksum))
+	}
+	return opts
+}
+
+func (o sinkOptions) showColors(def bool) bool {
+	if o.colors != nil {
+		return *o.colors
+	}
+	return def
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/Fraunhofer-AISEC/penlog/filter"
+)
+
+type seenStacktrace struct {
+	first string
+	short string
+	count int
+}
+
+// stacktraceFolder shows every distinct stacktrace once; repetitions
+// are replaced by a reference to the first occurrence. Crash loops
+// otherwise flood the terminal with identical dumps.
+type stacktraceFolder struct {
+	timespec string
+	// seen is keyed by the full digest; the short form is only shown.
+	seen map[[sha256.Size]byte]*seenStacktrace
+}
+
+func newStacktraceFolder(timespec string) *stacktraceFolder {
+	return &stacktraceFolder{
+		timespec: timespec,
+		seen:     make(map[[sha256.Size]byte]*seenStacktrace),
+	}
+}
+
+// take removes the stacktrace from a copy of data, such that the
+// formatter does not render it.
+func (f *stacktraceFolder) take(data map[string]interface{}) (map[string]interface{}, string) {
+	trace, ok := data["stacktrace"].(string)
+	if !ok || strings.TrimSpace(trace) == "" {
+		return data, ""
+	}
+	d := copyData(data)
+	delete(d, "stacktrace")
+	return d, trace
+}
+
+// render returns the lines to append to the rendered record. The first
+// occurrence looks like the output of the formatter, but headers and
+// messages are highlighted.
+func (f *stacktraceFolder) render(data map[string]interface{}, trace string, colors bool) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(trace)))
+	if seen, ok := f.seen[sum]; ok {
+		seen.count++
+		ref := fmt.Sprintf("  => stacktrace: repeated %s, first at %s, seen %d times", seen.short, seen.first, seen.count)
+		if colors {
+			ref = colorize(colorGray, ref)
+		}
+		return "\n" + ref
+	}
+	first, _ := fieldString(data, "timestamp")
+	if ts, err := filter.ParseTimestamp(first); err == nil {
+		first = ts.Format(f.timespec)
+	}
+	f.seen[sum] = &seenStacktrace{first: first, short: hex.EncodeToString(sum[:4]), count: 1}
+
+	var b strings.Builder
+	b.WriteString("\n  => stacktrace: \n")
+	for _, line := range strings.Split(trace, "\n") {
+		switch {
+		case !colors:
+			b.WriteString("  |" + line)
+		// Unindented lines are headers or messages, e.g. the
+		// goroutine of go or the exception of python.
+		case line != "" && line[0] != ' ' && line[0] != '\t':
+			b.WriteString(colorize(colorGray, "  |") + colorize(colorBold, line))
+		default:
+			b.WriteString(colorize(colorGray, "  |"+line))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
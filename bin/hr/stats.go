@@ -0,0 +1,280 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Fraunhofer-AISEC/penlog/filter"
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+// Bucket sizes which are tried in order when --stats-bucket is unset.
+var statsBucketSizes = []time.Duration{
+	time.Second,
+	10 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+}
+
+type statsBucket struct {
+	total  int
+	errors int
+}
+
+type stats struct {
+	total      int
+	components map[string]int
+	types      map[string]int
+	priorities map[string]int
+	payloads   map[string]int
+	first      time.Time
+	last       time.Time
+	// Buckets are collected per second and merged when reporting.
+	seconds map[int64]*statsBucket
+	order   *orderChecker
+}
+
+func newStats(orderThreshold time.Duration) *stats {
+	return &stats{
+		order:      newOrderChecker(orderThreshold),
+		components: make(map[string]int),
+		types:      make(map[string]int),
+		priorities: make(map[string]int),
+		payloads:   make(map[string]int),
+		seconds:    make(map[int64]*statsBucket),
+	}
+}
+
+func isErrorRecord(data map[string]interface{}) bool {
+	if p, ok := fieldPrio(data); ok {
+		return p <= penlog.PrioError
+	}
+	comp, _ := fieldString(data, "component")
+	msgType, _ := fieldString(data, "type")
+	return comp == "JSON" && msgType == "ERROR"
+}
+
+func (s *stats) add(data map[string]interface{}) {
+	s.total++
+
+	comp, _ := fieldString(data, "component")
+	msgType, _ := fieldString(data, "type")
+	payload, _ := fieldString(data, "data")
+	s.components[comp]++
+	s.types[msgType]++
+	s.payloads[payload]++
+
+	prio := "unset"
+	if p, ok := fieldPrio(data); ok {
+		prio = prioName(p)
+	}
+	s.priorities[prio]++
+	s.order.check(data)
+
+	ts, err := castField(data, "timestamp")
+	if err != nil {
+		return
+	}
+	t, err := filter.ParseTimestamp(ts)
+	if err != nil {
+		return
+	}
+	if s.first.IsZero() || t.Before(s.first) {
+		s.first = t
+	}
+	if t.After(s.last) {
+		s.last = t
+	}
+	b, ok := s.seconds[t.Unix()]
+	if !ok {
+		b = &statsBucket{}
+		s.seconds[t.Unix()] = b
+	}
+	b.total++
+	if isErrorRecord(data) {
+		b.errors++
+	}
+}
+
+func (s *stats) read(r io.Reader) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var data map[string]interface{}
+			if err := json.Unmarshal(line, &data); err != nil {
+				data = createErrorRecord(strings.TrimRight(string(line), "\n"))
+			}
+			s.add(data)
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.add(createErrorRecord(err.Error()))
+			}
+			return
+		}
+	}
+}
+
+type statsCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type statsErrorRate struct {
+	Start  time.Time `json:"start"`
+	Total  int       `json:"total"`
+	Errors int       `json:"errors"`
+	Rate   float64   `json:"rate"`
+}
+
+type statsReport struct {
+	Total         int              `json:"total"`
+	First         *time.Time       `json:"first,omitempty"`
+	Last          *time.Time       `json:"last,omitempty"`
+	OutOfOrder    int              `json:"out_of_order"`
+	MaxRegression float64          `json:"max_regression"`
+	Components    []statsCount     `json:"components"`
+	Types         []statsCount     `json:"types"`
+	Priorities    []statsCount     `json:"priorities"`
+	Bucket        string           `json:"bucket,omitempty"`
+	ErrorRates    []statsErrorRate `json:"error_rates"`
+	Payloads      []statsCount     `json:"top_payloads"`
+}
+
+// sortCounts orders by count, most frequent first. Ties are sorted
+// by name to get a stable output.
+func sortCounts(m map[string]int, n int) []statsCount {
+	res := make([]statsCount, 0, len(m))
+	for name, count := range m {
+		res = append(res, statsCount{name, count})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Name < res[j].Name
+	})
+	if n > 0 && len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+func (s *stats) report(bucket time.Duration, topN int) *statsReport {
+	r := statsReport{
+		Total:         s.total,
+		Components:    sortCounts(s.components, 0),
+		Types:         sortCounts(s.types, 0),
+		Priorities:    sortCounts(s.priorities, 0),
+		Payloads:      sortCounts(s.payloads, topN),
+		ErrorRates:    []statsErrorRate{},
+		OutOfOrder:    s.order.regressions,
+		MaxRegression: s.order.max.Seconds(),
+	}
+	if s.first.IsZero() {
+		return &r
+	}
+	first, last := s.first, s.last
+	r.First, r.Last = &first, &last
+
+	if bucket <= 0 {
+		// Aim for roughly ten buckets.
+		span := s.last.Sub(s.first)
+		bucket = statsBucketSizes[len(statsBucketSizes)-1]
+		for _, size := range statsBucketSizes {
+			if span/size <= 10 {
+				bucket = size
+				break
+			}
+		}
+	}
+	r.Bucket = bucket.String()
+
+	merged := make(map[int64]*statsBucket)
+	for sec, b := range s.seconds {
+		start := time.Unix(sec, 0).Truncate(bucket).Unix()
+		m, ok := merged[start]
+		if !ok {
+			m = &statsBucket{}
+			merged[start] = m
+		}
+		m.total += b.total
+		m.errors += b.errors
+	}
+	starts := make([]int64, 0, len(merged))
+	for start := range merged {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	for _, start := range starts {
+		b := merged[start]
+		r.ErrorRates = append(r.ErrorRates, statsErrorRate{
+			Start:  time.Unix(start, 0).In(s.first.Location()),
+			Total:  b.total,
+			Errors: b.errors,
+			Rate:   float64(b.errors) / float64(b.total),
+		})
+	}
+	return &r
+}
+
+func writeCounts(w io.Writer, title string, counts []statsCount) {
+	fmt.Fprintf(w, "\n%s:\n", title)
+	for _, c := range counts {
+		name := strings.ReplaceAll(c.Name, "\n", " ")
+		if name == "" {
+			name = "<none>"
+		} else if r := []rune(name); len(r) > 60 {
+			name = string(r[:59]) + "…"
+		}
+		fmt.Fprintf(w, "  %8d  %s\n", c.Count, name)
+	}
+}
+
+func (r *statsReport) writeHR(w io.Writer) {
+	fmt.Fprintf(w, "records:  %d\n", r.Total)
+	if r.First != nil {
+		fmt.Fprintf(w, "first:    %s\n", r.First.Format(time.RFC3339Nano))
+		fmt.Fprintf(w, "last:     %s\n", r.Last.Format(time.RFC3339Nano))
+		fmt.Fprintf(w, "duration: %s\n", r.Last.Sub(*r.First))
+		if r.OutOfOrder > 0 {
+			fmt.Fprintf(w, "out of order: %d (at most by %s)\n", r.OutOfOrder, time.Duration(r.MaxRegression*float64(time.Second)))
+		}
+	}
+	writeCounts(w, "components", r.Components)
+	writeCounts(w, "types", r.Types)
+	writeCounts(w, "priorities", r.Priorities)
+	if len(r.ErrorRates) > 0 {
+		fmt.Fprintf(w, "\nerror rate per %s:\n", r.Bucket)
+		for _, e := range r.ErrorRates {
+			fmt.Fprintf(w, "  %s  %6.2f%% (%d/%d)\n", e.Start.Format("2006-01-02T15:04:05"), 100*e.Rate, e.Errors, e.Total)
+		}
+	}
+	writeCounts(w, fmt.Sprintf("top %d payloads", len(r.Payloads)), r.Payloads)
+}
+
+func (r *statsReport) write(w io.Writer, format string) error {
+	switch format {
+	case "", "hr":
+		r.writeHR(w)
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	default:
+		return fmt.Errorf("invalid stats format: %s", format)
+	}
+	return nil
+}
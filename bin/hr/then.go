@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Fraunhofer-AISEC/penlog/pipeline"
+)
+
+// statsStage consumes the records for --then stats and writes the same
+// report as --stats at the end.
+type statsStage struct {
+	stats  *stats
+	bucket time.Duration
+	top    int
+	format string
+}
+
+func (s *statsStage) Process(r pipeline.Record) (pipeline.Record, error) {
+	s.stats.add(r)
+	return nil, nil
+}
+
+func (s *statsStage) Finish() error {
+	return s.stats.report(s.bucket, s.top).write(os.Stdout, s.format)
+}
+
+// fixtureStage consumes the records for --then fixture and writes
+// them as a fixture to stdout at the end.
+type fixtureStage struct {
+	fixture *fixture
+}
+
+func (s *fixtureStage) Process(r pipeline.Record) (pipeline.Record, error) {
+	s.fixture.add(r)
+	return nil, nil
+}
+
+func (s *fixtureStage) Finish() error {
+	return s.fixture.write(os.Stdout)
+}
+
+type thenOptions struct {
+	orderThreshold time.Duration
+	statsBucket    time.Duration
+	statsTop       int
+	statsFormat    string
+}
+
+// newThenPipeline builds the stages of --then. All stages consume the
+// records, so only the last stage can receive any.
+func newThenPipeline(names []string, opts thenOptions) (*pipeline.Pipeline, error) {
+	p := pipeline.New()
+	for i, name := range names {
+		switch name {
+		case "stats":
+			p.Then(&statsStage{
+				stats:  newStats(opts.orderThreshold),
+				bucket: opts.statsBucket,
+				top:    opts.statsTop,
+				format: opts.statsFormat,
+			})
+		case "fixture":
+			p.Then(&fixtureStage{fixture: newFixture()})
+		default:
+			return nil, fmt.Errorf("invalid stage for --then: %s", name)
+		}
+		if i < len(names)-1 {
+			return nil, fmt.Errorf("invalid stages for --then: %s consumes all records, '%s' would not receive any", name, names[i+1])
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// tsaSuffix is appended to the name of a file output for the file
+// which keeps its timestamps.
+const tsaSuffix = ".tsa.json"
+
+// tsaEntry is one line of the timestamp file: the token covers the
+// SHA-256 digest of the first offset bytes of the uncompressed output.
+type tsaEntry struct {
+	Offset int64  `json:"offset"`
+	SHA256 string `json:"sha256"`
+	Time   string `json:"time"`
+	Serial string `json:"serial"`
+	TSA    string `json:"tsa"`
+	Token  []byte `json:"token"`
+}
+
+// hashingWriter keeps the rolling digest of everything written to w.
+type hashingWriter struct {
+	w io.Writer
+	h hash.Hash
+	n int64
+}
+
+func newHashingWriter(w io.Writer) *hashingWriter {
+	return &hashingWriter{w: w, h: sha256.New()}
+}
+
+func (hw *hashingWriter) Write(p []byte) (int, error) {
+	n, err := hw.w.Write(p)
+	hw.h.Write(p[:n])
+	hw.n += int64(n)
+	return n, err
+}
+
+type tsaJob struct {
+	offset int64
+	digest []byte
+}
+
+// timestamper requests timestamps in the background, such that a slow
+// time-stamping authority does not stall the output.
+type timestamper struct {
+	url      string
+	client   *http.Client
+	filename string
+	file     *os.File
+	encoder  interface{ Encode(interface{}) error }
+	jobs     chan tsaJob
+	wg       sync.WaitGroup
+	last     int64
+	colors   bool
+}
+
+func newTimestamper(url string, output string, colors bool) (*timestamper, error) {
+	filename := output + tsaSuffix
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	t := &timestamper{
+		url:      url,
+		client:   &http.Client{Timeout: tsaTimeout},
+		filename: filename,
+		file:     file,
+		encoder:  json.NewEncoder(file),
+		jobs:     make(chan tsaJob, 16),
+		colors:   colors,
+	}
+	t.wg.Add(1)
+	go t.run()
+	return t, nil
+}
+
+func (t *timestamper) run() {
+	defer t.wg.Done()
+	for job := range t.jobs {
+		raw, err := requestTimestamp(t.client, t.url, job.digest)
+		if err == nil {
+			var token *timestampToken
+			if token, err = parseTimestampToken(raw); err == nil {
+				err = t.encoder.Encode(tsaEntry{
+					Offset: job.offset,
+					SHA256: hex.EncodeToString(job.digest),
+					Time:   token.genTime.UTC().Format(timestampFormat),
+					Serial: token.info.SerialNumber.String(),
+					TSA:    t.url,
+					Token:  raw,
+				})
+			}
+		}
+		if err != nil {
+			stderrMutex.Lock()
+			colorEprintf(colorYellow, t.colors, "warning: timestamping %d bytes of %s failed: %s\n", job.offset, t.filename, err)
+			stderrMutex.Unlock()
+		}
+	}
+}
+
+// stamp requests a timestamp for everything written to hw so far.
+func (t *timestamper) stamp(hw *hashingWriter) {
+	if hw.n == t.last {
+		return
+	}
+	t.last = hw.n
+	t.jobs <- tsaJob{offset: hw.n, digest: hw.h.Sum(nil)}
+}
+
+// close waits for the pending requests.
+func (t *timestamper) close() {
+	close(t.jobs)
+	t.wg.Wait()
+	t.file.Close()
+}
+
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", filename)
+	}
+	return pool, nil
+}
+
+// verifyTimestamps checks the tokens of filename in its timestamp file
+// against the uncompressed content and writes one line per token to w.
+// It returns the number of invalid tokens; uncovered content at the
+// end is reported, but no failure.
+func verifyTimestamps(w io.Writer, filename string, roots *x509.CertPool) (int, error) {
+	tsaFile, err := os.Open(filename + tsaSuffix)
+	if err != nil {
+		return 0, err
+	}
+	defer tsaFile.Close()
+	reader, err := getReader(filename)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		h        = sha256.New()
+		scanner  = bufio.NewScanner(tsaFile)
+		pos      int64
+		lineno   int
+		failures int
+	)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lineno++
+		var entry tsaEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return failures, fmt.Errorf("%s%s:%d: %w", filename, tsaSuffix, lineno, err)
+		}
+		if entry.Offset < pos {
+			return failures, fmt.Errorf("%s%s:%d: offsets are not ascending", filename, tsaSuffix, lineno)
+		}
+		n, err := io.CopyN(h, reader, entry.Offset-pos)
+		pos += n
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				fmt.Fprintf(w, "%s: timestamp %d: covers %d bytes, but the file has only %d\n", filename, lineno, entry.Offset, pos)
+				failures++
+				break
+			}
+			return failures, err
+		}
+		digest := h.Sum(nil)
+		token, err := parseTimestampToken(entry.Token)
+		if err == nil {
+			err = token.verify(digest, roots)
+		}
+		if err != nil {
+			fmt.Fprintf(w, "%s: timestamp %d: %s\n", filename, lineno, err)
+			failures++
+			continue
+		}
+		fmt.Fprintf(w, "%s: %d bytes existed at %s (serial %s)\n", filename, entry.Offset, token.genTime.UTC().Format("2006-01-02T15:04:05Z"), token.info.SerialNumber)
+	}
+	if err := scanner.Err(); err != nil {
+		return failures, err
+	}
+	if rest, err := io.Copy(ioutil.Discard, reader); err != nil {
+		return failures, err
+	} else if rest > 0 {
+		fmt.Fprintf(w, "%s: %d bytes after the last timestamp are not covered\n", filename, rest)
+	}
+	return failures, nil
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+)
+
+// tlsOptions configure all network transports: the certificate is
+// presented by --serve and --listen and sent as client certificate by
+// --input; ca verifies the servers of --input and clientCA, if set, the
+// mandatory client certificates of --serve and --listen.
+type tlsOptions struct {
+	cert       string
+	key        string
+	ca         string
+	clientCA   string
+	serverName string
+}
+
+func (o *tlsOptions) check() error {
+	if (o.cert == "") != (o.key == "") {
+		return errors.New("--tls-cert and --tls-key must be specified together")
+	}
+	return nil
+}
+
+func (o *tlsOptions) certificates() ([]tls.Certificate, error) {
+	if o.cert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(o.cert, o.key)
+	if err != nil {
+		return nil, err
+	}
+	return []tls.Certificate{cert}, nil
+}
+
+// pool loads the CA certificates of file, if given.
+func (o *tlsOptions) pool(file string) (*x509.CertPool, error) {
+	if file == "" {
+		return nil, nil
+	}
+	return loadCertPool(file)
+}
+
+// serverConfig returns nil if no certificate is configured.
+func (o *tlsOptions) serverConfig() (*tls.Config, error) {
+	certs, err := o.certificates()
+	if err != nil || certs == nil {
+		if err == nil && o.clientCA != "" {
+			err = errors.New("--tls-client-ca requires --tls-cert")
+		}
+		return nil, err
+	}
+	pool, err := o.pool(o.clientCA)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: certs,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if pool != nil {
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+func (o *tlsOptions) clientConfig() (*tls.Config, error) {
+	certs, err := o.certificates()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := o.pool(o.ca)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: certs,
+		RootCAs:      pool,
+		ServerName:   o.serverName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// listen opens a TCP listener which speaks TLS if cfg is not nil.
+func listen(addr string, cfg *tls.Config) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg != nil {
+		ln = tls.NewListener(ln, cfg)
+	}
+	return ln, nil
+}
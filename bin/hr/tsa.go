@@ -0,0 +1,346 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+
+	// The digests of tokens are looked up via crypto.Hash.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// This file implements the subset of RFC 3161 and RFC 5652 (CMS)
+// which is needed to request timestamps and to verify the tokens.
+
+const tsaTimeout = 30 * time.Second
+
+var (
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type encapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	// GenTime is parsed by hand, since encoding/asn1 rejects
+	// fractions of seconds.
+	GenTime    asn1.RawValue
+	Accuracy   accuracy      `asn1:"optional"`
+	Ordering   bool          `asn1:"optional"`
+	Nonce      *big.Int      `asn1:"optional"`
+	TSA        asn1.RawValue `asn1:"optional,explicit,tag:0"`
+	Extensions asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// timestampToken is a parsed RFC 3161 token.
+type timestampToken struct {
+	raw      []byte
+	info     tstInfo
+	genTime  time.Time
+	signed   signedData
+	certs    []*x509.Certificate
+	eContent []byte
+}
+
+func hashOfOID(oid asn1.ObjectIdentifier) (crypto.Hash, bool) {
+	switch {
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, true
+	case oid.Equal(oidSHA384):
+		return crypto.SHA384, true
+	case oid.Equal(oidSHA512):
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+// requestTimestamp obtains a token for the SHA-256 digest from the
+// time-stamping authority at url.
+func requestTimestamp(client *http.Client, url string, digest []byte) ([]byte, error) {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	req, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Post(url, "application/timestamp-query", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var tsResp timeStampResp
+	if _, err := asn1.Unmarshal(body, &tsResp); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	// 0 is granted, 1 granted with modifications.
+	if tsResp.Status.Status > 1 {
+		return nil, fmt.Errorf("request rejected with status %d: %v", tsResp.Status.Status, tsResp.Status.StatusString)
+	}
+	token, err := parseTimestampToken(tsResp.TimeStampToken.FullBytes)
+	if err != nil {
+		return nil, err
+	}
+	if token.info.Nonce == nil || token.info.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("the nonce of the token does not match the request")
+	}
+	if !bytes.Equal(token.info.MessageImprint.HashedMessage, digest) {
+		return nil, errors.New("the token covers a different digest")
+	}
+	return token.raw, nil
+}
+
+func parseTimestampToken(raw []byte) (*timestampToken, error) {
+	t := &timestampToken{raw: raw}
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(raw, &ci); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	} else if len(rest) > 0 {
+		return nil, errors.New("invalid token: trailing data")
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("invalid token: unexpected content type %s", ci.ContentType)
+	}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &t.signed); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	if !t.signed.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return nil, errors.New("invalid token: no TSTInfo")
+	}
+	if _, err := asn1.Unmarshal(t.signed.EncapContentInfo.EContent.Bytes, &t.eContent); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	if _, err := asn1.Unmarshal(t.eContent, &t.info); err != nil {
+		return nil, fmt.Errorf("invalid TSTInfo: %w", err)
+	}
+	genTime, err := time.Parse("20060102150405Z0700", string(t.info.GenTime.Bytes))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TSTInfo: %w", err)
+	}
+	t.genTime = genTime
+	if len(t.signed.Certificates.Bytes) > 0 {
+		if t.certs, err = x509.ParseCertificates(t.signed.Certificates.Bytes); err != nil {
+			return nil, fmt.Errorf("invalid token: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// signer returns the certificate which matches the signer identifier.
+func (t *timestampToken) signer(si *signerInfo) (*x509.Certificate, error) {
+	var ias issuerAndSerial
+	isSerial := false
+	if _, err := asn1.Unmarshal(si.SID.FullBytes, &ias); err == nil {
+		isSerial = true
+	}
+	for _, cert := range t.certs {
+		if isSerial {
+			if bytes.Equal(cert.RawIssuer, ias.Issuer.FullBytes) && cert.SerialNumber.Cmp(ias.Serial) == 0 {
+				return cert, nil
+			}
+		} else if si.SID.Class == asn1.ClassContextSpecific && bytes.Equal(cert.SubjectKeyId, si.SID.Bytes) {
+			return cert, nil
+		}
+	}
+	return nil, errors.New("the certificate of the signer is not part of the token")
+}
+
+// verify checks the signature of the token, its certificate chain
+// against roots at the time of the token, and that the token covers
+// digest. roots is nil for the system roots.
+func (t *timestampToken) verify(digest []byte, roots *x509.CertPool) error {
+	imprint := t.info.MessageImprint
+	if h, ok := hashOfOID(imprint.HashAlgorithm.Algorithm); !ok || h != crypto.SHA256 {
+		return fmt.Errorf("unexpected digest algorithm %s", imprint.HashAlgorithm.Algorithm)
+	}
+	if !bytes.Equal(imprint.HashedMessage, digest) {
+		return errors.New("the token covers a different digest")
+	}
+	if len(t.signed.SignerInfos) != 1 {
+		return fmt.Errorf("expected one signer, got %d", len(t.signed.SignerInfos))
+	}
+	si := &t.signed.SignerInfos[0]
+	hash, ok := hashOfOID(si.DigestAlgorithm.Algorithm)
+	if !ok {
+		return fmt.Errorf("unsupported digest algorithm %s", si.DigestAlgorithm.Algorithm)
+	}
+	if len(si.SignedAttrs.Bytes) == 0 {
+		return errors.New("the token has no signed attributes")
+	}
+
+	// The signed attributes carry the digest of the TSTInfo.
+	var (
+		rest          = si.SignedAttrs.Bytes
+		messageDigest []byte
+		contentType   asn1.ObjectIdentifier
+	)
+	for len(rest) > 0 {
+		var attr attribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return fmt.Errorf("invalid signed attributes: %w", err)
+		}
+		switch {
+		case attr.Type.Equal(oidMessageDigest):
+			asn1.Unmarshal(attr.Values.Bytes, &messageDigest)
+		case attr.Type.Equal(oidContentType):
+			asn1.Unmarshal(attr.Values.Bytes, &contentType)
+		}
+	}
+	if !contentType.Equal(oidTSTInfo) {
+		return errors.New("the signed content is no TSTInfo")
+	}
+	h := hash.New()
+	h.Write(t.eContent)
+	if !bytes.Equal(h.Sum(nil), messageDigest) {
+		return errors.New("the TSTInfo does not match its signed digest")
+	}
+
+	// The signature covers the DER encoding of the attributes as
+	// SET OF, not with the implicit tag.
+	signedAttrs := append([]byte{}, si.SignedAttrs.FullBytes...)
+	signedAttrs[0] = 0x31
+	h = hash.New()
+	h.Write(signedAttrs)
+	hashed := h.Sum(nil)
+
+	cert, err := t.signer(si)
+	if err != nil {
+		return err
+	}
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(pub, hash, hashed, si.Signature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, hashed, si.Signature) {
+			err = errors.New("ecdsa verification failure")
+		}
+	default:
+		err = fmt.Errorf("unsupported public key %T", pub)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range t.certs {
+		if c != cert {
+			intermediates.AddCert(c)
+		}
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   t.genTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	})
+	if err != nil {
+		return fmt.Errorf("untrusted signer %q: %w", cert.Subject.CommonName, err)
+	}
+	return nil
+}
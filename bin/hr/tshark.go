@@ -0,0 +1,227 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+// inputFormatTshark is the output of tshark -T ek. Fields of the
+// packets can be selected like a URL query, e.g.
+// "tshark-ek?fields=ip.src,tcp.dstport".
+const inputFormatTshark = "tshark-ek"
+
+func isTsharkFormat(format string) bool {
+	return format == inputFormatTshark || strings.HasPrefix(format, inputFormatTshark+"?")
+}
+
+// parseTsharkFormat returns the fields selected by the format.
+func parseTsharkFormat(format string) ([]string, error) {
+	i := strings.Index(format, "?")
+	if i < 0 {
+		return nil, nil
+	}
+	query, err := url.ParseQuery(format[i+1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid options of %s '%s': %w", inputFormatTshark, format[i+1:], err)
+	}
+	var fields []string
+	for key, vals := range query {
+		if key != "fields" {
+			return nil, fmt.Errorf("unknown option of %s: %s", inputFormatTshark, key)
+		}
+		for _, val := range vals {
+			fields = append(fields, removeEmpy(strings.Split(val, ","))...)
+		}
+	}
+	return fields, nil
+}
+
+// tsharkReader converts packets of tshark -T ek into records of the
+// component tshark. The innermost protocol is the type, the info
+// column or the addresses are the data, and the selected fields are
+// kept in the field tshark.
+type tsharkReader struct {
+	r      *bufio.Reader
+	fields []string
+	buf    bytes.Buffer
+	err    error
+}
+
+func (t *tsharkReader) Read(p []byte) (int, error) {
+	for t.buf.Len() == 0 {
+		if t.err != nil {
+			return 0, t.err
+		}
+		t.next()
+	}
+	return t.buf.Read(p)
+}
+
+func (t *tsharkReader) next() {
+	line, err := t.r.ReadBytes('\n')
+	if err != nil {
+		t.err = err
+	}
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+	var packet map[string]interface{}
+	if err := json.Unmarshal(line, &packet); err != nil {
+		t.emit(createErrorRecord(string(line)))
+		return
+	}
+	// Bulk index lines of Elasticsearch precede each packet.
+	if _, ok := packet["index"]; ok {
+		return
+	}
+	layers, ok := packet["layers"].(map[string]interface{})
+	if !ok {
+		t.emit(createErrorRecord(fmt.Sprintf("%s: packet without layers: %s", inputFormatTshark, line)))
+		return
+	}
+	t.emit(t.convert(packet, layers))
+}
+
+func (t *tsharkReader) emit(val map[string]interface{}) {
+	line, err := json.Marshal(val)
+	if err != nil {
+		line, _ = json.Marshal(createErrorRecord(err.Error()))
+	}
+	t.buf.Write(line)
+	t.buf.WriteByte('\n')
+}
+
+// tsharkField looks up a field in the layers. name uses the notation
+// of Wireshark, e.g. ip.src. Packets contain it as ip_ip_src below
+// the layer ip or, with tshark -e, as ip_src.
+func tsharkField(layers map[string]interface{}, name string) (interface{}, bool) {
+	key := strings.ReplaceAll(name, ".", "_")
+	if val, ok := layers[key]; ok {
+		return tsharkValue(val), true
+	}
+	proto := key
+	if i := strings.Index(key, "_"); i > 0 {
+		proto = key[:i]
+	}
+	if layer, ok := layers[proto].(map[string]interface{}); ok {
+		if val, ok := layer[proto+"_"+key]; ok {
+			return tsharkValue(val), true
+		}
+	}
+	return nil, false
+}
+
+// tsharkValue unwraps the lists of tshark -e with a single value.
+func tsharkValue(val interface{}) interface{} {
+	if list, ok := val.([]interface{}); ok && len(list) == 1 {
+		return list[0]
+	}
+	return val
+}
+
+func tsharkString(layers map[string]interface{}, name string) string {
+	val, _ := tsharkField(layers, name)
+	s, _ := scalarString(val)
+	return s
+}
+
+// tsharkTime prefers the precise time of the frame over the timestamp
+// of the packet, which has milliseconds. Depending on the version of
+// tshark, the time of the frame is in seconds or in ISO8601.
+func tsharkTime(packet, layers map[string]interface{}) (time.Time, bool) {
+	if raw := tsharkString(layers, "frame.time_epoch"); raw != "" {
+		if ts, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+			return ts, true
+		}
+		parts := strings.SplitN(raw+".", ".", 3)
+		sec, err1 := strconv.ParseInt(parts[0], 10, 64)
+		nsec, err2 := strconv.ParseInt((parts[1] + "000000000")[:9], 10, 64)
+		if err1 == nil && err2 == nil {
+			return time.Unix(sec, nsec), true
+		}
+	}
+	if raw, ok := scalarString(packet["timestamp"]); ok {
+		if ms, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return time.Unix(0, ms*int64(time.Millisecond)), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func tsharkProtocol(layers map[string]interface{}) string {
+	if protos := tsharkString(layers, "frame.protocols"); protos != "" {
+		parts := strings.Split(protos, ":")
+		return parts[len(parts)-1]
+	}
+	if proto := tsharkString(layers, "_ws.col.protocol"); proto != "" {
+		return strings.ToLower(proto)
+	}
+	return "packet"
+}
+
+// tsharkSummary uses the info column if it was requested with
+// tshark -e _ws.col.info; otherwise the addresses are shown.
+func tsharkSummary(layers map[string]interface{}, proto string) string {
+	for _, name := range []string{"_ws.col.info", "_ws.col.Info"} {
+		if info := tsharkString(layers, name); info != "" {
+			return info
+		}
+	}
+	var src, dst string
+	for _, net := range []string{"ip", "ipv6", "eth"} {
+		if src = tsharkString(layers, net+".src"); src != "" {
+			dst = tsharkString(layers, net+".dst")
+			break
+		}
+	}
+	for _, transport := range []string{"tcp", "udp"} {
+		srcPort := tsharkString(layers, transport+".srcport")
+		if srcPort == "" {
+			continue
+		}
+		src += ":" + srcPort
+		dst += ":" + tsharkString(layers, transport+".dstport")
+		break
+	}
+	summary := proto
+	if src != "" {
+		summary = fmt.Sprintf("%s -> %s", src, dst)
+	}
+	if size := tsharkString(layers, "frame.len"); size != "" {
+		summary += fmt.Sprintf(", %s bytes", size)
+	}
+	return summary
+}
+
+func (t *tsharkReader) convert(packet, layers map[string]interface{}) map[string]interface{} {
+	proto := tsharkProtocol(layers)
+	record := createRecord(proto, penlog.PrioInfo, tsharkSummary(layers, proto))
+	record["component"] = "tshark"
+	if ts, ok := tsharkTime(packet, layers); ok {
+		// Packets cross hosts; UTC with explicit offset keeps them
+		// comparable regardless of the local time zone.
+		record["timestamp"] = ts.UTC().Format(timestampFormat)
+	} else {
+		record["timestamp"] = "NONE"
+	}
+	if len(t.fields) > 0 {
+		extra := make(map[string]interface{})
+		for _, name := range t.fields {
+			if val, ok := tsharkField(layers, name); ok {
+				extra[name] = val
+			}
+		}
+		record["tshark"] = extra
+	}
+	return record
+}
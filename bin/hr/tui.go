@@ -0,0 +1,677 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	escAltScreen  = "\033[?1049h"
+	escMainScreen = "\033[?1049l"
+	escHideCursor = "\033[?25l"
+	escShowCursor = "\033[?25h"
+	escHome       = "\033[H"
+	escClear      = "\033[2J"
+	escReverse    = "\033[7m"
+)
+
+const (
+	keyUp = iota + 0x110000
+	keyDown
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyEscape
+)
+
+// tui is a terminal user interface which keeps all records in memory.
+// Priority, component, and search are applied live on top of the
+// filters of the converter.
+type tui struct {
+	conv     *converter
+	tty      *os.File
+	oldState *unix.Termios
+	width    int
+	height   int
+
+	mu      sync.Mutex
+	records []map[string]interface{}
+	visible []int
+	cursor  int
+	top     int
+	follow  bool
+	detail  bool
+	filter  viewFilter
+	search  *regexp.Regexp
+	prompt  string
+	input   []rune
+	message string
+	eof     bool
+	// marked holds the indices of the records marked for export.
+	marked map[int]bool
+
+	dirty chan struct{}
+}
+
+func newTUI(conv *converter) (*tui, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	t := &tui{
+		conv:   conv,
+		tty:    tty,
+		follow: true,
+		filter: viewFilter{
+			prio:      conv.logLevel,
+			threshold: conv.threshold,
+		},
+		marked: make(map[int]bool),
+		dirty:  make(chan struct{}, 1),
+	}
+	if err := t.updateSize(); err != nil {
+		tty.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// resize adapts to a new size of the terminal. The rows are rendered
+// on every draw, so only the scroll position and the screen contents
+// need to be reset.
+func (t *tui) resize() {
+	t.mu.Lock()
+	if err := t.updateSize(); err != nil {
+		t.message = err.Error()
+	}
+	if t.cursor >= t.top+t.listHeight() {
+		t.top = t.cursor - t.listHeight() + 1
+	}
+	t.mu.Unlock()
+	fmt.Print(escClear)
+}
+
+func (t *tui) updateSize() error {
+	ws, err := unix.IoctlGetWinsize(int(t.tty.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return err
+	}
+	t.width, t.height = int(ws.Col), int(ws.Row)
+	return nil
+}
+
+func (t *tui) setup() error {
+	fd := int(t.tty.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	old := *termios
+	t.oldState = &old
+
+	// See cfmakeraw(3); output processing is kept for \n.
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		return err
+	}
+	fmt.Print(escAltScreen + escHideCursor)
+	return nil
+}
+
+func (t *tui) restore() {
+	fmt.Print(escShowCursor + escMainScreen)
+	if t.oldState != nil {
+		unix.IoctlSetTermios(int(t.tty.Fd()), unix.TCSETS, t.oldState)
+	}
+	t.tty.Close()
+}
+
+func (t *tui) markDirty() {
+	select {
+	case t.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// viewFilter holds the priority and the components which are changed
+// at runtime in the TUI. It is kept apart from the terminal such that
+// it can be tested on its own.
+type viewFilter struct {
+	prio       penlog.Prio
+	components []string
+	// threshold returns the priority level of a component, which
+	// differs from prio with --prio-override.
+	threshold func(comp string, prio penlog.Prio) penlog.Prio
+}
+
+func (f *viewFilter) isVisible(data map[string]interface{}) bool {
+	if p, ok := fieldPrio(data); ok {
+		comp, _ := fieldString(data, "component")
+		if p > f.threshold(comp, f.prio) {
+			return false
+		}
+	}
+	if len(f.components) > 0 {
+		comp, _ := fieldString(data, "component")
+		comp = strings.ToLower(comp)
+		found := false
+		for _, pattern := range f.components {
+			if ok, _ := path.Match(pattern, comp); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// setComponents parses the comma separated globs of the component
+// prompt. An empty input shows all components.
+func (f *viewFilter) setComponents(input string) error {
+	var components []string
+	for _, pattern := range removeEmpy(strings.Split(strings.ToLower(input), ",")) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+		components = append(components, pattern)
+	}
+	f.components = components
+	return nil
+}
+
+// handleKey changes the priority level for the keys + and - and the
+// digits 0 to 8. It returns true if the level has changed.
+func (f *viewFilter) handleKey(key rune) bool {
+	prio := f.prio
+	switch {
+	case key == '+' && prio < penlog.PrioTrace:
+		prio++
+	case key == '-' && prio > penlog.PrioEmergency:
+		prio--
+	case key >= '0' && key <= '8':
+		prio = penlog.Prio(key - '0')
+	}
+	if prio == f.prio {
+		return false
+	}
+	f.prio = prio
+	return true
+}
+
+// apply returns the indices of the visible records and the position of
+// the last visible record up to the index selected, or 0.
+func (f *viewFilter) apply(records []map[string]interface{}, selected int) ([]int, int) {
+	var (
+		visible []int
+		cursor  = 0
+	)
+	for i, data := range records {
+		if !f.isVisible(data) {
+			continue
+		}
+		if i <= selected {
+			cursor = len(visible)
+		}
+		visible = append(visible, i)
+	}
+	return visible, cursor
+}
+
+// add is called by the converter for every record which passed
+// its filters.
+func (t *tui) add(data map[string]interface{}) {
+	t.mu.Lock()
+	t.records = append(t.records, data)
+	if t.filter.isVisible(data) {
+		t.visible = append(t.visible, len(t.records)-1)
+		if t.follow {
+			t.cursor = len(t.visible) - 1
+		}
+	}
+	t.mu.Unlock()
+	t.markDirty()
+}
+
+// done is called when the input is exhausted.
+func (t *tui) done() {
+	t.mu.Lock()
+	t.eof = true
+	t.mu.Unlock()
+	t.markDirty()
+}
+
+// refilter recomputes the visible records and tries to keep the
+// selected record.
+func (t *tui) refilter() {
+	selected := -1
+	if t.cursor < len(t.visible) {
+		selected = t.visible[t.cursor]
+	}
+	t.visible, t.cursor = t.filter.apply(t.records, selected)
+	if t.follow {
+		t.cursor = len(t.visible) - 1
+	}
+	if t.cursor < 0 {
+		t.cursor = 0
+	}
+}
+
+func (t *tui) searchText(data map[string]interface{}) string {
+	comp, _ := fieldString(data, "component")
+	msgType, _ := fieldString(data, "type")
+	payload, _ := fieldString(data, "data")
+	return comp + " " + msgType + " " + payload
+}
+
+func (t *tui) findNext(dir int) {
+	if t.search == nil || len(t.visible) == 0 {
+		return
+	}
+	for i := 1; i <= len(t.visible); i++ {
+		pos := (t.cursor + dir*i + len(t.visible)) % len(t.visible)
+		if t.search.MatchString(t.searchText(t.records[t.visible[pos]])) {
+			t.cursor = pos
+			t.follow = false
+			return
+		}
+	}
+	t.message = "pattern not found: " + strings.TrimPrefix(t.search.String(), "(?i)")
+}
+
+func (t *tui) move(delta int) {
+	t.cursor += delta
+	if t.cursor >= len(t.visible) {
+		t.cursor = len(t.visible) - 1
+	}
+	if t.cursor < 0 {
+		t.cursor = 0
+	}
+	t.follow = t.cursor == len(t.visible)-1 && delta > 0
+}
+
+// listHeight is the number of rows for records; the header and the
+// status line take one row each.
+func (t *tui) listHeight() int {
+	h := t.height - 2
+	if t.detail {
+		h /= 2
+	}
+	if h < 1 {
+		h = 1
+	}
+	return h
+}
+
+func (t *tui) applyPrompt() {
+	input := strings.TrimSpace(string(t.input))
+	switch t.prompt {
+	case "/":
+		if input == "" {
+			t.search = nil
+			return
+		}
+		re, err := regexp.Compile("(?i)" + input)
+		if err != nil {
+			t.message = err.Error()
+			return
+		}
+		t.search = re
+		t.findNext(1)
+	case "component":
+		if err := t.filter.setComponents(input); err != nil {
+			t.message = err.Error()
+			return
+		}
+		t.refilter()
+	}
+}
+
+// handleKey returns false if the user wants to quit.
+func (t *tui) handleKey(key rune) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.message = ""
+	if t.prompt != "" {
+		switch key {
+		case '\r', '\n':
+			t.applyPrompt()
+			t.prompt = ""
+		case keyEscape, 0x03:
+			t.prompt = ""
+		case 0x7f, 0x08:
+			if len(t.input) > 0 {
+				t.input = t.input[:len(t.input)-1]
+			}
+		default:
+			if key >= 0x20 && key < keyUp {
+				t.input = append(t.input, key)
+			}
+		}
+		return true
+	}
+
+	switch key {
+	case 'q', 0x03:
+		return false
+	case 'j', keyDown:
+		t.move(1)
+	case 'k', keyUp:
+		t.move(-1)
+	case ' ', keyPageDown:
+		t.move(t.listHeight())
+	case 'b', keyPageUp:
+		t.move(-t.listHeight())
+	case 'g', keyHome:
+		t.cursor = 0
+		t.follow = false
+	case 'G', keyEnd:
+		t.cursor = len(t.visible) - 1
+		t.follow = true
+	case 'f':
+		t.follow = !t.follow
+		if t.follow {
+			t.cursor = len(t.visible) - 1
+		}
+	case '\r', '\n':
+		t.detail = !t.detail
+	case '/':
+		t.prompt = "/"
+		t.input = t.input[:0]
+	case 'n':
+		t.findNext(1)
+	case 'N':
+		t.findNext(-1)
+	case 'm':
+		t.toggleMark()
+	case '\'':
+		t.nextMark()
+	case 'c':
+		t.prompt = "component"
+		t.input = []rune(strings.Join(t.filter.components, ","))
+	default:
+		if t.filter.handleKey(key) {
+			t.refilter()
+		}
+	}
+	if t.cursor < 0 {
+		t.cursor = 0
+	}
+	return true
+}
+
+// truncateANSI cuts s to width visible characters; escape
+// sequences are copied but not counted.
+func truncateANSI(s string, width int) string {
+	var (
+		b       strings.Builder
+		n       = 0
+		escaped = false
+	)
+	for _, r := range s {
+		if escaped {
+			b.WriteRune(r)
+			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+				escaped = false
+			}
+			continue
+		}
+		if r == '\033' {
+			escaped = true
+			b.WriteRune(r)
+			continue
+		}
+		if n >= width {
+			if width > 0 {
+				b.WriteString(colorReset)
+			}
+			break
+		}
+		if r == '\t' {
+			r = ' '
+		}
+		b.WriteRune(r)
+		n++
+	}
+	return b.String()
+}
+
+func (t *tui) renderRow(data map[string]interface{}) string {
+	line, err := t.conv.render(data)
+	if err != nil {
+		line = err.Error()
+	}
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	return line
+}
+
+func (t *tui) renderDetail(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var lines []string
+	for _, k := range keys {
+		val := formatDiffValue(data[k])
+		for i, line := range strings.Split(strings.TrimRight(val, "\n"), "\n") {
+			if i == 0 {
+				lines = append(lines, fmt.Sprintf("%-12s %s", k+":", line))
+			} else {
+				lines = append(lines, "             "+line)
+			}
+		}
+	}
+	return lines
+}
+
+// headerLine summarizes what is shown: the state of the view, the
+// runtime controls, and the filters given on the command line.
+func (t *tui) headerLine() string {
+	state := "PAUSED"
+	if t.follow {
+		state = "FOLLOW"
+	}
+	if t.eof {
+		state += " (EOF)"
+	}
+	parts := []string{state, fmt.Sprintf("prio<=%s", prioName(t.filter.prio))}
+	if len(t.filter.components) > 0 {
+		parts = append(parts, "comp="+strings.Join(t.filter.components, ","))
+	}
+	if t.search != nil {
+		parts = append(parts, "search="+strings.TrimPrefix(t.search.String(), "(?i)"))
+	}
+	if filters := t.conv.filterSources(); len(filters) > 0 {
+		parts = append(parts, "filters: "+strings.Join(filters, " "))
+	} else {
+		parts = append(parts, "no filters")
+	}
+	return strings.Join(parts, " | ")
+}
+
+func (t *tui) statusLine() string {
+	if t.prompt != "" {
+		return t.prompt + ": " + string(t.input)
+	}
+	if t.message != "" {
+		return t.message
+	}
+	pos := 0
+	if len(t.visible) > 0 {
+		pos = t.cursor + 1
+	}
+	return fmt.Sprintf("%d/%d (%d total, %d marked) | q:quit f:follow /:search c:component 0-8:prio enter:details m:mark", pos, len(t.visible), len(t.records), len(t.marked))
+}
+
+// fitLine pads or cuts s to the width of the terminal, such that
+// reverse video spans the whole row.
+func (t *tui) fitLine(s string) string {
+	n := utf8.RuneCountInString(s)
+	if n > t.width {
+		return string([]rune(s)[:t.width])
+	}
+	return s + strings.Repeat(" ", t.width-n)
+}
+
+func (t *tui) draw() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var (
+		b          strings.Builder
+		listHeight = t.listHeight()
+		rows       = 0
+	)
+	if t.cursor < t.top {
+		t.top = t.cursor
+	}
+	if t.cursor >= t.top+listHeight {
+		t.top = t.cursor - listHeight + 1
+	}
+	b.WriteString(escHome)
+	b.WriteString(clearLine + escReverse + t.fitLine(t.headerLine()) + colorReset + "\r\n")
+	for i := t.top; i < len(t.visible) && rows < listHeight; i++ {
+		mark := " "
+		if t.marked[t.visible[i]] {
+			mark = "*"
+		}
+		gutter := " " + mark
+		if i == t.cursor {
+			gutter = escReverse + ">" + colorReset + mark
+		}
+		row := t.renderRow(t.records[t.visible[i]])
+		b.WriteString(clearLine + gutter + truncateANSI(row, t.width-2) + "\r\n")
+		rows++
+	}
+	for ; rows < listHeight; rows++ {
+		b.WriteString(clearLine + "\r\n")
+	}
+	if t.detail {
+		var lines []string
+		if t.cursor < len(t.visible) {
+			lines = t.renderDetail(t.records[t.visible[t.cursor]])
+		}
+		b.WriteString(clearLine + strings.Repeat("─", t.width) + "\r\n")
+		rows++
+		for i := 0; rows < t.height-2; i++ {
+			line := ""
+			if i < len(lines) {
+				line = truncateANSI(lines[i], t.width)
+			}
+			b.WriteString(clearLine + line + "\r\n")
+			rows++
+		}
+	}
+	b.WriteString(clearLine + escReverse + t.fitLine(t.statusLine()) + colorReset)
+	fmt.Print(b.String())
+}
+
+func (t *tui) readKeys(keys chan<- rune) {
+	buf := make([]byte, 64)
+	for {
+		n, err := t.tty.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		in := buf[:n]
+		for len(in) > 0 {
+			if in[0] == '\033' {
+				seqs := map[string]rune{
+					"\033[A": keyUp, "\033OA": keyUp,
+					"\033[B": keyDown, "\033OB": keyDown,
+					"\033[5~": keyPageUp, "\033[6~": keyPageDown,
+					"\033[H": keyHome, "\033[1~": keyHome,
+					"\033[F": keyEnd, "\033[4~": keyEnd,
+				}
+				matched := false
+				for seq, key := range seqs {
+					if strings.HasPrefix(string(in), seq) {
+						keys <- key
+						in = in[len(seq):]
+						matched = true
+						break
+					}
+				}
+				if !matched {
+					// Unknown sequences are dropped entirely.
+					if len(in) == 1 {
+						keys <- keyEscape
+					}
+					in = in[:0]
+				}
+				continue
+			}
+			r, size := utf8.DecodeRune(in)
+			keys <- r
+			in = in[size:]
+		}
+	}
+}
+
+// run blocks until the user quits.
+func (t *tui) run() error {
+	if err := t.setup(); err != nil {
+		return err
+	}
+	defer t.restore()
+
+	keys := make(chan rune)
+	go t.readKeys(keys)
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, unix.SIGWINCH)
+	defer signal.Stop(winch)
+
+	// Redraws are limited to avoid burning CPU on fast streams.
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	t.draw()
+	pending := false
+	for {
+		select {
+		case key, ok := <-keys:
+			if !ok || !t.handleKey(key) {
+				return nil
+			}
+			t.draw()
+		case <-winch:
+			t.resize()
+			t.draw()
+		case <-t.dirty:
+			pending = true
+		case <-ticker.C:
+			if pending {
+				t.draw()
+				pending = false
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+var tuiRecords = []string{
+	`{"component": "scanner", "type": "message", "priority": 6, "data": "a"}`,
+	`{"component": "scanner", "type": "message", "priority": 7, "data": "b"}`,
+	`{"component": "DoIP", "type": "read", "priority": 7, "data": "c"}`,
+	`{"component": "doip", "type": "error", "priority": 3, "data": "d"}`,
+	`{"component": "uart", "type": "write", "priority": 8, "data": "e"}`,
+	`{"component": "uart", "type": "message", "data": "f"}`,
+	`{"component": "moncay", "type": "message", "priority": 0, "data": "g"}`,
+}
+
+func loadTUIRecords(t *testing.T) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range tuiRecords {
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &data); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, data)
+	}
+	return records
+}
+
+func newTestViewFilter(t *testing.T, prio penlog.Prio, overrides ...string) *viewFilter {
+	conv := &converter{}
+	for _, spec := range overrides {
+		if err := conv.addPrioOverride(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &viewFilter{prio: prio, threshold: conv.threshold}
+}
+
+// visibleData returns the payloads of the visible records.
+func visibleData(records []map[string]interface{}, visible []int) string {
+	var b strings.Builder
+	for _, i := range visible {
+		payload, _ := fieldString(records[i], "data")
+		b.WriteString(payload)
+	}
+	return b.String()
+}
+
+func TestViewFilterVisible(t *testing.T) {
+	tests := []struct {
+		prio       penlog.Prio
+		overrides  []string
+		components string
+		want       string
+	}{
+		{penlog.PrioTrace, nil, "", "abcdefg"},
+		{penlog.PrioDebug, nil, "", "abcdfg"},
+		{penlog.PrioInfo, nil, "", "adfg"},
+		// Records without a priority are always shown.
+		{penlog.PrioEmergency, nil, "", "fg"},
+		{penlog.PrioInfo, []string{"doip=debug"}, "", "acdfg"},
+		{penlog.PrioInfo, []string{"d*=trace", "uart=trace"}, "", "acdefg"},
+		// The first matching override wins.
+		{penlog.PrioInfo, []string{"doip=error", "doip=debug"}, "", "adfg"},
+		{penlog.PrioError, []string{"scanner=info"}, "", "adfg"},
+		{penlog.PrioTrace, nil, "doip", "cd"},
+		{penlog.PrioTrace, nil, "Scan*,u?rt", "abef"},
+		{penlog.PrioInfo, nil, "scanner,doip", "ad"},
+		{penlog.PrioTrace, nil, "nothing", ""},
+	}
+	records := loadTUIRecords(t)
+	for _, tt := range tests {
+		f := newTestViewFilter(t, tt.prio, tt.overrides...)
+		if err := f.setComponents(tt.components); err != nil {
+			t.Fatal(err)
+		}
+		visible, _ := f.apply(records, -1)
+		if got := visibleData(records, visible); got != tt.want {
+			t.Errorf("prio %d, overrides %v, components %q: got %q, want %q", tt.prio, tt.overrides, tt.components, got, tt.want)
+		}
+	}
+}
+
+func TestViewFilterCursor(t *testing.T) {
+	tests := []struct {
+		prio     penlog.Prio
+		selected int
+		cursor   int
+	}{
+		// The selected record stays selected.
+		{penlog.PrioTrace, 4, 4},
+		{penlog.PrioDebug, 3, 3},
+		// Otherwise, the closest visible record before it.
+		{penlog.PrioInfo, 2, 0},
+		{penlog.PrioInfo, 4, 1},
+		{penlog.PrioInfo, 6, 3},
+		// Nothing was selected.
+		{penlog.PrioInfo, -1, 0},
+	}
+	records := loadTUIRecords(t)
+	for _, tt := range tests {
+		f := newTestViewFilter(t, tt.prio)
+		if _, cursor := f.apply(records, tt.selected); cursor != tt.cursor {
+			t.Errorf("prio %d, selected %d: cursor %d, want %d", tt.prio, tt.selected, cursor, tt.cursor)
+		}
+	}
+}
+
+func TestViewFilterHandleKey(t *testing.T) {
+	tests := []struct {
+		prio    penlog.Prio
+		key     rune
+		want    penlog.Prio
+		changed bool
+	}{
+		{penlog.PrioInfo, '+', penlog.PrioDebug, true},
+		{penlog.PrioInfo, '-', penlog.PrioNotice, true},
+		{penlog.PrioTrace, '+', penlog.PrioTrace, false},
+		{penlog.PrioEmergency, '-', penlog.PrioEmergency, false},
+		{penlog.PrioInfo, '0', penlog.PrioEmergency, true},
+		{penlog.PrioInfo, '8', penlog.PrioTrace, true},
+		{penlog.PrioInfo, '6', penlog.PrioInfo, false},
+		{penlog.PrioInfo, '9', penlog.PrioInfo, false},
+		{penlog.PrioInfo, 'q', penlog.PrioInfo, false},
+	}
+	for _, tt := range tests {
+		f := newTestViewFilter(t, tt.prio)
+		changed := f.handleKey(tt.key)
+		if f.prio != tt.want || changed != tt.changed {
+			t.Errorf("prio %d, key %q: got %d, %t, want %d, %t", tt.prio, tt.key, f.prio, changed, tt.want, tt.changed)
+		}
+	}
+}
+
+func TestViewFilterSetComponents(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"", nil},
+		{"DoIP", []string{"doip"}},
+		{",scanner,,u*,", []string{"scanner", "u*"}},
+	}
+	for _, tt := range tests {
+		f := newTestViewFilter(t, penlog.PrioTrace)
+		if err := f.setComponents(tt.input); err != nil {
+			t.Errorf("setComponents(%q): %s", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(f.components, tt.want) {
+			t.Errorf("setComponents(%q): got %q, want %q", tt.input, f.components, tt.want)
+		}
+	}
+
+	// Invalid globs keep the previous components.
+	f := newTestViewFilter(t, penlog.PrioTrace)
+	f.setComponents("scanner")
+	if err := f.setComponents("doip,[a"); err == nil {
+		t.Errorf("setComponents(%q): no error", "doip,[a")
+	}
+	if want := []string{"scanner"}; !reflect.DeepEqual(f.components, want) {
+		t.Errorf("setComponents(%q): got %q, want %q", "doip,[a", f.components, want)
+	}
+}
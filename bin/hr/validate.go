@@ -0,0 +1,298 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Fraunhofer-AISEC/penlog/filter"
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+type violation struct {
+	field string
+	msg   string
+}
+
+func (v *violation) Error() string {
+	return fmt.Sprintf("%s: %s", v.field, v.msg)
+}
+
+// typeName returns the JSON type of a decoded value.
+func typeName(raw interface{}) string {
+	switch v := raw.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case stdjson.Number, float64, int:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case rawJSON:
+		var decoded interface{}
+		if json.Unmarshal(v, &decoded) == nil {
+			return typeName(decoded)
+		}
+	}
+	return fmt.Sprintf("%T", raw)
+}
+
+func checkString(data map[string]interface{}, field string, required bool) (string, *violation) {
+	raw, ok := data[field]
+	if !ok {
+		if required {
+			return "", &violation{field, "required field is missing"}
+		}
+		return "", nil
+	}
+	s, ok := raw.(string)
+	if !ok {
+		return "", &violation{field, fmt.Sprintf("expected string, got %s", typeName(raw))}
+	}
+	return s, nil
+}
+
+// validateRecord checks a decoded record against the field
+// specification in penlog(7).
+func validateRecord(data map[string]interface{}) []*violation {
+	var res []*violation
+
+	for _, field := range []string{"component", "host", "id", "rule", "run_id", "stacktrace"} {
+		if _, v := checkString(data, field, false); v != nil {
+			res = append(res, v)
+		}
+	}
+	if _, v := checkString(data, "data", true); v != nil {
+		res = append(res, v)
+	}
+	if _, v := checkString(data, "type", true); v != nil {
+		res = append(res, v)
+	}
+	if ts, v := checkString(data, "timestamp", true); v != nil {
+		res = append(res, v)
+	} else if _, err := filter.ParseTimestamp(ts); err != nil {
+		res = append(res, &violation{"timestamp", fmt.Sprintf("invalid ISO8601 timestamp '%s'", ts)})
+	}
+	if line, v := checkString(data, "line", false); v != nil {
+		res = append(res, v)
+	} else if line != "" {
+		i := strings.LastIndex(line, ":")
+		if i <= 0 {
+			res = append(res, &violation{"line", fmt.Sprintf("expected 'filename:number', got '%s'", line)})
+		} else if _, err := strconv.ParseUint(line[i+1:], 10, 64); err != nil {
+			res = append(res, &violation{"line", fmt.Sprintf("invalid line number in '%s'", line)})
+		}
+	}
+	if raw, ok := data["priority"]; ok {
+		if n, ok := numberOf(raw); !ok {
+			res = append(res, &violation{"priority", fmt.Sprintf("expected integer, got %s", typeName(raw))})
+		} else if p, err := n.Int64(); err != nil {
+			res = append(res, &violation{"priority", fmt.Sprintf("expected integer, got %s", n)})
+		} else if p < int64(penlog.PrioEmergency) || p > int64(penlog.PrioTrace) {
+			res = append(res, &violation{"priority", fmt.Sprintf("out of range: %d", p)})
+		}
+	}
+	if raw, ok := data["tags"]; ok {
+		if tags, ok := raw.([]interface{}); !ok {
+			res = append(res, &violation{"tags", fmt.Sprintf("expected list of strings, got %s", typeName(raw))})
+		} else {
+			for _, tag := range tags {
+				if _, ok := tag.(string); !ok {
+					res = append(res, &violation{"tags", fmt.Sprintf("expected string tag, got %s", typeName(tag))})
+					break
+				}
+			}
+		}
+	}
+	if raw, ok := data["error"]; ok {
+		res = append(res, checkError("error", raw)...)
+	}
+	if raw, ok := data["target"]; ok {
+		if target, ok := raw.(map[string]interface{}); !ok {
+			res = append(res, &violation{"target", fmt.Sprintf("expected object, got %s", typeName(raw))})
+		} else {
+			for _, key := range append([]string{"ecu"}, filter.TargetKeys...) {
+				if _, v := checkString(target, key, false); v != nil {
+					res = append(res, &violation{"target." + v.field, v.msg})
+				}
+			}
+		}
+	}
+	if msgType, _ := data["type"].(string); msgType == "check" {
+		if _, v := checkString(data, "check", true); v != nil {
+			res = append(res, v)
+		}
+		if raw, ok := data["passed"]; !ok {
+			res = append(res, &violation{"passed", "required field is missing"})
+		} else if _, ok := raw.(bool); !ok {
+			res = append(res, &violation{"passed", fmt.Sprintf("expected bool, got %s", typeName(raw))})
+		}
+	}
+	if msgType, _ := data["type"].(string); msgType == "capabilities" {
+		res = append(res, checkCapabilities(data)...)
+	}
+	if raw, ok := data["via"]; ok {
+		if via, ok := raw.([]interface{}); !ok {
+			res = append(res, &violation{"via", fmt.Sprintf("expected list of objects, got %s", typeName(raw))})
+		} else {
+			for _, hop := range via {
+				if _, ok := hop.(map[string]interface{}); !ok {
+					res = append(res, &violation{"via", fmt.Sprintf("expected object, got %s", typeName(hop))})
+					break
+				}
+			}
+		}
+	}
+	return res
+}
+
+// checkError checks the structured error at field, including its causes.
+func checkError(field string, raw interface{}) []*violation {
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return []*violation{{field, fmt.Sprintf("expected object, got %s", typeName(raw))}}
+	}
+	var res []*violation
+	if _, v := checkString(obj, "message", true); v != nil {
+		res = append(res, &violation{field + "." + v.field, v.msg})
+	}
+	for _, key := range []string{"type", "stacktrace"} {
+		if _, v := checkString(obj, key, false); v != nil {
+			res = append(res, &violation{field + "." + v.field, v.msg})
+		}
+	}
+	if rawCauses, ok := obj["causes"]; ok {
+		causes, ok := rawCauses.([]interface{})
+		if !ok {
+			return append(res, &violation{field + ".causes", fmt.Sprintf("expected list of objects, got %s", typeName(rawCauses))})
+		}
+		for i, cause := range causes {
+			res = append(res, checkError(fmt.Sprintf("%s.causes[%d]", field, i), cause)...)
+		}
+	}
+	return res
+}
+
+type validator struct {
+	w          io.Writer
+	records    int
+	violations map[string]int
+}
+
+func newValidator(w io.Writer) *validator {
+	return &validator{
+		w:          w,
+		violations: make(map[string]int),
+	}
+}
+
+func (v *validator) validate(r io.Reader, name string) {
+	var (
+		reader = bufio.NewReader(r)
+		lineNo = 0
+	)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			lineNo++
+		}
+		// Blank lines are not records, e.g. a trailing empty line.
+		if len(bytes.TrimSpace(line)) > 0 {
+			v.records++
+			var data map[string]interface{}
+			if err := json.Unmarshal(line, &data); err != nil {
+				v.violations["json"]++
+				fmt.Fprintf(v.w, "%s:%d: json: not a valid JSON object\n", name, lineNo)
+			} else {
+				for _, violation := range validateRecord(data) {
+					v.violations[violation.field]++
+					fmt.Fprintf(v.w, "%s:%d: %s\n", name, lineNo, violation)
+				}
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				fmt.Fprintf(v.w, "%s:%d: %s\n", name, lineNo, err)
+				v.violations["io"]++
+			}
+			return
+		}
+	}
+}
+
+func (v *validator) total() int {
+	n := 0
+	for _, count := range v.violations {
+		n += count
+	}
+	return n
+}
+
+func (v *validator) summary() {
+	fields := make([]string, 0, len(v.violations))
+	for field := range v.violations {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	fmt.Fprintf(v.w, "%d records, %d violations\n", v.records, v.total())
+	for _, field := range fields {
+		fmt.Fprintf(v.w, "  %-12s %d\n", field, v.violations[field])
+	}
+}
+
+// checkCapabilities validates the object announced by a record of type
+// "capabilities".
+func checkCapabilities(data map[string]interface{}) []*violation {
+	raw, ok := data["capabilities"]
+	if !ok {
+		return []*violation{{"capabilities", "required field is missing"}}
+	}
+	caps, ok := raw.(map[string]interface{})
+	if !ok {
+		return []*violation{{"capabilities", fmt.Sprintf("expected object, got %s", typeName(raw))}}
+	}
+	var res []*violation
+	if raw, ok := caps["spec"]; ok {
+		if n, ok := numberOf(raw); !ok {
+			res = append(res, &violation{"capabilities.spec", fmt.Sprintf("expected integer, got %s", typeName(raw))})
+		} else if _, err := n.Int64(); err != nil {
+			res = append(res, &violation{"capabilities.spec", fmt.Sprintf("expected integer, got %s", n)})
+		}
+	}
+	if raw, ok := caps["extensions"]; ok {
+		if _, ok := fieldStrings(caps, "extensions"); !ok {
+			res = append(res, &violation{"capabilities.extensions", fmt.Sprintf("expected list of strings, got %s", typeName(raw))})
+		}
+	}
+	if raw, ok := caps["binary"]; ok {
+		if binary, ok := raw.(map[string]interface{}); !ok {
+			res = append(res, &violation{"capabilities.binary", fmt.Sprintf("expected object, got %s", typeName(raw))})
+		} else {
+			for field, steps := range binary {
+				if _, ok := steps.(string); !ok {
+					res = append(res, &violation{"capabilities.binary." + field, fmt.Sprintf("expected string, got %s", typeName(steps))})
+				}
+			}
+		}
+	}
+	if raw, ok := caps["hmac"]; ok {
+		if _, ok := raw.(bool); !ok {
+			res = append(res, &violation{"capabilities.hmac", fmt.Sprintf("expected bool, got %s", typeName(raw))})
+		}
+	}
+	return res
+}
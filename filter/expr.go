@@ -0,0 +1,258 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+// Operators are ordered such that two character operators are tried
+// before their one character prefixes.
+var operators = []string{"!=", "!~", "<=", ">=", "=", "~", "<", ">"}
+
+var aliases = map[string]string{
+	"comp": "component",
+	"prio": "priority",
+}
+
+func quoted(tokens ...string) []string {
+	res := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		res = append(res, "'"+token+"'")
+	}
+	return res
+}
+
+// Condition compares a field of a record with a value. The fields
+// "since" and "until" compare the timestamp instead; "target" compares
+// the label of the target, see Target. Fields of nested objects are
+// addressed with dots, e.g. "target.vin".
+type Condition struct {
+	Field string
+	Op    string
+	Value string
+
+	prio penlog.Prio
+	re   *regexp.Regexp
+	time time.Time
+	glob bool
+}
+
+// Expression is a list of conditions which all have to match,
+// e.g. "comp=scanner;prio<=warning;since=2020-04-23T15:00;data~^finished".
+type Expression struct {
+	Conditions []*Condition
+}
+
+// ParseCondition parses a single condition. It is not split at ';'.
+func ParseCondition(spec string) (*Condition, error) {
+	return parseCondition(spec, 0, len(spec))
+}
+
+// ParseExpression parses conditions separated by ';'.
+func ParseExpression(spec string) (*Expression, error) {
+	return parseExpression(spec, 0, len(spec))
+}
+
+// parseExpression parses spec[start:end]; offsets in errors refer to spec.
+func parseExpression(spec string, start, end int) (*Expression, error) {
+	var expr Expression
+	for offset := start; offset <= end; {
+		n := strings.IndexByte(spec[offset:end], ';')
+		if n < 0 {
+			n = end - offset
+		}
+		if n > 0 {
+			cond, err := parseCondition(spec, offset, offset+n)
+			if err != nil {
+				return nil, err
+			}
+			expr.Conditions = append(expr.Conditions, cond)
+		}
+		offset += n + 1
+	}
+	if len(expr.Conditions) == 0 {
+		return nil, syntaxError(spec, start, []string{"a condition"}, "empty expression")
+	}
+	return &expr, nil
+}
+
+// parseCondition parses spec[start:end]; offsets in errors refer to spec.
+func parseCondition(spec string, start, end int) (*Condition, error) {
+	var (
+		part = spec[start:end]
+		i    = strings.IndexAny(part, "!=~<>")
+	)
+	if i < 0 {
+		return nil, syntaxError(spec, end, quoted(operators...), "missing operator")
+	}
+	field := strings.TrimSpace(part[:i])
+	if field == "" {
+		return nil, syntaxError(spec, start, []string{"a field name"}, "missing field")
+	}
+	cond := Condition{Field: strings.ToLower(field)}
+	if alias, ok := aliases[cond.Field]; ok {
+		cond.Field = alias
+	}
+
+	var (
+		rest     = part[i:]
+		opOffset = start + i
+	)
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			cond.Op = op
+			break
+		}
+	}
+	if cond.Op == "" {
+		return nil, syntaxError(spec, opOffset, quoted(operators...), "unknown operator")
+	}
+	var (
+		raw         = rest[len(cond.Op):]
+		valueOffset = opOffset + len(cond.Op) + len(raw) - len(strings.TrimLeft(raw, " \t"))
+	)
+	cond.Value = strings.TrimSpace(raw)
+
+	switch cond.Op {
+	case "~", "!~":
+		re, err := regexp.Compile(cond.Value)
+		if err != nil {
+			return nil, syntaxError(spec, valueOffset, nil, "invalid regular expression: %s", err)
+		}
+		cond.re = re
+	case "<", "<=", ">", ">=":
+		if cond.Field != "priority" {
+			return nil, syntaxError(spec, opOffset, quoted("=", "!=", "~", "!~"), "'%s' only works with priorities", cond.Op)
+		}
+	}
+	switch cond.Field {
+	case "priority":
+		if cond.re == nil {
+			prio, err := ParsePrio(cond.Value)
+			if err != nil {
+				return nil, syntaxError(spec, valueOffset, []string{"a priority name", "an integer"}, "unknown priority '%s'", cond.Value)
+			}
+			cond.prio = prio
+		}
+	case "since", "until":
+		if cond.Op != "=" {
+			return nil, syntaxError(spec, opOffset, quoted("="), "'%s' only supports '='", cond.Field)
+		}
+		t, err := ParseTimestamp(cond.Value)
+		if err != nil {
+			return nil, syntaxError(spec, valueOffset, []string{"an ISO8601 timestamp"}, "invalid timestamp '%s'", cond.Value)
+		}
+		cond.time = t
+	default:
+		if cond.Op == "=" || cond.Op == "!=" {
+			if strings.ContainsAny(cond.Value, "*?[") {
+				if _, err := path.Match(cond.Value, ""); err != nil {
+					return nil, syntaxError(spec, valueOffset, nil, "invalid pattern: %s", err)
+				}
+				cond.glob = true
+				cond.Value = strings.ToLower(cond.Value)
+			}
+		}
+	}
+	return &cond, nil
+}
+
+// Match reports whether the condition holds for the record data.
+func (c *Condition) Match(data map[string]interface{}) bool {
+	if c.Field == "since" || c.Field == "until" {
+		ts, ok := stringField(data, "timestamp")
+		if !ok {
+			return false
+		}
+		t, err := ParseTimestamp(ts)
+		if err != nil {
+			return false
+		}
+		if c.Field == "since" {
+			return !t.Before(c.time)
+		}
+		return !t.After(c.time)
+	}
+	if c.Field == "priority" && c.re == nil {
+		raw, ok := data["priority"]
+		if !ok {
+			return false
+		}
+		var prio penlog.Prio
+		switch p := raw.(type) {
+		case float64:
+			prio = penlog.Prio(p)
+		case json.Number:
+			n, err := p.Int64()
+			if err != nil {
+				return false
+			}
+			prio = penlog.Prio(n)
+		default:
+			return false
+		}
+		switch c.Op {
+		case "=":
+			return prio == c.prio
+		case "!=":
+			return prio != c.prio
+		case "<":
+			return prio < c.prio
+		case "<=":
+			return prio <= c.prio
+		case ">":
+			return prio > c.prio
+		case ">=":
+			return prio >= c.prio
+		}
+		return false
+	}
+
+	var val string
+	if c.Field == "target" {
+		val = Target(data)
+	} else if raw, ok := lookup(data, c.Field); ok {
+		if s, ok := raw.(string); ok {
+			val = s
+		} else {
+			val = fmt.Sprint(raw)
+		}
+	}
+	switch c.Op {
+	case "=":
+		return c.equals(val)
+	case "!=":
+		return !c.equals(val)
+	case "~":
+		return c.re.MatchString(val)
+	case "!~":
+		return !c.re.MatchString(val)
+	}
+	return false
+}
+
+func (c *Condition) equals(val string) bool {
+	if c.glob {
+		ok, _ := path.Match(c.Value, strings.ToLower(val))
+		return ok
+	}
+	return strings.EqualFold(val, c.Value)
+}
+
+// Match reports whether all conditions hold for the record data.
+func (e *Expression) Match(data map[string]interface{}) bool {
+	for _, cond := range e.Conditions {
+		if !cond.Match(data) {
+			return false
+		}
+	}
+	return true
+}
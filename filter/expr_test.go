@@ -0,0 +1,260 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package filter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+func TestParseExpression(t *testing.T) {
+	tests := []struct {
+		spec  string
+		conds int
+	}{
+		{"comp=scanner", 1},
+		{"comp=scanner;", 1},
+		{";comp=scanner;;type=msg", 2},
+		{"since=2020-04-23T15:00:00;until=2020-04-23T16:00", 2},
+		{"data~^a;type=msg", 2},
+	}
+	for _, tt := range tests {
+		expr, err := ParseExpression(tt.spec)
+		if err != nil {
+			t.Errorf("ParseExpression(%q): %s", tt.spec, err)
+			continue
+		}
+		if n := len(expr.Conditions); n != tt.conds {
+			t.Errorf("ParseExpression(%q): %d conditions, want %d", tt.spec, n, tt.conds)
+		}
+	}
+
+	for _, spec := range []string{"", ";", ";;"} {
+		_, err := ParseExpression(spec)
+		want := "invalid filter '" + spec + "' at position 1: empty expression, expected a condition"
+		if err == nil || err.Error() != want {
+			t.Errorf("ParseExpression(%q): %v, want %s", spec, err, want)
+		}
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		spec  string
+		field string
+		op    string
+		value string
+	}{
+		{"comp=scanner", "component", "=", "scanner"},
+		{"Prio<=warning", "priority", "<=", "warning"},
+		{"prio >= 3", "priority", ">=", "3"},
+		{"type!=msg", "type", "!=", "msg"},
+		{"data~^foo", "data", "~", "^foo"},
+		{"data!~bar$", "data", "!~", "bar$"},
+		{"host=", "host", "=", ""},
+		// Conditions are not split at ';'.
+		{"data~a;b", "data", "~", "a;b"},
+	}
+	for _, tt := range tests {
+		cond, err := ParseCondition(tt.spec)
+		if err != nil {
+			t.Errorf("ParseCondition(%q): %s", tt.spec, err)
+			continue
+		}
+		if cond.Field != tt.field || cond.Op != tt.op || cond.Value != tt.value {
+			t.Errorf("ParseCondition(%q) = %q %q %q, want %q %q %q",
+				tt.spec, cond.Field, cond.Op, cond.Value, tt.field, tt.op, tt.value)
+		}
+	}
+}
+
+func TestConditionMatch(t *testing.T) {
+	ts := time.Date(2020, 4, 23, 15, 30, 0, 0, time.Local)
+	record := map[string]interface{}{
+		"timestamp": ts.Format("2006-01-02T15:04:05.000000"),
+		"component": "scanner",
+		"type":      "msg",
+		"data":      "Scan finished",
+		"priority":  float64(penlog.PrioWarning),
+		"line":      float64(42),
+		"can_id":    json.Number("18446744073709551615"),
+		"target":    map[string]interface{}{"vin": "WVWZZZ1JZXW000001", "ecu": "0x7e0"},
+	}
+	tests := []struct {
+		spec  string
+		match bool
+	}{
+		{"comp=scanner", true},
+		{"comp=SCANNER", true},
+		{"comp!=scanner", false},
+		{"comp=scan*", true},
+		{"comp=scan?", false},
+		{"comp!=mon*", true},
+		{"data~finished$", true},
+		{"data~^finished", false},
+		{"data!~^finished", true},
+		{"prio=warning", true},
+		{"prio=4", true},
+		{"prio!=warning", false},
+		{"prio<warning", false},
+		{"prio<=warning", true},
+		{"prio>error", true},
+		{"prio>=notice", false},
+		{"prio~^4$", true},
+		{"since=2020-04-23T15:00", true},
+		{"since=2020-04-23T16:00", false},
+		{"until=2020-04-23T16:00", true},
+		{"until=2020-04-23", false},
+		// Fields which are no strings are compared by their text.
+		{"line=42", true},
+		{"can_id=18446744073709551615", true},
+		{"target=wvwzzz1jzxw000001/0x7e0", true},
+		{"target=WVW*/0x7e0", true},
+		{"target=WVW*/0x7e8", false},
+		{"target.ecu=0x7e0", true},
+		{"target.ip=", true},
+		{"line.missing=42", false},
+		{"missing=", true},
+		{"missing=foo", false},
+	}
+	for _, tt := range tests {
+		cond, err := ParseCondition(tt.spec)
+		if err != nil {
+			t.Errorf("ParseCondition(%q): %s", tt.spec, err)
+			continue
+		}
+		if match := cond.Match(record); match != tt.match {
+			t.Errorf("%q matches %t, want %t", tt.spec, match, tt.match)
+		}
+	}
+
+	// Decoders with UseNumber yield priorities as json.Number.
+	numbered := map[string]interface{}{"priority": json.Number("4")}
+	for _, spec := range []string{"prio=warning", "prio>error"} {
+		cond, _ := ParseCondition(spec)
+		if !cond.Match(numbered) {
+			t.Errorf("%q does not match a json.Number priority", spec)
+		}
+	}
+
+	for _, spec := range []string{"prio<=debug", "since=2020-01-01"} {
+		cond, _ := ParseCondition(spec)
+		if cond.Match(map[string]interface{}{"data": "no priority or timestamp"}) {
+			t.Errorf("%q matches a record without the field", spec)
+		}
+	}
+}
+
+func TestExpressionMatch(t *testing.T) {
+	record := map[string]interface{}{
+		"component": "scanner",
+		"type":      "msg",
+		"priority":  float64(penlog.PrioError),
+	}
+	tests := []struct {
+		spec  string
+		match bool
+	}{
+		{"comp=scanner;prio<=error", true},
+		{"comp=scanner;prio<error", false},
+		{"comp=moncay;prio<=error", false},
+	}
+	for _, tt := range tests {
+		expr, err := ParseExpression(tt.spec)
+		if err != nil {
+			t.Errorf("ParseExpression(%q): %s", tt.spec, err)
+			continue
+		}
+		if match := expr.Match(record); match != tt.match {
+			t.Errorf("%q matches %t, want %t", tt.spec, match, tt.match)
+		}
+	}
+}
+
+func TestParsePrio(t *testing.T) {
+	tests := []struct {
+		spec string
+		prio penlog.Prio
+	}{
+		{"trace", penlog.PrioTrace},
+		{"debug", penlog.PrioDebug},
+		{"INFO", penlog.PrioInfo},
+		{"notice", penlog.PrioNotice},
+		{"warning", penlog.PrioWarning},
+		{"error", penlog.PrioError},
+		{"critical", penlog.PrioCritical},
+		{"alert", penlog.PrioAlert},
+		{"emergency", penlog.PrioEmergency},
+		{"5", penlog.Prio(5)},
+	}
+	for _, tt := range tests {
+		prio, err := ParsePrio(tt.spec)
+		if err != nil {
+			t.Errorf("ParsePrio(%q): %s", tt.spec, err)
+			continue
+		}
+		if prio != tt.prio {
+			t.Errorf("ParsePrio(%q) = %d, want %d", tt.spec, prio, tt.prio)
+		}
+	}
+	if _, err := ParsePrio("loud"); err == nil {
+		t.Errorf("ParsePrio(\"loud\"): no error")
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	utc := time.Date(2020, 4, 23, 15, 4, 5, 123456000, time.UTC)
+	tests := []struct {
+		ts   string
+		want time.Time
+	}{
+		{"2020-04-23T15:04:05.123456Z", utc},
+		{"2020-04-23T17:04:05.123456+02:00", utc},
+		{"2020-04-23T15:04:05.123456", time.Date(2020, 4, 23, 15, 4, 5, 123456000, time.Local)},
+		{"2020-04-23T15:04Z", time.Date(2020, 4, 23, 15, 4, 0, 0, time.UTC)},
+		{"2020-04-23T15:04", time.Date(2020, 4, 23, 15, 4, 0, 0, time.Local)},
+		{"2020-04-23", time.Date(2020, 4, 23, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimestamp(tt.ts)
+		if err != nil {
+			t.Errorf("ParseTimestamp(%q): %s", tt.ts, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseTimestamp(%q) = %s, want %s", tt.ts, got, tt.want)
+		}
+	}
+	for _, ts := range []string{"", "NONE", "23.04.2020"} {
+		if _, err := ParseTimestamp(ts); err == nil {
+			t.Errorf("ParseTimestamp(%q): no error", ts)
+		}
+	}
+}
+
+func TestTarget(t *testing.T) {
+	tests := []struct {
+		target interface{}
+		label  string
+	}{
+		{nil, ""},
+		{"bench-3", "bench-3"},
+		{map[string]interface{}{"ip": "10.0.0.1", "hostname": "ecu-gw"}, "ecu-gw"},
+		{map[string]interface{}{"name": "car-1", "vin": "WVWZZZ1JZXW000001"}, "car-1"},
+		{map[string]interface{}{"vin": "WVWZZZ1JZXW000001", "ecu": "0x7e0"}, "WVWZZZ1JZXW000001/0x7e0"},
+		{map[string]interface{}{"ecu": "0x7e0"}, "0x7e0"},
+		{map[string]interface{}{"asset": ""}, ""},
+	}
+	for _, tt := range tests {
+		data := map[string]interface{}{}
+		if tt.target != nil {
+			data["target"] = tt.target
+		}
+		if label := Target(data); label != tt.label {
+			t.Errorf("Target(%v) = %q, want %q", tt.target, label, tt.label)
+		}
+	}
+}
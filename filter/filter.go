@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// Package filter parses and evaluates the filter specifications of
+// hr(1), as given with --filter. A specification selects records and
+// names an output for them. Two syntaxes exist, which are told apart by
+// the operators before the last colon:
+//
+//	filter     = simple | expression ":" output
+//	simple     = output | types ":" output | components ":" types ":" output
+//	components = [ name { "," name } ]
+//	types      = [ name { "," name } ]
+//	expression = condition { ";" condition }
+//	condition  = field operator value
+//	operator   = "=" | "!=" | "~" | "!~" | "<" | "<=" | ">" | ">="
+//
+// Names are compared case insensitive; an empty list matches
+// everything. Since timestamps in expressions contain colons, the output
+// of an expression is everything after the last colon. In the simple
+// syntax the output is everything after the second colon. The output
+// is not interpreted by this package.
+//
+// Problems are reported as *SyntaxError with the position and the
+// expected tokens.
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind is the syntax a filter was specified with.
+type Kind int
+
+const (
+	KindSimple Kind = iota
+	KindExpr
+)
+
+// Filter selects records which are written to Output.
+type Filter struct {
+	Kind Kind
+	// Components and Types are set for KindSimple.
+	Components []string
+	Types      []string
+	// Expr is set for KindExpr.
+	Expr   *Expression
+	Output string
+}
+
+// SyntaxError describes a problem in a specification. Offset is the
+// byte offset in Spec where the problem was found.
+type SyntaxError struct {
+	Spec     string
+	Offset   int
+	Msg      string
+	Expected []string
+}
+
+func (e *SyntaxError) Error() string {
+	msg := fmt.Sprintf("invalid filter '%s' at position %d: %s", e.Spec, e.Offset+1, e.Msg)
+	switch n := len(e.Expected); {
+	case n == 1:
+		msg += ", expected " + e.Expected[0]
+	case n == 2:
+		msg += ", expected " + e.Expected[0] + " or " + e.Expected[1]
+	case n > 2:
+		msg += ", expected " + strings.Join(e.Expected[:n-1], ", ") + ", or " + e.Expected[n-1]
+	}
+	return msg
+}
+
+func syntaxError(spec string, offset int, expected []string, format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{
+		Spec:     spec,
+		Offset:   offset,
+		Msg:      fmt.Sprintf(format, args...),
+		Expected: expected,
+	}
+}
+
+// KindOf distinguishes "comp=foo;prio<=warning:file" from the simple
+// "component:type:file" syntax. Expressions need operators which are
+// not allowed in the simple syntax.
+func KindOf(spec string) Kind {
+	i := strings.LastIndex(spec, ":")
+	if i > 0 && strings.ContainsAny(spec[:i], "=~<>") {
+		return KindExpr
+	}
+	return KindSimple
+}
+
+// Parse parses a filter specification of either syntax.
+func Parse(spec string) (*Filter, error) {
+	if KindOf(spec) == KindExpr {
+		return parseExprFilter(spec)
+	}
+	return parseSimpleFilter(spec)
+}
+
+func parseSimpleFilter(spec string) (*Filter, error) {
+	var (
+		f      = Filter{Kind: KindSimple}
+		parts  = strings.SplitN(spec, ":", 3)
+		offset = len(spec) - len(parts[len(parts)-1])
+	)
+	switch len(parts) {
+	case 1:
+		f.Output = parts[0]
+	case 2:
+		f.Types = splitList(parts[0])
+		f.Output = parts[1]
+	case 3:
+		f.Components = splitList(parts[0])
+		f.Types = splitList(parts[1])
+		f.Output = parts[2]
+	}
+	if f.Output == "" {
+		return nil, syntaxError(spec, offset, []string{"an output"}, "missing output")
+	}
+	return &f, nil
+}
+
+func parseExprFilter(spec string) (*Filter, error) {
+	// KindOf guarantees a colon.
+	i := strings.LastIndex(spec, ":")
+	expr, err := parseExpression(spec, 0, i)
+	if err != nil {
+		return nil, err
+	}
+	if i+1 == len(spec) {
+		return nil, syntaxError(spec, i+1, []string{"an output"}, "missing output")
+	}
+	return &Filter{Kind: KindExpr, Expr: expr, Output: spec[i+1:]}, nil
+}
+
+// Match reports whether the record data is selected by the filter.
+func (f *Filter) Match(data map[string]interface{}) bool {
+	switch f.Kind {
+	case KindSimple:
+		comp, ok := stringField(data, "component")
+		if !ok {
+			return false
+		}
+		msgType, ok := stringField(data, "type")
+		if !ok {
+			return false
+		}
+		return MatchName(comp, f.Components) && MatchName(msgType, f.Types)
+	case KindExpr:
+		return f.Expr.Match(data)
+	}
+	return false
+}
+
+// MatchName reports whether candidate is one of names, ignoring case.
+// An empty list of names matches everything.
+func MatchName(candidate string, names []string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, name := range names {
+		if strings.EqualFold(candidate, name) {
+			return true
+		}
+	}
+	return false
+}
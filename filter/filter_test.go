@@ -0,0 +1,233 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package filter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		spec string
+		kind Kind
+	}{
+		{"out.log", KindSimple},
+		{"error:out.log", KindSimple},
+		{"scanner,moncay:error,warning:out.log", KindSimple},
+		{"-", KindSimple},
+		{"comp=scanner:out.log", KindExpr},
+		{"data~foo:-", KindExpr},
+		{"prio<=warning:-", KindExpr},
+		{"since=2020-04-23T15:00:out.log", KindExpr},
+		// Options of the output are not part of the expression.
+		{"error:out.log?format=hr", KindSimple},
+		// Without a colon everything is the output.
+		{"comp=scanner", KindSimple},
+	}
+	for _, tt := range tests {
+		if kind := KindOf(tt.spec); kind != tt.kind {
+			t.Errorf("KindOf(%q) = %d, want %d", tt.spec, kind, tt.kind)
+		}
+	}
+}
+
+func TestParseSimple(t *testing.T) {
+	tests := []struct {
+		spec       string
+		components []string
+		types      []string
+		output     string
+	}{
+		{"out.log", nil, nil, "out.log"},
+		{"error:out.log", nil, []string{"error"}, "out.log"},
+		{"error,warning:out.log", nil, []string{"error", "warning"}, "out.log"},
+		{"scanner:error:out.log", []string{"scanner"}, []string{"error"}, "out.log"},
+		{"scanner,,moncay::out.log", []string{"scanner", "moncay"}, nil, "out.log"},
+		{"::-", nil, nil, "-"},
+		{"a:b:c:d", []string{"a"}, []string{"b"}, "c:d"},
+		{"error:out.log?format=hr&colors=1", nil, []string{"error"}, "out.log?format=hr&colors=1"},
+	}
+	for _, tt := range tests {
+		f, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %s", tt.spec, err)
+			continue
+		}
+		if f.Kind != KindSimple {
+			t.Errorf("Parse(%q): kind %d, want simple", tt.spec, f.Kind)
+		}
+		if !reflect.DeepEqual(f.Components, tt.components) {
+			t.Errorf("Parse(%q): components %q, want %q", tt.spec, f.Components, tt.components)
+		}
+		if !reflect.DeepEqual(f.Types, tt.types) {
+			t.Errorf("Parse(%q): types %q, want %q", tt.spec, f.Types, tt.types)
+		}
+		if f.Output != tt.output {
+			t.Errorf("Parse(%q): output %q, want %q", tt.spec, f.Output, tt.output)
+		}
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	f, err := Parse("comp=scanner;prio<=warning;since=2020-04-23T15:00:out.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Kind != KindExpr {
+		t.Fatalf("kind %d, want expression", f.Kind)
+	}
+	if f.Output != "out.log" {
+		t.Errorf("output %q, want out.log", f.Output)
+	}
+	var fields []string
+	for _, cond := range f.Expr.Conditions {
+		fields = append(fields, cond.Field+cond.Op+cond.Value)
+	}
+	want := []string{"component=scanner", "priority<=warning", "since=2020-04-23T15:00"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("conditions %q, want %q", fields, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		msg  string
+	}{
+		{
+			"",
+			"invalid filter '' at position 1: missing output, expected an output",
+		},
+		{
+			"error:",
+			"invalid filter 'error:' at position 7: missing output, expected an output",
+		},
+		{
+			"scanner:error:",
+			"invalid filter 'scanner:error:' at position 15: missing output, expected an output",
+		},
+		{
+			"comp=scanner:",
+			"invalid filter 'comp=scanner:' at position 14: missing output, expected an output",
+		},
+		{
+			"=scanner:-",
+			"invalid filter '=scanner:-' at position 1: missing field, expected a field name",
+		},
+		{
+			"comp=scanner;=foo:-",
+			"invalid filter 'comp=scanner;=foo:-' at position 14: missing field, expected a field name",
+		},
+		{
+			"comp=scanner;type:-",
+			"invalid filter 'comp=scanner;type:-' at position 18: missing operator, expected '!=', '!~', '<=', '>=', '=', '~', '<', or '>'",
+		},
+		{
+			"comp!scanner;type=x:-",
+			"invalid filter 'comp!scanner;type=x:-' at position 5: unknown operator, expected '!=', '!~', '<=', '>=', '=', '~', '<', or '>'",
+		},
+		{
+			"comp<scanner:-",
+			"invalid filter 'comp<scanner:-' at position 5: '<' only works with priorities, expected '=', '!=', '~', or '!~'",
+		},
+		{
+			"prio<=loud:-",
+			"invalid filter 'prio<=loud:-' at position 7: unknown priority 'loud', expected a priority name or an integer",
+		},
+		{
+			"prio <= loud:-",
+			"invalid filter 'prio <= loud:-' at position 9: unknown priority 'loud', expected a priority name or an integer",
+		},
+		{
+			"since~2020:-",
+			"invalid filter 'since~2020:-' at position 6: 'since' only supports '=', expected '='",
+		},
+		{
+			"until=yesterday:-",
+			"invalid filter 'until=yesterday:-' at position 7: invalid timestamp 'yesterday', expected an ISO8601 timestamp",
+		},
+		{
+			"data~(:-",
+			"invalid filter 'data~(:-' at position 6: invalid regular expression: error parsing regexp: missing closing ): `(`",
+		},
+		{
+			"comp=[:-",
+			"invalid filter 'comp=[:-' at position 6: invalid pattern: syntax error in pattern",
+		},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.spec)
+		if err == nil {
+			t.Errorf("Parse(%q): no error", tt.spec)
+			continue
+		}
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Parse(%q): %T is no SyntaxError", tt.spec, err)
+		}
+		if err.Error() != tt.msg {
+			t.Errorf("Parse(%q):\n got: %s\nwant: %s", tt.spec, err, tt.msg)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	record := map[string]interface{}{
+		"component": "scanner",
+		"type":      "Error",
+		"data":      "finished",
+		"priority":  float64(3),
+	}
+	tests := []struct {
+		spec  string
+		match bool
+	}{
+		{"out.log", true},
+		{"error:out.log", true},
+		{"ERROR:out.log", true},
+		{"warning:out.log", false},
+		{"scanner:error:out.log", true},
+		{"moncay,SCANNER::out.log", true},
+		{"moncay::out.log", false},
+		{"comp=scanner:out.log", true},
+		{"comp=scan*;prio<=error:out.log", true},
+		{"comp=scan*;prio<error:out.log", false},
+	}
+	for _, tt := range tests {
+		f, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %s", tt.spec, err)
+			continue
+		}
+		if match := f.Match(record); match != tt.match {
+			t.Errorf("%q matches %t, want %t", tt.spec, match, tt.match)
+		}
+	}
+
+	f, _ := Parse("error:out.log")
+	if f.Match(map[string]interface{}{"component": "scanner"}) {
+		t.Errorf("record without type matches")
+	}
+}
+
+func TestMatchName(t *testing.T) {
+	tests := []struct {
+		candidate string
+		names     []string
+		match     bool
+	}{
+		{"scanner", nil, true},
+		{"", nil, true},
+		{"scanner", []string{"scanner"}, true},
+		{"Scanner", []string{"moncay", "SCANNER"}, true},
+		{"scanner", []string{"moncay"}, false},
+		{"", []string{"moncay"}, false},
+	}
+	for _, tt := range tests {
+		if match := MatchName(tt.candidate, tt.names); match != tt.match {
+			t.Errorf("MatchName(%q, %q) = %t, want %t", tt.candidate, tt.names, match, tt.match)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	penlog "github.com/Fraunhofer-AISEC/penlogger"
+)
+
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// ParseTimestamp parses the ISO8601 timestamps as used in penlog(7).
+// Timestamps without a zone are interpreted as local time.
+func ParseTimestamp(ts string) (time.Time, error) {
+	var err error
+	for _, layout := range timestampLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, ts, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// ParsePrio parses a priority given as integer or by its name.
+func ParsePrio(spec string) (penlog.Prio, error) {
+	if val, err := strconv.ParseInt(spec, 10, 64); err == nil {
+		return penlog.Prio(val), nil
+	}
+	switch strings.ToLower(spec) {
+	case "trace":
+		return penlog.PrioTrace, nil
+	case "debug":
+		return penlog.PrioDebug, nil
+	case "info":
+		return penlog.PrioInfo, nil
+	case "notice":
+		return penlog.PrioNotice, nil
+	case "warning":
+		return penlog.PrioWarning, nil
+	case "error":
+		return penlog.PrioError, nil
+	case "critical":
+		return penlog.PrioCritical, nil
+	case "alert":
+		return penlog.PrioAlert, nil
+	case "emergency":
+		return penlog.PrioEmergency, nil
+	}
+	return 0, fmt.Errorf("invalid loglevel '%s'", spec)
+}
+
+func stringField(data map[string]interface{}, field string) (string, bool) {
+	s, ok := data[field].(string)
+	return s, ok
+}
+
+// splitList splits a comma separated list and drops empty elements.
+func splitList(s string) []string {
+	var res []string
+	for _, elem := range strings.Split(s, ",") {
+		if elem != "" {
+			res = append(res, elem)
+		}
+	}
+	return res
+}
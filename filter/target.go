@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package filter
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// TargetKeys are the keys of the field "target" of penlog(7) which
+// identify the target itself, in the order of preference for Target.
+// The key "ecu" identifies a part of the target.
+var TargetKeys = []string{"name", "vin", "asset", "hostname", "ip"}
+
+// Target returns a label for the target under test of a record, or ""
+// if the record has none. The label is the first of TargetKeys which
+// is set, followed by "/" and the ECU address if available, e.g.
+// "WVWZZZ1JZXW000001/0x7e0". A target given as plain string is used
+// as it is.
+func Target(data map[string]interface{}) string {
+	switch v := data["target"].(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		var label string
+		for _, key := range TargetKeys {
+			if s, ok := v[key].(string); ok && s != "" {
+				label = s
+				break
+			}
+		}
+		if ecu, ok := v["ecu"].(string); ok && ecu != "" {
+			if label == "" {
+				return ecu
+			}
+			return label + "/" + ecu
+		}
+		return label
+	}
+	return ""
+}
+
+// lookup returns the value of a field. Fields of nested objects are
+// addressed with dots, e.g. "target.vin"; a field whose name contains
+// dots takes precedence.
+func lookup(data map[string]interface{}, field string) (interface{}, bool) {
+	if val, ok := data[field]; ok {
+		return val, true
+	}
+	parts := strings.Split(field, ".")
+	if len(parts) < 2 {
+		return nil, false
+	}
+	var val interface{} = data
+	for _, part := range parts {
+		obj, ok := asObject(val)
+		if !ok {
+			return nil, false
+		}
+		if val, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+	return val, true
+}
+
+// asObject also accepts values which are not decoded yet, as long as
+// they encode to a JSON object.
+func asObject(val interface{}) (map[string]interface{}, bool) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		return v, true
+	case json.Marshaler:
+		raw, err := v.MarshalJSON()
+		if err != nil {
+			return nil, false
+		}
+		var obj map[string]interface{}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return nil, false
+		}
+		return obj, true
+	}
+	return nil, false
+}
@@ -5,10 +5,11 @@ go 1.15
 require (
 	codeberg.org/rumpelsepp/helpers v0.0.0-20211020091314-b9b064cf8c8a
 	github.com/Fraunhofer-AISEC/penlogger v0.0.0-20210914113712-8a2b1758b080
+	github.com/itchyny/gojq v0.12.7
 	github.com/json-iterator/go v1.1.12
 	github.com/klauspost/compress v1.13.6
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/crypto v0.0.0-20211108221036-ceb1ce70b4fa // indirect
-	golang.org/x/sys v0.0.0-20211111213525-f221eed1c01e
+	golang.org/x/sys v0.10.0
 )
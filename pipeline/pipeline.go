@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// Package pipeline chains processing stages for decoded penlog records
+// within one process. hr(1) uses it for --then; chaining stages in one
+// process avoids encoding and decoding every record again as a shell
+// pipeline such as `hr -o json | hr --stats` would.
+//
+// A stage receives one record at a time and returns the record which is
+// passed to the next stage, or nil to drop it. Stages which produce a
+// result only at the end of the input, e.g. statistics, implement
+// Finisher as well.
+package pipeline
+
+// Record is a decoded penlog record.
+type Record = map[string]interface{}
+
+// Stage processes records.
+type Stage interface {
+	Process(r Record) (Record, error)
+}
+
+// Finisher is implemented by stages which have to do something at the
+// end of the input.
+type Finisher interface {
+	Finish() error
+}
+
+// Func adapts a function to a Stage.
+type Func func(r Record) (Record, error)
+
+// Process calls f(r).
+func (f Func) Process(r Record) (Record, error) {
+	return f(r)
+}
+
+// Matcher is implemented by *filter.Filter, *filter.Expression, and
+// *filter.Condition.
+type Matcher interface {
+	Match(data map[string]interface{}) bool
+}
+
+// Filter returns a stage which drops the records not matching m.
+func Filter(m Matcher) Stage {
+	return Func(func(r Record) (Record, error) {
+		if !m.Match(r) {
+			return nil, nil
+		}
+		return r, nil
+	})
+}
+
+// Pipeline is a sequence of stages. The zero value passes all records
+// through unchanged.
+type Pipeline struct {
+	stages []Stage
+}
+
+// New returns a pipeline of the given stages.
+func New(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// Then appends a stage and returns p for chaining.
+func (p *Pipeline) Then(s Stage) *Pipeline {
+	p.stages = append(p.stages, s)
+	return p
+}
+
+// Process passes r through all stages. It stops at the first stage
+// which drops the record or fails.
+func (p *Pipeline) Process(r Record) (Record, error) {
+	var err error
+	for _, s := range p.stages {
+		r, err = s.Process(r)
+		if err != nil || r == nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Finish finishes all stages in order, also if one of them fails, and
+// returns the first error.
+func (p *Pipeline) Finish() error {
+	var first error
+	for _, s := range p.stages {
+		f, ok := s.(Finisher)
+		if !ok {
+			continue
+		}
+		if err := f.Finish(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pipeline
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Fraunhofer-AISEC/penlog/filter"
+)
+
+type counter struct {
+	n        int
+	finished bool
+	err      error
+}
+
+func (c *counter) Process(r Record) (Record, error) {
+	c.n++
+	return nil, nil
+}
+
+func (c *counter) Finish() error {
+	c.finished = true
+	return c.err
+}
+
+func TestPipeline(t *testing.T) {
+	expr, err := filter.ParseExpression("comp=scanner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag := Func(func(r Record) (Record, error) {
+		r["stage"] = "tagged"
+		return r, nil
+	})
+	p := New(Filter(expr)).Then(tag)
+
+	out, err := p.Process(Record{"component": "moncay"})
+	if err != nil || out != nil {
+		t.Errorf("moncay: got %v, %v, want nil, nil", out, err)
+	}
+	out, err = p.Process(Record{"component": "scanner"})
+	want := Record{"component": "scanner", "stage": "tagged"}
+	if err != nil || !reflect.DeepEqual(out, want) {
+		t.Errorf("scanner: got %v, %v, want %v", out, err, want)
+	}
+
+	var zero Pipeline
+	if out, _ := zero.Process(Record{"data": "x"}); out["data"] != "x" {
+		t.Errorf("zero pipeline changed the record: %v", out)
+	}
+}
+
+func TestPipelineErrors(t *testing.T) {
+	errStage := errors.New("stage failed")
+	var (
+		reached = false
+		p       = New(
+			Func(func(r Record) (Record, error) { return nil, errStage }),
+			Func(func(r Record) (Record, error) { reached = true; return r, nil }),
+		)
+	)
+	if _, err := p.Process(Record{}); err != errStage {
+		t.Errorf("got %v, want %v", err, errStage)
+	}
+	if reached {
+		t.Errorf("stage after the failed stage was called")
+	}
+}
+
+func TestFinish(t *testing.T) {
+	var (
+		errFirst = errors.New("first")
+		a        = &counter{err: errFirst}
+		b        = &counter{err: errors.New("second")}
+		p        = New(a, b)
+	)
+	p.Process(Record{})
+	if a.n != 1 || b.n != 0 {
+		t.Errorf("processed %d and %d records, want 1 and 0", a.n, b.n)
+	}
+	if err := p.Finish(); err != errFirst {
+		t.Errorf("got %v, want %v", err, errFirst)
+	}
+	if !a.finished || !b.finished {
+		t.Errorf("not all stages finished")
+	}
+}
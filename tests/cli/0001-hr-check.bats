@@ -126,3 +126,603 @@ setup() {
     out="$(hr hr/example-with-error.log.json)"
     compstr "$out" "$(< hr/expected-with-error.log)"
 }
+
+@test "show priority as column" {
+	local out
+
+	out="$(hr --show-priority --show-colors=false "${HRFLAGS[@]}" hr/example-colors.log.json | head -n 2)"
+	compstr "$out" "Apr  2 12:48:08.906 {scanner } [msg    ]: emergency Starting tshark
+Apr  2 12:48:09.583 {moncay  } [msg    ]: alert     Doing stuff"
+
+	out="$(hr --show-priority=number --show-colors=false "${HRFLAGS[@]}" hr/example-colors.log.json | head -n 1)"
+	compstr "$out" "Apr  2 12:48:08.906 {scanner } [msg    ]: 0 Starting tshark"
+
+	run hr --show-priority=foo hr/example-colors.log.json
+	[[ "$status" -eq 1 ]]
+}
+
+@test "show time since phase start" {
+	local out
+
+	out="$(hr --phase-time --show-colors=false "${HRFLAGS[@]}" hr/phases.log.json)"
+	compstr "$out" "Apr  2 12:00:00.000 {scanner } [msg    ]: before
+      +00:00:00.000 {scanner } [phase-s]: fuzzing started
+      +00:00:02.250 {scanner } [msg    ]: crash
+Apr  2 12:00:04.000 {other   } [msg    ]: unrelated
+      +01:00:03.000 {scanner } [phase-e]: fuzzing done
+Apr  2 13:00:05.000 {scanner } [msg    ]: after"
+}
+
+@test "phase budget" {
+	local out
+	local alert="$BATS_TMPDIR/phase-budget.out"
+
+	out="$(hr --phase-budget fuzzing=10m --phase-budget-exec 'echo "$PENLOG_PHASE $PENLOG_PHASE_COMPONENT" > '"$alert" --show-colors=false "${HRFLAGS[@]}" hr/phases.log.json | sed "s/^[^{]*//")"
+	compstr "$out" "{scanner } [msg    ]: before
+{scanner } [phase-s]: fuzzing started
+{scanner } [msg    ]: crash
+{other   } [msg    ]: unrelated
+{hr      } [phase-b]: phase fuzzing of scanner exceeded its budget of 10m0s after 1h0m3s
+{scanner } [phase-e]: fuzzing done
+{scanner } [msg    ]: after"
+	compstr "$(cat "$alert")" "fuzzing scanner"
+
+	run hr --phase-budget fuzzing "${HRFLAGS[@]}" hr/phases.log.json
+	[ "$status" -eq 1 ]
+}
+
+@test "phase budget of replayed files" {
+	local out
+
+	# The second file takes longer than the budget to read, but the
+	# phase is within its budget according to the timestamps.
+	out="$(hr --phase-budget fuzzing=100ms --show-colors=false "${HRFLAGS[@]}" <(head -n 2 hr/phases.log.json) <(sleep 0.5; echo '{"timestamp":"2020-04-02T12:00:01.050000","component":"scanner","type":"phase-end","phase":"fuzzing","data":"fuzzing done","priority":5}') | sed "s/^[^{]*//")"
+	compstr "$out" "{scanner } [msg    ]: before
+{scanner } [phase-s]: fuzzing started
+{scanner } [phase-e]: fuzzing done"
+}
+
+@test "check order of timestamps" {
+	local out
+
+	out="$(hr --check-order --show-colors=false "${HRFLAGS[@]}" hr/out-of-order.log.json | sed "s/^[^{]*//")"
+	compstr "$out" "{a       } [msg    ]: one
+{a       } [msg    ]: two
+{b       } [msg    ]: jitter
+{hr      } [order  ]: line 4: timestamp regressed by 4s
+{b       } [msg    ]: late
+{hr      } [order  ]: 1 timestamps regressed by more than 1s, at most by 4s; sort the input before trusting the timeline"
+
+	out="$(hr --check-order --order-threshold=5s --show-colors=false "${HRFLAGS[@]}" hr/out-of-order.log.json | tail -n 1 | sed "s/^[^{]*//")"
+	compstr "$out" "{hr      } [order  ]: no timestamps regressed by more than 5s"
+
+	hr --stats hr/out-of-order.log.json | grep -q "^out of order: 1 (at most by 4s)$"
+}
+
+@test "pretty JSON output" {
+	local out
+
+	out="$(hr -o jsonl-pretty hr/out-of-order.log.json | head -n 9)"
+	compstr "$out" '{
+  "component": "a",
+  "data": "one",
+  "priority": 6,
+  "timestamp": "2020-04-02T12:00:00.000000",
+  "type": "msg"
+}
+---
+{'
+
+	out="$(hr --output json hr/out-of-order.log.json)"
+	compstr "$out" "$(jq -cS . hr/out-of-order.log.json)"
+}
+
+@test "keep html characters in written files" {
+	local record='{"component":"a","data":"<script> & </script>","timestamp":"2020-04-02T12:00:00.000000","type":"msg"}'
+
+	echo "$record" | hr -f "$BATS_TMPDIR/html.log" > /dev/null
+	compstr "$(< "$BATS_TMPDIR/html.log")" "$record"
+	rm "$BATS_TMPDIR/html.log"
+}
+
+@test "add run id to written records" {
+	local out
+
+	out="$(hr --show-colors=false "${HRFLAGS[@]}" --run-id=scan-42 -f "$BATS_TMPDIR/run.log" hr/out-of-order.log.json)"
+	[[ "${out%%$'\n'*}" == *"[run    ]: run id scan-42" ]]
+	compstr "$(jq -r .run_id "$BATS_TMPDIR/run.log" | uniq)" "scan-42"
+
+	# Existing run ids are kept.
+	hr --run-id -f "$BATS_TMPDIR/run2.log" "$BATS_TMPDIR/run.log" > /dev/null
+	compstr "$(jq -r .run_id "$BATS_TMPDIR/run2.log" | uniq)" "scan-42"
+
+	hr -f "$BATS_TMPDIR/run.log" hr/out-of-order.log.json > /dev/null
+	compstr "$(jq -r .run_id "$BATS_TMPDIR/run.log" | uniq)" "null"
+	rm "$BATS_TMPDIR/run.log" "$BATS_TMPDIR/run2.log"
+}
+
+@test "append provenance to written records" {
+	hr --provenance -f "$BATS_TMPDIR/via.log" hr/out-of-order.log.json > /dev/null
+	compstr "$(jq -r '.via | length' "$BATS_TMPDIR/via.log" | uniq)" "1"
+	compstr "$(jq -r '.via[0].host' "$BATS_TMPDIR/via.log" | uniq)" "$(hostname)"
+	# Hops cross hosts, hence their time is in UTC.
+	[[ "$(jq -r '.via[0].timestamp' "$BATS_TMPDIR/via.log" | head -n 1)" =~ ^[0-9-]+T[0-9:.]+Z$ ]]
+
+	# Every hop appends its entry.
+	hr --provenance -f "$BATS_TMPDIR/via2.log" "$BATS_TMPDIR/via.log" > /dev/null
+	compstr "$(jq -r '.via | length' "$BATS_TMPDIR/via2.log" | uniq)" "2"
+	rm "$BATS_TMPDIR/via.log" "$BATS_TMPDIR/via2.log"
+}
+
+@test "records of hr carry an offset to UTC" {
+	[[ "$(echo '{}' | TZ=Europe/Berlin hr -o json --strict | head -n 1 | jq -r .timestamp)" =~ ^[0-9-]+T[0-9:.]+[+-][0-9]{2}:[0-9]{2}$ ]]
+	[[ "$(echo '{}' | TZ=UTC hr -o json --strict | head -n 1 | jq -r .timestamp)" =~ ^[0-9-]+T[0-9:.]+Z$ ]]
+}
+
+@test "stop after a number of records" {
+	local out
+
+	out="$(hr --max-records 2 --show-colors=false "${HRFLAGS[@]}" -f "$BATS_TMPDIR/limit.log" hr/out-of-order.log.json | sed "s/^[^{]*//")"
+	compstr "$out" "{a       } [msg    ]: one
+{a       } [msg    ]: two
+{hr      } [limit  ]: record limit of 2 reached; stopping"
+	compstr "$(jq -r .type "$BATS_TMPDIR/limit.log")" "msg
+msg
+limit"
+	rm "$BATS_TMPDIR/limit.log"
+}
+
+@test "stop after a duration" {
+	local out
+
+	out="$(hr --max-duration 100ms --show-colors=false "${HRFLAGS[@]}" < <(sleep 5) | sed "s/^[^{]*//")"
+	compstr "$out" "{hr      } [limit  ]: time limit of 100ms reached; stopping"
+}
+
+@test "fold repeated stacktraces" {
+	local out
+
+	out="$(hr --show-stacktraces --show-colors=false "${HRFLAGS[@]}" hr/stacktraces.log.json | grep "=> stacktrace")"
+	compstr "$out" "  => stacktrace: 
+  => stacktrace: repeated 7501af04, first at Apr  2 12:00:00.000, seen 2 times
+  => stacktrace: repeated 7501af04, first at Apr  2 12:00:00.000, seen 3 times"
+
+	out="$(hr --show-stacktraces --expand-stacktraces --show-colors=false "${HRFLAGS[@]}" hr/stacktraces.log.json | grep -c "main.go:12")"
+	compstr "$out" "3"
+}
+
+@test "render structured errors" {
+	local out
+
+	out="$(hr --show-colors=false "${HRFLAGS[@]}" hr/errors.log.json | grep "=> error")"
+	compstr "$out" "  => error: *fmt.wrapError: loading config: open hr.json: no such file or directory (2 causes)
+  => error: timeout"
+
+	out="$(hr --expand-errors --show-colors=false "${HRFLAGS[@]}" hr/errors.log.json | grep "^  |")"
+	compstr "$out" "  |caused by: *fs.PathError: open hr.json: no such file or directory
+  |caused by: syscall.Errno: no such file or directory
+  |stacktrace:
+  |  main.load()
+  |  	/src/main.go:42"
+
+	run hr --validate hr/errors.log.json
+	[[ "$status" -eq 0 ]]
+}
+
+@test "preserve the values of extension fields" {
+	local out="$BATS_TMPDIR/extensions.log.json"
+	local expected='{"can_id":18446744073709551615,"component":"a","data":"x","meta":{"z":1e3,"a":[1.0,2]},"note":"café","priority":6,"ratio":1.50,"timestamp":"2020-04-02T12:00:00.000000","type":"msg"}'
+
+	compstr "$(hr -o json hr/extensions.log.json)" "$expected"
+	hr -f "$out" hr/extensions.log.json > /dev/null
+	compstr "$(cat "$out")" "$expected"
+	compstr "$(hr -f "can_id=18446744073709551615:-" "${HRFLAGS[@]}" --show-colors=false hr/extensions.log.json | wc -l)" "1"
+}
+
+@test "keep the precision of large integers in jq" {
+	local out
+
+	out="$(hr --jq-native --jq '{can_id, next: (.can_id - 1)}' -o json hr/extensions.log.json 2>&1)"
+	[[ "$out" == *'"can_id":18446744073709551615'* ]]
+	[[ "$out" == *'"next":18446744073709551614'* ]]
+}
+
+@test "chain stages with --then" {
+	compstr "$(hr -f "comp=scanner:-" --then stats hr/example.log.json)" "$(hr -f "comp=scanner:-" -o json hr/example.log.json | hr --stats)"
+	compstr "$(hr --grep crash --then fixture hr/fixture.log.json)" "$(hr --grep crash -o json hr/fixture.log.json | hr -f "$BATS_TMPDIR/then.json?format=fixture" > /dev/null; cat "$BATS_TMPDIR/then.json")"
+
+	run hr --then stats --then fixture hr/example.log.json
+	[[ "$status" -eq 1 ]]
+}
+
+@test "filter by target" {
+	compstr "$(hr "${HRFLAGS[@]}" --show-colors=false --target "wvw*/0x7e0" hr/targets.log.json | sed "s/^[^{]*//")" "{scanner } [msg    ]: probing
+{scanner } [finding]: seed is constant"
+	compstr "$(hr "${HRFLAGS[@]}" --show-colors=false -f "target.hostname=gateway;prio<=warning:-" hr/targets.log.json | sed "s/^[^{]*//")" "{scanner } [finding]: telnet is open"
+
+	run hr --validate hr/targets.log.json
+	[[ "$status" -eq 0 ]]
+	run hr --validate <<< '{"data": "x", "timestamp": "2020-04-23T15:21:50", "type": "msg", "target": {"ecu": 2016}}'
+	[[ "$status" -eq 1 ]]
+	compstr "${lines[0]}" "<stdin>:1: target.ecu: expected string, got number"
+}
+
+@test "group records by target" {
+	compstr "$(hr "${HRFLAGS[@]}" --show-colors=false --group-by target hr/targets.log.json | sed "s/^[^{]*//" | sed -n "1,4p")" "{hr      } [group  ]: target WVWZZZ1JZXW000001/0x7e0
+{scanner } [msg    ]: probing
+{scanner } [finding]: seed is constant
+{hr      } [group  ]: target WVWZZZ1JZXW000001/0x7e0: 2 records, 1 errors, 0 warnings, from 2020-04-02T12:00:01.000000 to 2020-04-02T12:00:03.000000"
+	compstr "$(hr -o json --group-by target hr/targets.log.json | jq -r 'select(.type == "group" and .records) | "\(.group)=\(.records)"')" "WVWZZZ1JZXW000001/0x7e0=2
+WVWZZZ1JZXW000001/0x7e8=1
+gateway=2
+=2"
+
+	local dir="$BATS_TMPDIR/groups"
+	rm -rf "$dir"
+	hr --group-by target --group-dir "$dir" hr/targets.log.json > /dev/null
+	compstr "$(ls "$dir")" "WVWZZZ1JZXW000001_0x7e0.log.json
+WVWZZZ1JZXW000001_0x7e8.log.json
+gateway.log.json
+no-target.log.json"
+	compstr "$(jq -r .data "$dir/gateway.log.json")" "probing
+telnet is open"
+	rm -r "$dir"
+
+	# Labels which are equal after replacing characters get distinct files.
+	printf '%s\n' '{"timestamp": "2020-04-02T12:00:00.000000", "component": "ecu 1", "type": "msg", "data": "one"}' \
+		'{"timestamp": "2020-04-02T12:00:01.000000", "component": "ecu/1", "type": "msg", "data": "two"}' \
+		'{"timestamp": "2020-04-02T12:00:02.000000", "component": "ecu 1", "type": "msg", "data": "three"}' |
+		hr --group-by component --group-dir "$dir" > /dev/null
+	compstr "$(jq -r .data "$dir/ecu_1.log.json")" "one
+three"
+	compstr "$(jq -r .data "$dir/ecu_1-2.log.json")" "two"
+	rm -r "$dir"
+
+	run hr --group-by vin hr/targets.log.json
+	[[ "$status" -eq 1 ]]
+}
+
+@test "keep a session log" {
+	local out
+	local dir="$BATS_TMPDIR/sessions"
+	rm -rf "$dir"
+
+	out="$(hr --session-log "$dir" --target gateway "${HRFLAGS[@]}" hr/targets.log.json)"
+	local log=("$dir"/hr-*.log)
+	[[ "${#log[@]}" -eq 1 ]]
+	compstr "$(grep -v "^#" "${log[0]}")" "$out"
+	compstr "$(grep "^# args: \|^# filters: " "${log[0]}")" "# args: --complen=8 --session-log=$dir --target=gateway --typelen=7
+# filters: --target 'gateway'"
+	rm -r "$dir"
+}
+
+@test "report checks and findings" {
+	run hr --report json hr/checks.log.json
+	[[ "$status" -eq 1 ]]
+	compstr "$(jq -c '[.tests, .failures, [.suites[].name], .suites[0].checks[1].details]' <<< "$output")" '[5,3,["scanner","fuzzer"],{"expected":"0x67","got":"0x7f"}]'
+
+	run hr --report junit hr/checks.log.json
+	[[ "$status" -eq 1 ]]
+	[[ "${lines[1]}" == '<testsuites tests="5" failures="3">' ]]
+	[[ "$output" == *'<failure message="check security-access failed">'* ]]
+	[[ "$output" == *'<testcase name="open-telnet" classname="scanner">'* ]]
+
+	run hr --report junit < <(grep '"check"' hr/checks.log.json | grep -v security-access)
+	[[ "$status" -eq 0 ]]
+
+	run hr --report sarif hr/checks.log.json
+	[[ "$status" -eq 1 ]]
+	compstr "$(jq -c '[.version, [.runs[] | .tool.driver.name, [.results[] | .ruleId, .level]]]' <<< "$output")" '["2.1.0",["scanner",["security-access","error","open-telnet","warning"],"fuzzer",["crash at frame 4711","error"]]]'
+	compstr "$(jq -c '.runs[0].results[1].locations' <<< "$output")" '[{"physicalLocation":{"artifactLocation":{"uri":"scanner/ports.go"},"region":{"startLine":42}},"logicalLocations":[{"name":"gateway","kind":"target"}]}]'
+
+	run hr --validate hr/checks.log.json
+	[[ "$status" -eq 0 ]]
+	run hr --validate <<< '{"data": "x", "timestamp": "2020-04-23T15:21:50", "type": "check", "check": "x", "passed": "yes"}'
+	[[ "$status" -eq 1 ]]
+	compstr "${lines[0]}" "<stdin>:1: passed: expected bool, got string"
+}
+
+@test "read length prefixed CBOR records" {
+	compstr "$(hr -o json hr/records.cbor)" '{"component":"ecu","data":"definite","neg":-18446744073709551616,"timestamp":"2020-04-02T12:00:00Z","type":"can","u64":18446744073709551615}
+{"component":"ecu","data":"indefinite","list":[1,2],"timestamp":"2020-04-02T12:00:01Z","type":"can"}
+{"component":"JSON","data":"cbor: truncated record","timestamp":"NONE","type":"ERROR"}'
+	compstr "$(hr -o json --input-format cbor hr/records.cbor | head -n 1 | jq -r .data)" "definite"
+}
+
+@test "read length prefixed MessagePack records" {
+	compstr "$(hr -o json hr/records.msgpack)" '{"component":"ecu","data":"fixmap","neg":-9223372036854775808,"timestamp":"2020-04-02T12:00:00Z","type":"can","u64":18446744073709551615}
+{"component":"ecu","data":"map16","timestamp":"2020-04-02T12:00:01Z","type":"can"}
+{"component":"JSON","data":"msgpack: truncated record","timestamp":"NONE","type":"ERROR"}'
+}
+
+@test "text with a plausible length prefix is no binary input" {
+	# The fifth byte of "Schön" looks like the header of a CBOR map.
+	compstr "$(hr -o json hr/umlaut.log | jq -c '[.component, .data]')" '["JSON","Schön, this is not a record"]
+["scanner","valid"]'
+}
+
+@test "import packets of tshark" {
+	compstr "$(TZ=Europe/Berlin hr "${HRFLAGS[@]}" --show-colors=false --input-format tshark-ek hr/tshark.ek.json)" "Apr  2 12:00:00.123 {tshark  } [tcp    ]: 192.168.0.2:40000 -> 192.168.0.1:23, 74 bytes
+Apr  2 12:00:01.000 {tshark  } [arp    ]: 00:11:22:33:44:55 -> ff:ff:ff:ff:ff:ff, 60 bytes
+Apr  2 12:00:02.500 {tshark  } [uds    ]: Security Access Request, Request Seed"
+	# Times are written in UTC regardless of the local time zone.
+	compstr "$(TZ=Europe/Berlin hr -o json --input-format "tshark-ek?fields=tcp.flags,can.id" hr/tshark.ek.json | jq -c '[.timestamp, .tshark]')" '["2020-04-02T12:00:00.123456Z",{"tcp.flags":"0x0002"}]
+["2020-04-02T12:00:01.000000Z",{}]
+["2020-04-02T12:00:02.500000Z",{"can.id":"0x7e0"}]'
+
+	run hr --input-format "tshark-ek?field=ip.src" hr/tshark.ek.json
+	[[ "$status" -eq 1 ]]
+}
+
+@test "select columns" {
+	compstr "$(hr --show-colors=false --columns "prio,target:23,component:0,data" hr/targets.log.json | sed -n "2p;6p")" "info      WVWZZZ1JZXW000001/0x7e0 {scanner}: probing
+warning   gateway                 {scanner}: telnet is open"
+	compstr "$(hr --show-colors=false --columns "data,type:0" hr/targets.log.json | sed -n "4p")" "seed is constant [finding]"
+
+	run hr --columns timestamp,component hr/targets.log.json
+	[[ "$status" -eq 1 ]]
+	run hr --columns data,severity hr/targets.log.json
+	[[ "$status" -eq 1 ]]
+}
+
+@test "save and replay the invocation" {
+	local out
+	local saved="$BATS_TMPDIR/invocation.json"
+
+	out="$(hr --save-invocation "$saved" --grep crash --input-token secret "${HRFLAGS[@]}" hr/phases.log.json)"
+	[[ "${out%%$'\n'*}" == *"[invocat]: invocation saved to $saved" ]]
+	compstr "$(jq -c .args "$saved")" '["--complen=8","--grep=crash","--input-token=REDACTED","--typelen=7"]'
+
+	compstr "$(hr --config "$saved" hr/phases.log.json)" "${out#*$'\n'}"
+	rm "$saved"
+}
+
+@test "emit checkpoints while reading" {
+	local out
+	local stats="$BATS_TMPDIR/stats.json"
+
+	out="$( (head -n 3 hr/example.log.json; sleep 0.5) | hr --stats-interval 100ms --stats-file "$stats" --show-colors=false "${HRFLAGS[@]}")"
+	[[ "$(sed -n 4p <<< "$out")" == *"{hr      } [stats  ]: 3 records ("*"), 0 errors, 1 components in 0s; 3 records in total" ]]
+	compstr "$(head -n 1 "$stats" | jq -c '[.type, .stats.records, .stats.errors, .stats.components.scanner.records, .stats.total_records]')" '["stats",3,0,3,3]'
+	# Later checkpoints only count the records since the previous one.
+	compstr "$(sed -n 2p "$stats" | jq -c '[.stats.records, .stats.total_records]')" '[0,3]'
+	rm "$stats"
+}
+
+@test "redact tokens in the invocation" {
+	local out
+	local saved="$BATS_TMPDIR/invocation.json"
+	local dir="$BATS_TMPDIR/sessions"
+	rm -rf "$dir"
+
+	out="$(hr --save-invocation "$saved" --session-log "$dir" --output json --input-token i-s3cr3t --serve "127.0.0.1:$((20000 + RANDOM % 10000))" --serve-token s3cr3t hr/phases.log.json)"
+	local log=("$dir"/hr-*.log)
+	compstr "$(jq -c '[.args[] | select(contains("token"))]' "$saved")" '["--input-token=REDACTED","--serve-token=REDACTED"]'
+	[[ "$(grep "^# args: " "${log[0]}")" == *"--serve-token=REDACTED"* ]]
+	! grep -q "s3cr3t" "$saved" "${log[0]}"
+	[[ "$out" != *"s3cr3t"* ]]
+	rm -r "$saved" "$dir"
+}
+
+@test "compact rendering on narrow terminals" {
+	command -v script > /dev/null || skip "script is required"
+	local out
+
+	out="$(script -qc "stty cols 40; hr --show-colors=false hr/phases.log.json" /dev/null | head -n 3 | tr -d '\r')"
+	compstr "$out" "12:00:00: scanne I before
+12:00:01: scanne N fuzzing started
+12:00:03: scanne E crash"
+
+	out="$(script -qc "stty cols 40; hr --narrow-width=0 --show-colors=false ${HRFLAGS[*]} hr/phases.log.json" /dev/null | head -n 1 | tr -d '\r')"
+	compstr "$out" "Apr  2 12:00:00.000 {scanner } [msg    ]: before"
+}
+
+@test "adapt to announced capabilities" {
+	run hr "${HRFLAGS[@]}" hr/capabilities.log.json
+	[[ "$status" -eq 0 ]]
+	[[ "${lines[0]}" == *"scanner uses version 2 of penlog(7), hr supports version 1"* ]]
+	[[ "${lines[1]}" == *"scanner uses extensions unknown to hr: quantum" ]]
+	[[ "${lines[2]}" == *"scanner signs its records; verify them with --verify-hmac" ]]
+	[[ "${lines[5]}" == *"{scanner } [message]: port 23 open" ]]
+	[[ "${lines[6]}" == *"{fuzzer  } [message]: H4sI"* ]]
+
+	run hr "${HRFLAGS[@]}" --decode-field data=base64 hr/capabilities.log.json
+	[[ "$status" -eq 0 ]]
+	[[ "$output" != *"as announced"* ]]
+	[[ "${lines[5]}" == *"[message]: 1f8b08"* ]]
+
+	run hr --validate hr/capabilities.log.json
+	[[ "$status" -eq 0 ]]
+}
+
+@test "detect concatenated captures" {
+	local out
+
+	out="$(TZ=UTC hr --check-stream --show-colors=false "${HRFLAGS[@]}" hr/mixed.log.json | grep '\[stream' | sed "s/^[^{]*//")"
+	compstr "$out" "{hr      } [stream ]: line 3: scanner uses version 2 of penlog(7), unlike previous records
+{hr      } [stream ]: line 3: run_id b follows run_id a
+{hr      } [stream ]: line 3: timestamp jumps by 10195h59m59s from 2020-04-02T12:00:01Z to 2021-06-01T08:00:00Z; time bases differ
+{hr      } [stream ]: line 5: run_id a continues after run_id b; runs are interleaved
+{hr      } [stream ]: the input mixes 2 spec versions (v1: 3 records, v2: 2 records), 2 run_ids (a: 3 records, b: 2 records) with 2 switches, 2 timestamp jumps over 24h0m0s; were several captures concatenated?"
+
+	out="$(hr --check-stream --time-jump=1000000h -o json hr/mixed.log.json | tail -n 1 | jq -c '[.run_ids, .timestamp_jumps]')"
+	compstr "$out" '[{"a":3,"b":2},null]'
+
+	out="$(hr --check-stream --show-colors=false "${HRFLAGS[@]}" hr/out-of-order.log.json | tail -n 1 | sed "s/^[^{]*//")"
+	compstr "$out" "{hr      } [stream ]: the input is consistent: one spec version, one run, and no timestamp jumps"
+}
+
+@test "fall back to stderr if a file output fails" {
+	[[ -w /dev/full ]] || skip "/dev/full is required"
+	local out
+
+	out="$(hr -f /dev/full hr/phases.log.json 2>&1 > /dev/null)" && false
+	compstr "$(head -n 1 <<< "$out" | jq -r .data)" "writing /dev/full failed: write /dev/full: no space left on device; writing its 6 unsynced and all following records to stderr"
+	compstr "$(tail -n +2 <<< "$out")" "$(hr -o json hr/phases.log.json)"
+
+	out="$(hr -f "/dev/full?format=hr" "${HRFLAGS[@]}" --show-colors=false hr/phases.log.json 2>&1 > /dev/null)" && false
+	compstr "$(tail -n +2 <<< "$out")" "$(hr "${HRFLAGS[@]}" --show-colors=false hr/phases.log.json)"
+}
+
+@test "export marked records of the TUI" {
+	command -v script > /dev/null || skip "script is required"
+
+	# Mark the first and the third record, then quit.
+	(sleep 1; printf 'gmjjm'; sleep 0.5; printf 'q') |
+		script -qc "stty cols 100 rows 20; hr -I --show-colors=false ${HRFLAGS[*]} --marks $BATS_TMPDIR/marks.txt hr/phases.log.json" /dev/null > /dev/null
+	compstr "$(tail -n +2 "$BATS_TMPDIR/marks.txt")" '
+# record 1
+Apr  2 12:00:00.000 {scanner } [msg    ]: before
+{"component":"scanner","data":"before","priority":6,"timestamp":"2020-04-02T12:00:00.000000","type":"msg"}
+
+# record 3
+Apr  2 12:00:03.250 {scanner } [msg    ]: crash
+{"component":"scanner","data":"crash","priority":3,"timestamp":"2020-04-02T12:00:03.250000","type":"msg"}'
+	rm "$BATS_TMPDIR/marks.txt"
+}
+
+@test "lift key=value lists into fields" {
+	compstr "$(hr --lift-kv "${HRFLAGS[@]}" --show-colors=false -f "kv.state=open:-" hr/kv.log.json)" \
+		"Apr  2 12:00:00.000 {scanner } [msg    ]: host=10.0.0.1 port=23 state=open banner"
+
+	compstr "$(hr --lift-kv -o json hr/kv.log.json | jq -c '[.kv, .data_list]')" '[{"host":"10.0.0.1","port":"23","state":"open"},["host=10.0.0.1","port=23","state=open","banner"]]
+[{"host":"10.0.0.2","port":"22","state":"closed"},["host=10.0.0.2","port=22","state=closed"]]
+[null,null]'
+
+	# Without --lift-kv, the lists stay untouched.
+	compstr "$(hr -o json hr/kv.log.json | jq -c '.data' | head -n 1)" '["host=10.0.0.1","port=23","state=open","banner"]'
+}
+
+@test "verify hash chained records" {
+	run hr --verify-hmac hr/hmac.key hr/signed.log.json
+	[ "$status" -eq 0 ]
+	[[ "$output" != *"[hmac"* ]]
+
+	# Canonical JSON of RFC8785: numbers, key order, and escapes.
+	run hr --verify-hmac hr/hmac.key hr/signed-jcs.log.json
+	[ "$status" -eq 0 ]
+	[[ "$output" != *"[hmac"* ]]
+
+	compstr "$(sed 2d hr/signed.log.json | hr -o json --verify-hmac hr/hmac.key 2> /dev/null | jq -r 'select(.type == "hmac") | .data')" \
+		"hmac_seq 1: gap in hash chain, 1 records missing"
+
+	compstr "$({ sed -n '1p;3p' hr/signed.log.json; sed -n '2p;4p' hr/signed.log.json; } | hr -o json --verify-hmac hr/hmac.key 2> /dev/null | jq -r 'select(.type == "hmac") | .data' | head -n 2)" \
+		"hmac_seq 1: gap in hash chain, 1 records missing
+hmac_seq 3: hash chain out of order, got sequence number 1"
+
+	compstr "$(sed 's/"done"/"DONE"/' hr/signed.log.json | hr -o json --verify-hmac hr/hmac.key 2> /dev/null | jq -r 'select(.type == "hmac") | .data')" \
+		"hmac_seq 2: hmac mismatch, record was modified"
+
+	run hr --verify-hmac hr/hmac.key hr/example.log.json
+	[ "$status" -eq 1 ]
+}
+
+@test "verify signed records before lifting key=value lists" {
+	run hr --lift-kv --verify-hmac hr/hmac.key "${HRFLAGS[@]}" --show-colors=false hr/signed.log.json
+	[ "$status" -eq 0 ]
+	[[ "$output" != *"hmac"* ]]
+	[[ "${lines[0]}" == *"host=10.0.0.1 port=23 state=open" ]]
+}
+
+@test "blame errors on preceding actions" {
+	compstr "$(TZ=UTC hr --blame-window 5s hr/blame.log.json)" "2020-04-02T12:00:11.000 target [crash]: segfault
+       2  fuzzer [send]: CCCC
+       1  fuzzer [reset]: reconnect
+2020-04-02T12:00:30.000 target [error]: timeout
+  no other components within 5s
+
+actions preceding 2 errors within 5s:
+         1  fuzzer [reset]
+         1  fuzzer [send]"
+
+	# The ping of the monitor only falls into a wider window.
+	run hr --blame-window 10s hr/blame.log.json
+	[[ "$output" == *"monitor [ping]: alive"* ]]
+
+	run hr --blame-window -1s hr/blame.log.json
+	[ "$status" -eq 1 ]
+}
+
+@test "show only changed fields" {
+	local input='{"timestamp": "2020-04-02T12:00:00.000000", "component": "ecu", "type": "status", "data": "st", "v": 1}
+{"timestamp": "2020-04-02T12:00:01.000000", "component": "ecu", "type": "status", "data": "st", "v": 2}
+{"timestamp": "2020-04-02T12:00:02.000000", "component": "ecu", "type": "status", "data": "st", "v": 2}'
+
+	compstr "$(hr --diff-fields "${HRFLAGS[@]}" --show-colors=false <<< "$input")" "Apr  2 12:00:00.000 {ecu     } [status ]: st
+Apr  2 12:00:01.000 {ecu     } [status ]: v=2
+Apr  2 12:00:02.000 {ecu     } [status ]: (unchanged)"
+
+	# Machine readable output keeps the records.
+	compstr "$(hr --diff-fields -o json <<< "$input" | jq -r .data)" "st
+st
+st"
+}
+
+@test "wait for a record" {
+	run hr "${HRFLAGS[@]}" --show-colors=false --wait-for "data=late" hr/out-of-order.log.json
+	[ "$status" -eq 0 ]
+	compstr "$output" "Apr  2 12:00:01.000 {b       } [msg    ]: late"
+
+	# The input ends without a match.
+	run hr --wait-for "data=missing" hr/out-of-order.log.json
+	[ "$status" -eq 1 ]
+	[ -z "$output" ]
+}
+
+@test "give up waiting after the timeout" {
+	local start
+
+	cp hr/out-of-order.log.json "$BATS_TMPDIR/timeout.log"
+	start="$(date +%s%N)"
+	run timeout 10 hr --follow --wait-for "data=missing" --timeout 1s "$BATS_TMPDIR/timeout.log"
+	[ "$status" -eq 124 ]
+	[[ "$output" == *"no matching record within 1s"* ]]
+	(( $(date +%s%N) - start < 5000000000 ))
+	rm "$BATS_TMPDIR/timeout.log"
+
+	run hr --timeout 1s hr/out-of-order.log.json
+	[ "$status" -eq 1 ]
+	compstr "$output" "error: --timeout requires --wait-for"
+}
+
+@test "follow a growing file" {
+	local pid
+	local status=0
+
+	cp hr/out-of-order.log.json "$BATS_TMPDIR/follow.log"
+	timeout 10 hr "${HRFLAGS[@]}" --show-colors=false --follow --wait-for "data=appended" --timeout 5s "$BATS_TMPDIR/follow.log" > "$BATS_TMPDIR/follow.out" &
+	pid="$!"
+	sleep 0.5
+	echo '{"timestamp": "2020-04-02T12:00:09.000000", "component": "a", "type": "msg", "data": "appended"}' >> "$BATS_TMPDIR/follow.log"
+	wait "$pid" || status="$?"
+	[ "$status" -eq 0 ]
+	compstr "$(< "$BATS_TMPDIR/follow.out")" "Apr  2 12:00:09.000 {a       } [msg    ]: appended"
+	rm "$BATS_TMPDIR/follow.log" "$BATS_TMPDIR/follow.out"
+}
+
+@test "render records with the templates of the config" {
+	local out
+	out="$(hr "${HRFLAGS[@]}" --show-colors=false --config hr/renderers.json hr/renderers.log.json)"
+	compstr "$out" "$(< hr/expected-renderers.log)"
+}
+
+@test "render records with the formatter of the config args" {
+	local out
+	echo '{"args": ["--show-colors=false", "--complen=8", "--typelen=7"], "renderers": [{"type": "read,write", "template": "{{hr (set . \"data\" (hex .data))}}"}]}' > "$BATS_TMPDIR/hr.json"
+	out="$(hr --config "$BATS_TMPDIR/hr.json" hr/renderers.log.json | grep uart)"
+	compstr "$out" "$(grep uart hr/expected-renderers.log)"
+	rm "$BATS_TMPDIR/hr.json"
+}
+
+@test "reject invalid render templates" {
+	echo '{"renderers": [{"type": "info"}]}' > "$BATS_TMPDIR/hr.json"
+	run hr --config "$BATS_TMPDIR/hr.json" hr/renderers.log.json
+	[ "$status" -eq 1 ]
+	[[ "$output" == *"renderer for ':info' has no template"* ]]
+
+	echo '{"renderers": [{"type": "info", "template": "{{.data"}]}' > "$BATS_TMPDIR/hr.json"
+	run hr --config "$BATS_TMPDIR/hr.json" hr/renderers.log.json
+	[ "$status" -eq 1 ]
+	[[ "$output" == *"unclosed action"* ]]
+	rm "$BATS_TMPDIR/hr.json"
+}
@@ -50,3 +50,38 @@ setup() {
 	rm "$BATS_TMPDIR/foo.log"
 	rm "$BATS_TMPDIR/foo1.log"
 }
+
+@test "export records as fixture" {
+	hr -f "type=crash:$BATS_TMPDIR/fixture.json?format=fixture" hr/fixture.log.json > /dev/null
+	compstr "$(cat "$BATS_TMPDIR/fixture.json")" '{"component":"scanner","data":"crash at 192.0.2.1","host":"host-1","priority":3,"timestamp":"2020-04-02T12:00:01.000000","type":"crash"}
+{"component":"scanner","data":"crash at 192.0.2.2 on host-1","host":"host-1","priority":3,"timestamp":"2020-04-02T12:00:02.000000","type":"crash"}'
+	rm "$BATS_TMPDIR/fixture.json"
+}
+
+@test "anonymize nested fields of fixtures" {
+	echo '{"timestamp": "2020-04-02T12:00:00.000000", "component": "scanner", "type": "error", "host": "lab", "data": "label of lab", "target": {"host": "10.1.2.3"}, "error": {"message": "connect 10.1.2.3:22 refused", "hops": ["lab", "10.1.2.4"]}}' |
+		hr -f "$BATS_TMPDIR/fixture.json?format=fixture" > /dev/null
+	compstr "$(cat "$BATS_TMPDIR/fixture.json")" '{"component":"scanner","data":"label of host-1","error":{"hops":["host-1","192.0.2.1"],"message":"connect 192.0.2.2:22 refused"},"host":"host-1","target":{"host":"192.0.2.2"},"timestamp":"2020-04-02T12:00:00.000000","type":"error"}'
+	rm "$BATS_TMPDIR/fixture.json"
+}
+
+@test "explain filter verdicts" {
+	local out
+
+	out="$(hr --explain=3 -p warning --grep crash -f "$BATS_TMPDIR/explain.log" --show-colors=false hr/phases.log.json | sed -n "s/^.*\[explain *\]: //p")"
+	compstr "$out" "line 1: dropped; --grep 'crash' rejected; priority info is above warning; written to $BATS_TMPDIR/explain.log
+line 2: dropped; --grep 'crash' rejected; priority notice is above warning; written to $BATS_TMPDIR/explain.log
+line 3: shown; --grep 'crash' matched; priority error is within warning; written to $BATS_TMPDIR/explain.log"
+	rm "$BATS_TMPDIR/explain.log"
+}
+
+@test "override the priority level per component" {
+	compstr "$(hr -p error --prio-override "FUZZ*=debug" --complen=8 --typelen=7 --show-colors=false hr/checks.log.json | sed "s/^[^{]*//")" "{scanner } [check  ]: check security-access failed
+{fuzzer  } [check  ]: check no-crash passed
+{fuzzer  } [msg    ]: crash at frame 4711"
+	compstr "$(hr --explain=4 -p error --prio-override "fuzz*=debug" hr/checks.log.json | sed -n "s/^.*\[explain *\]: //p" | tail -n 2)" "line 3: shown; priority error is within error
+line 4: shown; priority info is within debug of --prio-override"
+
+	run hr --prio-override "fuzzer" hr/checks.log.json
+	[ "$status" -eq 1 ]
+}
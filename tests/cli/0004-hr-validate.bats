@@ -0,0 +1,47 @@
+#!/usr/bin/env bats
+
+load lib-helpers
+
+@test "validate conforming records" {
+	run hr --validate hr/example.log.json hr/example-colors.log.json
+	[ "$status" -eq 0 ]
+}
+
+@test "validate records with violations" {
+	run hr --validate <<< '{"data": 1, "timestamp": "2020-04-23T15:21:50.620310", "type": "info"}'
+	[ "$status" -eq 1 ]
+	compstr "${lines[0]}" "<stdin>:1: data: expected string, got number"
+}
+
+@test "validate arbitrary data" {
+	run hr --validate <<< "hans"
+	[ "$status" -eq 1 ]
+	compstr "${lines[0]}" "<stdin>:1: json: not a valid JSON object"
+}
+
+@test "render records with unexpected field types" {
+	local out
+	out="$(hr --show-colors=false --show-lines --show-tags <<< '{"component": "py", "type": "msg", "data": "x", "line": 42, "tags": "a,b", "timestamp": "2020-04-23T15:21:50.620310"}')"
+	compstr "$out" "$(printf 'Apr 23 15:21:50.620 {py      } [msg     ]: x\n  => line: 42\n  => tags: a b ')"
+}
+
+@test "report unexpected field types in strict mode" {
+	run hr --show-colors=false --strict <<< '{"component": "py", "type": "msg", "data": "x", "line": 42, "timestamp": "2020-04-23T15:21:50.620310"}'
+	[ "$status" -eq 0 ]
+	[[ "${lines[0]}" == *"line 1: line: expected string, got number" ]]
+}
+
+@test "skip blank lines" {
+	local record='{"component": "a", "type": "msg", "data": "x", "timestamp": "2020-04-23T15:21:50.620310"}'
+
+	run hr --validate <<< "$(printf '%s\n\n  \n%s\n\n' "$record" "$record")"
+	[ "$status" -eq 0 ]
+
+	run hr --validate <<< "$(printf '%s\n\n{}' "$record")"
+	[ "$status" -eq 1 ]
+	compstr "${lines[0]}" "<stdin>:3: data: required field is missing"
+
+	run hr --show-colors=false <<< "$(printf '%s\n\n  \n%s\n' "$record" "$record")"
+	[ "$status" -eq 0 ]
+	[ "${#lines[@]}" -eq 2 ]
+}
@@ -0,0 +1,45 @@
+#!/usr/bin/env bats
+
+load lib-helpers
+
+HRFLAGS=("--complen=8" "--typelen=7" "--show-colors=false")
+
+@test "jq preprocessor equals pipeline" {
+	local out
+	local expected
+	out="$(hr "${HRFLAGS[@]}" --jq 'select(.type == "info")' hr/example.log.json)"
+	expected="$(jq -c 'select(.type == "info")' hr/example.log.json | hr "${HRFLAGS[@]}")"
+	compstr "$out" "$expected"
+}
+
+@test "jq compile error" {
+	run hr "${HRFLAGS[@]}" --jq '.[' hr/example.log.json
+	[ "$status" -eq 1 ]
+	[[ "${lines[0]}" == *"jq failed with status 3: jq: error: syntax error"* ]]
+}
+
+@test "jq exits early with pending input" {
+	run hr "${HRFLAGS[@]}" --jq 'halt_error' hr/example.log.json
+	[ "$status" -eq 1 ]
+	[[ "${lines[-1]}" == *"jq failed with status 5"* ]]
+}
+
+@test "jq is stopped after --until-match" {
+	run hr "${HRFLAGS[@]}" --jq '.' --until-match 'type=preamble' hr/example.log.json
+	[ "$status" -eq 3 ]
+	[[ "$output" != *"jq failed"* ]]
+}
+
+@test "embedded jq equals jq" {
+	local out
+	local expected
+	out="$(hr "${HRFLAGS[@]}" --jq-native --jq 'select(.type == "info") | .data |= ascii_upcase' hr/example.log.json)"
+	expected="$(hr "${HRFLAGS[@]}" --jq 'select(.type == "info") | .data |= ascii_upcase' hr/example.log.json)"
+	compstr "$out" "$expected"
+}
+
+@test "embedded jq runtime error" {
+	run hr "${HRFLAGS[@]}" --jq-native --jq 'error("bad")' hr/example.log.json
+	[ "$status" -eq 1 ]
+	[[ "${lines[0]}" == *"jq failed with status 5: jq: error (at <stdin>:1): bad" ]]
+}
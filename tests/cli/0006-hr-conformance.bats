@@ -0,0 +1,98 @@
+#!/usr/bin/env bats
+
+load lib-helpers
+
+# hr/conformance.log.json contains golden records as emitted by the
+# Python implementation; it covers all fields of penlog(7).
+HRFLAGS=("--complen=8" "--typelen=7" "--show-colors=false" "--show-lines" "--show-ids" "--show-tags" "--show-stacktraces")
+
+@test "golden records conform to the specification" {
+	run hr --validate hr/conformance.log.json
+	[ "$status" -eq 0 ]
+}
+
+@test "golden records to stdout" {
+	local out
+	out="$(hr "${HRFLAGS[@]}" hr/conformance.log.json)"
+	compstr "$out" "$(< hr/conformance.log)"
+}
+
+@test "golden records with the default columns" {
+	compstr "$(hr "${HRFLAGS[@]}" --columns timestamp,component,type,data hr/conformance.log.json)" "$(< hr/conformance.log)"
+}
+
+@test "golden records survive a round trip through a file" {
+	local out
+	hr -f "$BATS_TMPDIR/conformance.log" hr/conformance.log.json > /dev/null
+	out="$(cat "$BATS_TMPDIR/conformance.log")"
+	compjson "$out" "$(< hr/conformance.log.json)"
+	run hr --validate "$BATS_TMPDIR/conformance.log"
+	[ "$status" -eq 0 ]
+	rm "$BATS_TMPDIR/conformance.log"
+}
+
+@test "golden records survive a round trip through a compressed file" {
+	local out
+	hr -f "$BATS_TMPDIR/conformance.log.zst" hr/conformance.log.json > /dev/null
+	out="$(hr "${HRFLAGS[@]}" "$BATS_TMPDIR/conformance.log.zst")"
+	compstr "$out" "$(< hr/conformance.log)"
+	rm "$BATS_TMPDIR/conformance.log.zst"
+}
+
+@test "compressed stdin is detected by its magic number" {
+	compstr "$(zstd -c -q hr/conformance.log.json | hr "${HRFLAGS[@]}")" "$(< hr/conformance.log)"
+	compstr "$(gzip -c hr/conformance.log.json | hr "${HRFLAGS[@]}")" "$(< hr/conformance.log)"
+	compstr "$(gzip -c hr/conformance.log.json | hr --stats-format json --stats | jq .total)" "$(hr --stats-format json --stats hr/conformance.log.json | jq .total)"
+
+	run hr <<< $'\x1f\x8bnot gzip'
+	[ "$status" -eq 1 ]
+}
+
+@test "zstd options and checksums" {
+	command -v python3 > /dev/null || skip "python3 is required"
+	local out
+	hr -f "$BATS_TMPDIR/long.log.zst?long=1" -f "$BATS_TMPDIR/nosum.log.zst?checksum=0&windowlog=16" hr/conformance.log.json > /dev/null
+	compstr "$(hr "${HRFLAGS[@]}" "$BATS_TMPDIR/long.log.zst")" "$(< hr/conformance.log)"
+	compstr "$(hr "${HRFLAGS[@]}" "$BATS_TMPDIR/nosum.log.zst")" "$(< hr/conformance.log)"
+
+	# Flip a bit of the checksum at the end of the frame.
+	python3 -c 'import sys; b = bytearray(open(sys.argv[1], "rb").read()); b[-1] ^= 1; sys.stdout.buffer.write(b)' \
+		"$BATS_TMPDIR/long.log.zst" > "$BATS_TMPDIR/corrupt.log.zst"
+	out="$(hr -o json "$BATS_TMPDIR/corrupt.log.zst" | tail -n 1 | jq -r .data)"
+	compstr "$out" "$BATS_TMPDIR/corrupt.log.zst: zstd checksum mismatch, the file is corrupt"
+	rm "$BATS_TMPDIR/long.log.zst" "$BATS_TMPDIR/nosum.log.zst" "$BATS_TMPDIR/corrupt.log.zst"
+
+	run hr -f "$BATS_TMPDIR/out.log?long=1" hr/conformance.log.json
+	[ "$status" -eq 1 ]
+}
+
+@test "repair compressed files at sync points" {
+	local ext out
+
+	for ext in zst gz; do
+		hr --sync-records=2 -f "$BATS_TMPDIR/sync.log.$ext" hr/conformance.log.json > /dev/null
+		compstr "$(hr "${HRFLAGS[@]}" "$BATS_TMPDIR/sync.log.$ext")" "$(< hr/conformance.log)"
+
+		# Cut the last frame or member as if hr was killed.
+		head -c -5 "$BATS_TMPDIR/sync.log.$ext" > "$BATS_TMPDIR/cut.log.$ext"
+		out="$(hr --repair "$BATS_TMPDIR/cut.log.$ext")"
+		[[ "$out" == "$BATS_TMPDIR/cut.log.$ext: truncated from "* ]]
+		compstr "$(hr "${HRFLAGS[@]}" "$BATS_TMPDIR/cut.log.$ext")" "$(head -n 18 hr/conformance.log.json | hr "${HRFLAGS[@]}")"
+
+		out="$(hr --repair "$BATS_TMPDIR/cut.log.$ext")"
+		[[ "$out" == "$BATS_TMPDIR/cut.log.$ext: intact, "* ]]
+		rm "$BATS_TMPDIR/sync.log.$ext" "$BATS_TMPDIR/cut.log.$ext"
+	done
+
+	# Sync points are opt-in; by default a file is a single frame.
+	if command -v zstd > /dev/null; then
+		hr -f "$BATS_TMPDIR/single.log.zst" hr/conformance.log.json > /dev/null
+		[[ "$(zstd -l "$BATS_TMPDIR/single.log.zst" | awk 'NR == 2 { print $1 }')" -eq 1 ]]
+		rm "$BATS_TMPDIR/single.log.zst"
+	fi
+
+	printf '{"data":"complete"}\n{"data":' > "$BATS_TMPDIR/cut.log"
+	hr --repair "$BATS_TMPDIR/cut.log"
+	compstr "$(< "$BATS_TMPDIR/cut.log")" '{"data":"complete"}'
+	rm "$BATS_TMPDIR/cut.log"
+}
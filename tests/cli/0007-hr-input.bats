@@ -0,0 +1,347 @@
+#!/usr/bin/env bats
+
+load lib-helpers
+
+port=""
+server=""
+
+setup() {
+	command -v python3 > /dev/null || skip "python3 is required"
+	port="$((20000 + RANDOM % 10000))"
+	python3 -m http.server "$port" --bind 127.0.0.1 --directory hr > /dev/null 2>&1 &
+	server="$!"
+	for _ in {1..50}; do
+		if python3 -c "import socket; socket.create_connection(('127.0.0.1', $port))" 2> /dev/null; then
+			return
+		fi
+		sleep 0.1
+	done
+}
+
+teardown() {
+	if [[ -n "$server" ]]; then
+		kill "$server"
+	fi
+}
+
+@test "read NDJSON from an HTTP endpoint" {
+	# The stream is reopened after the end, hence --until-match.
+	run timeout 10 hr --show-colors=false --complen=8 --typelen=7 --input "http://127.0.0.1:$port/out-of-order.log.json" --until-match "data=late"
+	[[ "$status" -eq 3 ]]
+	compstr "$output" "$(hr --show-colors=false --complen=8 --typelen=7 hr/out-of-order.log.json)"
+}
+
+@test "resume NDJSON without duplicates" {
+	local dir="$BATS_TMPDIR/ndjson"
+	local ndjson_port="$((port + 10))"
+	local ndjson_server
+	local hr_pid
+	local status=0
+
+	rm -rf "$dir"
+	mkdir -p "$dir"
+	cp hr/out-of-order.log.json "$dir/records.log.json"
+	# http.server ignores ranges and sends the file from the start.
+	python3 -m http.server "$ndjson_port" --bind 127.0.0.1 --directory "$dir" > /dev/null 2>&1 &
+	ndjson_server="$!"
+	sleep 0.5
+
+	timeout 10 hr --show-colors=false --complen=8 --typelen=7 --input "http://127.0.0.1:$ndjson_port/records.log.json" --until-match "data=appended" > "$dir/out" &
+	hr_pid="$!"
+	sleep 1.5
+	echo '{"timestamp": "2020-04-02T12:00:09.000000", "component": "a", "type": "msg", "data": "appended"}' >> "$dir/records.log.json"
+	wait "$hr_pid" || status="$?"
+	kill "$ndjson_server"
+	[[ "$status" -eq 3 ]]
+	compstr "$(grep -v "\[input  \]" "$dir/out")" "$(hr --show-colors=false --complen=8 --typelen=7 hr/out-of-order.log.json)
+Apr  2 12:00:09.000 {a       } [msg    ]: appended"
+	rm -rf "$dir"
+}
+
+@test "stop on client errors of the HTTP endpoint" {
+	run timeout 10 hr --show-colors=false --input "http://127.0.0.1:$port/missing.log.json"
+	[[ "$status" -eq 1 ]]
+	[[ "$output" == *"[input   ]: reading http://127.0.0.1:$port/missing.log.json failed: unexpected status: 404"* ]]
+}
+
+@test "reject unsupported input urls" {
+	run hr --input "ftp://127.0.0.1/log.json"
+	[[ "$status" -eq 1 ]]
+}
+
+@test "serve the stream to another hr instance" {
+	local serve_port="$((port + 1))"
+	local serve_pid
+
+	(sleep 1; cat hr/out-of-order.log.json; sleep 1) | hr --serve "127.0.0.1:$serve_port" > /dev/null &
+	serve_pid="$!"
+	sleep 0.5
+
+	run timeout 10 hr --show-colors=false --complen=8 --typelen=7 --input "http://127.0.0.1:$serve_port/" --until-match "data=late"
+	wait "$serve_pid"
+	[[ "$status" -eq 3 ]]
+	compstr "$output" "$(hr --show-colors=false --complen=8 --typelen=7 hr/out-of-order.log.json)"
+}
+
+@test "publish records on the control socket" {
+	local sock="$BATS_TMPDIR/hr-control.sock"
+	local hr_pid
+
+	(sleep 1; cat hr/out-of-order.log.json) | hr --control-socket "$sock" -f "data=late:-" > /dev/null &
+	hr_pid="$!"
+	sleep 0.5
+	[[ "$(stat -c %a "$sock")" == 600 ]]
+
+	run timeout 10 python3 -c 'import socket, sys
+s = socket.socket(socket.AF_UNIX)
+s.connect(sys.argv[1])
+for line in s.makefile():
+    sys.stdout.write(line)' "$sock"
+	wait "$hr_pid"
+	compstr "$(jq -c '[.event, .seq, .record.data]' <<< "$output")" '["hello",null,null]
+["record",1,"late"]
+["end",1,null]'
+	[[ ! -e "$sock" ]]
+}
+
+@test "require a token to serve the stream" {
+	local serve_port="$((port + 7))"
+	local serve_pid
+
+	(sleep 1; cat hr/out-of-order.log.json; sleep 1) | SERVE_TOKEN=s3cret hr --serve "127.0.0.1:$serve_port" --serve-token env:SERVE_TOKEN > /dev/null &
+	serve_pid="$!"
+	sleep 0.5
+
+	run timeout 10 hr --show-colors=false --input "http://127.0.0.1:$serve_port/"
+	[[ "$status" -eq 1 ]]
+	[[ "$output" == *"unexpected status: 401"* ]]
+
+	run timeout 10 hr --show-colors=false --complen=8 --typelen=7 --input "http://127.0.0.1:$serve_port/" --input-token s3cret --until-match "data=late"
+	wait "$serve_pid"
+	[[ "$status" -eq 3 ]]
+	compstr "$output" "$(hr --show-colors=false --complen=8 --typelen=7 hr/out-of-order.log.json)"
+
+	# Other addresses than loopback need authentication.
+	run hr --serve ":$serve_port" < /dev/null
+	[[ "$status" -eq 1 ]]
+	[[ "$output" == *"requires --serve-token"* ]]
+}
+
+@test "pace the served stream" {
+	local serve_port="$((port + 5))"
+	local serve_pid
+	local start
+
+	(sleep 1; cat hr/out-of-order.log.json; sleep 0.1) | hr --serve "127.0.0.1:$serve_port" --max-rate 2/s > /dev/null &
+	serve_pid="$!"
+	sleep 0.5
+
+	start="$(date +%s%N)"
+	run timeout 10 hr --show-colors=false --complen=8 --typelen=7 --input "http://127.0.0.1:$serve_port/" --until-match "data=late"
+	wait "$serve_pid"
+	[[ "$status" -eq 3 ]]
+	compstr "$output" "$(hr --show-colors=false --complen=8 --typelen=7 hr/out-of-order.log.json)"
+	# The last of four records at 2/s follows the first one after 1.5s.
+	(( $(date +%s%N) - start >= 1500000000 ))
+}
+
+@test "drop the spool of the paced stream on signals" {
+	local serve_pid
+
+	(cat hr/out-of-order.log.json; sleep 5) | hr --serve "127.0.0.1:$((port + 6))" --max-rate 1/m > /dev/null 2> "$BATS_TMPDIR/pacer.err" &
+	serve_pid="$!"
+	sleep 1
+
+	kill -TERM "$serve_pid"
+	# The handler waits for 1s, but not for the spool at 1/m.
+	for _ in {1..30}; do
+		kill -0 "$serve_pid" 2> /dev/null || break
+		sleep 0.1
+	done
+	! kill -0 "$serve_pid" 2> /dev/null
+	compstr "$(< "$BATS_TMPDIR/pacer.err")" "warning: dropped 3 records spooled by --max-rate"
+	rm "$BATS_TMPDIR/pacer.err"
+}
+
+@test "abort draining the spool on signals" {
+	local serve_pid
+
+	hr --serve "127.0.0.1:$((port + 8))" --max-rate 1/m < hr/out-of-order.log.json > /dev/null 2> "$BATS_TMPDIR/drain.err" &
+	serve_pid="$!"
+	sleep 0.5
+
+	# The input has ended; hr drains the spool at 1/m.
+	kill -INT "$serve_pid"
+	for _ in {1..30}; do
+		kill -0 "$serve_pid" 2> /dev/null || break
+		sleep 0.1
+	done
+	! kill -0 "$serve_pid" 2> /dev/null
+	compstr "$(< "$BATS_TMPDIR/drain.err")" "warning: dropped 3 records spooled by --max-rate"
+	rm "$BATS_TMPDIR/drain.err"
+}
+
+@test "serve the epilogue of --max-duration past the spool" {
+	local serve_port="$((port + 9))"
+	local serve_pid
+
+	(sleep 1; cat hr/out-of-order.log.json; sleep 5) | hr --serve "127.0.0.1:$serve_port" --max-rate 1/m --max-duration 2s > /dev/null 2>&1 &
+	serve_pid="$!"
+	sleep 0.5
+
+	run timeout 10 hr --show-colors=false --input "http://127.0.0.1:$serve_port/" --until-match "type=limit"
+	wait "$serve_pid" || true
+	[[ "$status" -eq 3 ]]
+	[[ "${#lines[@]}" -eq 2 ]]
+	[[ "${lines[1]}" == *"time limit of 2s reached; stopping" ]]
+}
+
+@test "reject invalid rates" {
+	run hr --serve "127.0.0.1:$((port + 5))" --max-rate 2/d
+	[[ "$status" -eq 1 ]]
+	run hr --max-rate 2/s
+	[[ "$status" -eq 1 ]]
+}
+
+@test "collect records of authenticated clients" {
+	command -v curl > /dev/null || skip "curl is required"
+	local listen_port="$((port + 2))"
+	local dir="$BATS_TMPDIR/collect"
+	local listen_pid
+
+	rm -rf "$dir"
+	mkdir -p "$dir"
+	echo '{"clients": [{"name": "alice", "token": "env:ALICE_TOKEN", "filters": ["comp=a:a.log"]}, {"name": "bob", "token": "file:'"$dir"'/bob.token"}]}' > "$dir/hr.json"
+	echo "t-bob" > "$dir/bob.token"
+	ALICE_TOKEN="t-alice" hr --config "$dir/hr.json" --listen "127.0.0.1:$listen_port" --collect-dir "$dir/out" > "$dir/stdout" &
+	listen_pid="$!"
+	sleep 0.5
+
+	run curl -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer t-alice" --data-binary @hr/out-of-order.log.json "http://127.0.0.1:$listen_port/"
+	compstr "$output" "204"
+	run curl -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer t-bob" --data-binary @hr/out-of-order.log.json "http://127.0.0.1:$listen_port/"
+	compstr "$output" "204"
+	run curl -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer t-eve" --data-binary @hr/out-of-order.log.json "http://127.0.0.1:$listen_port/"
+	compstr "$output" "401"
+
+	# Oversized records and bodies are rejected.
+	printf '{"component": "b", "type": "msg", "data": "%s"}\n' "$(head -c 1100000 /dev/zero | tr '\0' x)" > "$dir/large.json"
+	run curl -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer t-bob" --data-binary @"$dir/large.json" "http://127.0.0.1:$listen_port/"
+	compstr "$output" "413"
+	head -c 68000000 /dev/zero | tr '\0' '\n' > "$dir/large.json"
+	run curl -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer t-bob" --data-binary @"$dir/large.json" "http://127.0.0.1:$listen_port/"
+	compstr "$output" "413"
+
+	kill -TERM "$listen_pid"
+	wait "$listen_pid" || true
+	compstr "$(jq -r .client "$dir/out/alice/a.log" | uniq)" "alice"
+	compstr "$(jq -r .component "$dir/out/alice/a.log" | uniq)" "a"
+	compstr "$(jq -r .data "$dir/out/bob/records.log.json")" "$(jq -r .data hr/out-of-order.log.json)"
+	rm -rf "$dir"
+}
+
+@test "serve and collect via mutual TLS" {
+	command -v openssl > /dev/null || skip "openssl is required"
+	command -v curl > /dev/null || skip "curl is required"
+	local tls_port="$((port + 3))"
+	local dir="$BATS_TMPDIR/tls"
+	local listen_pid
+
+	rm -rf "$dir"
+	mkdir -p "$dir"
+	openssl req -x509 -newkey rsa:2048 -nodes -keyout "$dir/ca.key" -out "$dir/ca.pem" -days 1 -subj "/CN=test ca" 2> /dev/null
+	openssl req -newkey rsa:2048 -nodes -keyout "$dir/server.key" -out "$dir/server.csr" -subj "/CN=localhost" 2> /dev/null
+	echo "subjectAltName=IP:127.0.0.1" > "$dir/ext.cnf"
+	openssl x509 -req -in "$dir/server.csr" -CA "$dir/ca.pem" -CAkey "$dir/ca.key" -CAcreateserial -out "$dir/server.pem" -days 1 -extfile "$dir/ext.cnf" 2> /dev/null
+	openssl req -newkey rsa:2048 -nodes -keyout "$dir/client.key" -out "$dir/client.csr" -subj "/CN=alice" 2> /dev/null
+	openssl x509 -req -in "$dir/client.csr" -CA "$dir/ca.pem" -CAkey "$dir/ca.key" -CAcreateserial -out "$dir/client.pem" -days 1 2> /dev/null
+
+	echo '{"clients": [{"name": "alice", "cn": "alice"}]}' > "$dir/hr.json"
+	hr --config "$dir/hr.json" --listen "127.0.0.1:$tls_port" --collect-dir "$dir/out" \
+		--tls-cert "$dir/server.pem" --tls-key "$dir/server.key" --tls-client-ca "$dir/ca.pem" > /dev/null 2>&1 &
+	listen_pid="$!"
+	sleep 0.5
+
+	run curl -s -o /dev/null -w '%{http_code}' --cacert "$dir/ca.pem" --cert "$dir/client.pem" --key "$dir/client.key" \
+		--data-binary @hr/out-of-order.log.json "https://127.0.0.1:$tls_port/"
+	compstr "$output" "204"
+	# Without a client certificate the handshake fails.
+	run curl -s --cacert "$dir/ca.pem" --data-binary @hr/out-of-order.log.json "https://127.0.0.1:$tls_port/"
+	[[ "$status" -ne 0 ]]
+
+	kill -TERM "$listen_pid"
+	wait "$listen_pid" || true
+	compstr "$(jq -r .data "$dir/out/alice/records.log.json")" "$(jq -r .data hr/out-of-order.log.json)"
+
+	run hr --serve "127.0.0.1:$tls_port" --tls-client-ca "$dir/ca.pem" < /dev/null
+	[[ "$status" -eq 1 ]]
+	[[ "$output" == *"--tls-client-ca requires --tls-cert"* ]]
+	rm -rf "$dir"
+}
+
+@test "timestamp file outputs by a time-stamping authority" {
+	command -v openssl > /dev/null || skip "openssl is required"
+	local tsa_port="$((port + 4))"
+	local dir="$BATS_TMPDIR/tsa"
+	local tsa_pid
+
+	rm -rf "$dir"
+	mkdir -p "$dir"
+	openssl req -x509 -newkey rsa:2048 -nodes -keyout "$dir/ca.key" -out "$dir/ca.pem" -days 1 -subj "/CN=test ca" 2> /dev/null
+	openssl req -newkey rsa:2048 -nodes -keyout "$dir/tsa.key" -out "$dir/tsa.csr" -subj "/CN=test tsa" 2> /dev/null
+	echo "extendedKeyUsage=critical,timeStamping" > "$dir/ext.cnf"
+	openssl x509 -req -in "$dir/tsa.csr" -CA "$dir/ca.pem" -CAkey "$dir/ca.key" -CAcreateserial -out "$dir/tsa.pem" -days 1 -extfile "$dir/ext.cnf" 2> /dev/null
+	echo 01 > "$dir/serial"
+	cat > "$dir/tsa.cnf" <<-END
+		[ tsa ]
+		default_tsa = tsa_config
+		[ tsa_config ]
+		serial = $dir/serial
+		signer_cert = $dir/tsa.pem
+		signer_key = $dir/tsa.key
+		signer_digest = sha256
+		default_policy = 1.2.3.4.1
+		digests = sha256
+		ess_cert_id_alg = sha256
+	END
+	# A minimal RFC 3161 server on top of openssl ts.
+	cat > "$dir/tsa.py" <<-END
+		import http.server, subprocess, sys
+		class Handler(http.server.BaseHTTPRequestHandler):
+		    def do_POST(self):
+		        query = self.rfile.read(int(self.headers["Content-Length"]))
+		        reply = subprocess.run(["openssl", "ts", "-reply", "-config", sys.argv[2], "-queryfile", "/dev/stdin", "-out", "/dev/stdout"],
+		                               input=query, stdout=subprocess.PIPE, stderr=subprocess.DEVNULL).stdout
+		        self.send_response(200)
+		        self.send_header("Content-Type", "application/timestamp-reply")
+		        self.end_headers()
+		        self.wfile.write(reply)
+		    def log_message(self, *args):
+		        pass
+		http.server.HTTPServer(("127.0.0.1", int(sys.argv[1])), Handler).serve_forever()
+	END
+	python3 "$dir/tsa.py" "$tsa_port" "$dir/tsa.cnf" &
+	tsa_pid="$!"
+	sleep 0.5
+
+	hr --tsa "http://127.0.0.1:$tsa_port/" --tsa-interval=0 --sync-records=2 -f "$dir/out.log.zst" hr/out-of-order.log.json > /dev/null
+	kill "$tsa_pid"
+	compstr "$(wc -l < "$dir/out.log.zst.tsa.json")" "2"
+
+	run hr --verify-timestamps --tsa-ca "$dir/ca.pem" "$dir/out.log.zst"
+	[[ "$status" -eq 0 ]]
+	[[ "${lines[1]}" == "$dir/out.log.zst: $(hr -o json "$dir/out.log.zst" | wc -c) bytes existed at "* ]]
+
+	# Without the CA, the signer is not trusted.
+	run hr --verify-timestamps "$dir/out.log.zst"
+	[[ "$status" -eq 1 ]]
+	[[ "${lines[0]}" == *"untrusted signer \"test tsa\""* ]]
+
+	# Modified records break the timestamps.
+	hr -o json "$dir/out.log.zst" | sed '1s/one/eno/' > "$dir/modified.log"
+	cp "$dir/out.log.zst.tsa.json" "$dir/modified.log.tsa.json"
+	run hr --verify-timestamps --tsa-ca "$dir/ca.pem" "$dir/modified.log"
+	[[ "$status" -eq 1 ]]
+	compstr "${lines[0]}" "$dir/modified.log: timestamp 1: the token covers a different digest"
+	rm -rf "$dir"
+}